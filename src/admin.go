@@ -0,0 +1,579 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	// Names of the subcommands to operate the cluster by hand
+	StatusCommand  = "status"
+	DrainCommand   = "drain"
+	UnboostCommand = "unboost"
+	PauseCommand   = "pause"
+	ResumeCommand  = "resume"
+
+	// Targets of the pause and resume subcommands
+	PauseTargetBoosting         = "boosting"
+	PauseTargetDraining         = "draining"
+	PauseTargetAutoscalingGroup = "asg"
+
+	// ManualDrainEventReason is the reason of the event crafted for the nodes drained by hand without rebalance recommendation.
+	// It is never stored on Kubernetes, so deleting it once the instance is terminated does nothing
+	ManualDrainEventReason = "ManualDrain"
+
+	// TerminationWaitMargin is the time waited for a termination, over the time waited for AWS to confirm it
+	TerminationWaitMargin = time.Minute
+
+	// Info messages
+	ManualDrainDryRunMessage   = "dry-run: the node '%s' would be drained and its instance '%s' terminated\n"
+	ManualDrainDoneMessage     = "node '%s' drained and its instance '%s' terminated\n"
+	UnboostNothingMessage      = "desired capacity of asg '%s' is already '%d', nothing to unboost\n"
+	UnboostDryRunMessage       = "dry-run: desired capacity of asg '%s' would change from '%d' to '%d'\n"
+	UnboostDoneMessage         = "desired capacity of asg '%s' changed from '%d' to '%d'\n"
+	UnboostPauseHintMessage    = "the controller can boost it again: pause it with '%s %s %s' to prevent it\n"
+	PauseControlDryRunMessage  = "dry-run: %s would be %s\n"
+	PauseControlDoneMessage    = "%s %s\n"
+	StatusPausedProcessMessage = "%s paused: %t\n"
+
+	// Error messages
+	CommandUsageErrorMessage             = "usage: %s"
+	CommandErrorMessage                  = "%s failed: %v"
+	NodeNotFoundErrorMessage             = "node '%s' not found in the cluster"
+	AutoscalingGroupNotFoundErrorMessage = "asg '%s' not found in cluster-autoscaler's status"
+	TerminationNotFinishedErrorMessage   = "termination of the instance '%s' not finished after %s"
+	TerminationGivenUpAdminErrorMessage  = "termination of the instance '%s' given up (%s), the node stays drained"
+)
+
+// NodegroupStatus represents the state of a nodegroup shown by the status subcommand
+type NodegroupStatus struct {
+	Nodegroup              string `json:"nodegroup"`
+	AutoscalingGroup       string `json:"autoscalingGroup"`
+	Nodes                  int    `json:"nodes"`
+	Ready                  int    `json:"ready"`
+	Cordoned               int    `json:"cordoned"`
+	RecentlyReady          int    `json:"recentlyReady"`
+	Events                 int    `json:"events"`
+	DesiredCapacity        int    `json:"desiredCapacity"`
+	MaxCapacity            int    `json:"maxCapacity"`
+	BoostedNodes           int    `json:"boostedNodes"`
+	AutoscalingGroupPaused bool   `json:"autoscalingGroupPaused"`
+}
+
+// NodeUnderRiskStatus represents a node under risk shown by the status subcommand
+type NodeUnderRiskStatus struct {
+	Node              string    `json:"node"`
+	Nodegroup         string    `json:"nodegroup"`
+	Instance          string    `json:"instance"`
+	EventTimestamp    time.Time `json:"eventTimestamp"`
+	Cordoned          bool      `json:"cordoned"`
+	ExcludedFromDrain bool      `json:"excludedFromDrain"`
+}
+
+// ClusterStatus represents the whole output of the status subcommand
+type ClusterStatus struct {
+	BoostingPaused bool                  `json:"boostingPaused"`
+	DrainingPaused bool                  `json:"drainingPaused"`
+	Nodegroups     []NodegroupStatus     `json:"nodegroups"`
+	NodesUnderRisk []NodeUnderRiskStatus `json:"nodesUnderRisk"`
+}
+
+// newCommandFlagSet return a flag set for a subcommand with the flags of the controller already registered,
+// so the subcommands find the cluster, AWS and the controls the same way the controller does
+func newCommandFlagSet(name string) (*flag.FlagSet, *ControllerFlags) {
+	flagSet := flag.NewFlagSet(name, flag.ExitOnError)
+	return flagSet, RegisterControllerFlags(flagSet)
+}
+
+// newCommandContext return the context and the clients used by the subcommands.
+// Logs are written in a human-readable way into stderr, keeping stdout for the results
+func newCommandContext(flags *ControllerFlags) (ctx *Ctx, client kubernetes.Interface, awsClient *AwsClient, err error) {
+
+	loggerConfig := zap.NewDevelopmentConfig()
+	loggerConfig.DisableCaller = true
+	loggerConfig.DisableStacktrace = true
+	loggerConfig.Level.SetLevel(zap.InfoLevel)
+
+	logger, err := loggerConfig.Build()
+	if err != nil {
+		return ctx, client, awsClient, err
+	}
+
+	ctx = &Ctx{
+		Ctx:    context.Background(),
+		Logger: logger.Sugar(),
+		Flags:  flags,
+		Traces: NewNodeTracePool(),
+	}
+
+	ctx.Audit, err = NewAuditLogger(*flags.AuditLogPath, *flags.AuditLogMaxSizeMB, *flags.AuditLogMaxBackups)
+	if err != nil {
+		return ctx, client, awsClient, err
+	}
+
+	client, err = GetKubernetesClient(*flags.ConnectionMode, *flags.Kubeconfig)
+	if err != nil {
+		return ctx, client, awsClient, err
+	}
+	ctx.Recorder = NewEventRecorder(client, *flags.KubernetesEventsBurst, float32(*flags.KubernetesEventsQPS))
+
+	awsClient, err = AwsCreateSession(GetAwsRetryPolicy(flags))
+	return ctx, client, awsClient, err
+}
+
+// exitOnCommandError print the error of a subcommand and exit, when there is one
+func exitOnCommandError(command string, err error) {
+	if err == nil {
+		return
+	}
+	fmt.Fprintf(os.Stderr, CommandErrorMessage+"\n", command, err)
+	os.Exit(1)
+}
+
+// exitWithCommandUsage print how to use a subcommand and exit
+func exitWithCommandUsage(flagSet *flag.FlagSet, usage string) {
+	fmt.Fprintf(os.Stderr, CommandUsageErrorMessage+"\n", usage)
+	flagSet.PrintDefaults()
+	os.Exit(2)
+}
+
+// TakeClusterSnapshot return the inputs of the controller read at once from the cluster and AWS,
+// instead of being collected by the watchers
+func TakeClusterSnapshot(ctx *Ctx, client kubernetes.Interface, awsClient *AwsClient) (snapshot Snapshot, err error) {
+
+	snapshot.Version = SnapshotFormatVersion
+	snapshot.Timestamp = time.Now().UTC()
+
+	nodeList, err := client.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return snapshot, err
+	}
+	snapshot.Nodes = nodeList.Items
+
+	eventList, err := client.CoreV1().Events("default").List(context.TODO(), metav1.ListOptions{
+		FieldSelector: fmt.Sprintf("reason=%s", RebalanceEvent),
+	})
+	if err != nil {
+		return snapshot, err
+	}
+	snapshot.Events = eventList.Items
+
+	configmap, err := client.CoreV1().ConfigMaps(*ctx.Flags.CAStatusNamespace).Get(context.TODO(), *ctx.Flags.CAConfigmapName, metav1.GetOptions{})
+	if err != nil {
+		return snapshot, err
+	}
+	snapshot.ClusterAutoscalerStatus = configmap.Data["status"]
+
+	snapshot.AutoscalingGroupTags = map[string]map[string]string{}
+	autoscalingGroupNames := ParseAutoscalingGroupsNames(snapshot.ClusterAutoscalerStatus)
+	if len(autoscalingGroupNames) == 0 {
+		return snapshot, nil
+	}
+
+	snapshot.AutoscalingGroupTags, err = AwsDescribeAutoScalingGroupsTags(awsClient, autoscalingGroupNames)
+	if err != nil {
+		return snapshot, err
+	}
+
+	return snapshot, nil
+}
+
+// GetClusterStatus return the state of the nodegroups, the nodes under risk and the boosts found in the pools
+func GetClusterStatus(ctx *Ctx, nodePool *NodePool, eventPool *EventPool, autoscalingGroupPool *AutoscalingGroupPool,
+	desiredCapacities map[string]int) (clusterStatus ClusterStatus) {
+
+	aggregate := NewNodegroupsAggregate(eventPool, nodePool, *ctx.Flags.MaxTimeConsiderNewNodes, false)
+	asgsMaxCapacity, _ := GetAutoscalingGroupsMaxCapacity(autoscalingGroupPool)
+
+	for _, autoscalingGroup := range autoscalingGroupPool.Snapshot() {
+		nodegroupName := autoscalingGroup.Tags[AWSAutoscalingGroupsNodeGroupTag]
+
+		nodegroupStatus := NodegroupStatus{
+			Nodegroup:              nodegroupName,
+			AutoscalingGroup:       autoscalingGroup.Name,
+			Nodes:                  aggregate.NodesCount[nodegroupName],
+			Cordoned:               aggregate.CordonedNodesCount[nodegroupName],
+			RecentlyReady:          aggregate.RecentlyReadyCount[nodegroupName],
+			Events:                 aggregate.EventsCount[nodegroupName],
+			DesiredCapacity:        desiredCapacities[autoscalingGroup.Name],
+			MaxCapacity:            asgsMaxCapacity[autoscalingGroup.Name],
+			AutoscalingGroupPaused: autoscalingGroup.Tags[PausedTag] == PausedTagValue,
+		}
+		nodegroupStatus.Ready, _ = strconv.Atoi(autoscalingGroup.Health.Ready)
+
+		// Boosted nodes are those requested over the ready ones
+		if nodegroupStatus.DesiredCapacity > nodegroupStatus.Ready {
+			nodegroupStatus.BoostedNodes = nodegroupStatus.DesiredCapacity - nodegroupStatus.Ready
+		}
+
+		clusterStatus.Nodegroups = append(clusterStatus.Nodegroups, nodegroupStatus)
+	}
+	sort.Slice(clusterStatus.Nodegroups, func(i, j int) bool {
+		return clusterStatus.Nodegroups[i].Nodegroup < clusterStatus.Nodegroups[j].Nodegroup
+	})
+
+	for _, event := range eventPool.Snapshot() {
+		nodeUnderRiskStatus := NodeUnderRiskStatus{
+			Node:           event.InvolvedObject.Name,
+			EventTimestamp: event.CreationTimestamp.Time,
+		}
+
+		if node, found := nodePool.Get(event.InvolvedObject.Name); found {
+			nodeUnderRiskStatus.Nodegroup = node.Labels[AWSNodeGroupLabel]
+			nodeUnderRiskStatus.Instance = GetInstanceIdFromProviderID(node.Spec.ProviderID)
+			nodeUnderRiskStatus.Cordoned = node.Spec.Unschedulable
+			nodeUnderRiskStatus.ExcludedFromDrain = node.Annotations[ExcludeFromDrainAnnotation] == ExcludeFromDrainAnnotationValue
+		}
+
+		clusterStatus.NodesUnderRisk = append(clusterStatus.NodesUnderRisk, nodeUnderRiskStatus)
+	}
+	sort.Slice(clusterStatus.NodesUnderRisk, func(i, j int) bool {
+		return clusterStatus.NodesUnderRisk[i].EventTimestamp.Before(clusterStatus.NodesUnderRisk[j].EventTimestamp)
+	})
+
+	return clusterStatus
+}
+
+// printClusterStatusTable print the state of the cluster as human-readable tables
+func printClusterStatusTable(clusterStatus ClusterStatus) {
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer writer.Flush()
+
+	fmt.Fprintf(writer, StatusPausedProcessMessage, PauseTargetBoosting, clusterStatus.BoostingPaused)
+	fmt.Fprintf(writer, StatusPausedProcessMessage, PauseTargetDraining, clusterStatus.DrainingPaused)
+	fmt.Fprintln(writer)
+
+	fmt.Fprintln(writer, "NODEGROUP\tASG\tNODES\tREADY\tCORDONED\tRECENTLY READY\tEVENTS\tDESIRED\tMAX\tBOOSTED\tPAUSED")
+	for _, nodegroup := range clusterStatus.Nodegroups {
+		fmt.Fprintf(writer, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%t\n",
+			nodegroup.Nodegroup, nodegroup.AutoscalingGroup, nodegroup.Nodes, nodegroup.Ready, nodegroup.Cordoned,
+			nodegroup.RecentlyReady, nodegroup.Events, nodegroup.DesiredCapacity, nodegroup.MaxCapacity,
+			nodegroup.BoostedNodes, nodegroup.AutoscalingGroupPaused)
+	}
+	fmt.Fprintln(writer)
+
+	fmt.Fprintln(writer, "NODE UNDER RISK\tNODEGROUP\tINSTANCE\tEVENT AGE\tCORDONED\tEXCLUDED FROM DRAIN")
+	for _, node := range clusterStatus.NodesUnderRisk {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%t\t%t\n",
+			node.Node, node.Nodegroup, node.Instance, time.Since(node.EventTimestamp).Round(time.Second),
+			node.Cordoned, node.ExcludedFromDrain)
+	}
+}
+
+// RunStatusCommand print the nodegroups, the nodes under risk and the boosts of the cluster
+// Usage: aws-spots-booster status [--output table|json] [controller flags]
+func RunStatusCommand(args []string) {
+
+	flagSet, flags := newCommandFlagSet(StatusCommand)
+	output := flagSet.String("output", SimulationOutputTable, "format of the status: table, json")
+	_ = flagSet.Parse(args)
+
+	if *output != SimulationOutputTable && *output != SimulationOutputJSON {
+		exitWithCommandUsage(flagSet, "aws-spots-booster status [--output table|json] [flags]")
+	}
+
+	ctx, client, awsClient, err := newCommandContext(flags)
+	exitOnCommandError(StatusCommand, err)
+
+	err = func() error {
+		snapshot, err := TakeClusterSnapshot(ctx, client, awsClient)
+		if err != nil {
+			return err
+		}
+
+		nodePool, eventPool, autoscalingGroupPool, err := NewPoolsFromSnapshot(&snapshot)
+		if err != nil {
+			return err
+		}
+
+		desiredCapacities, err := AwsGetAutoScalingGroupsDesiredCapacity(awsClient, GetAutoscalingGroupsNames(autoscalingGroupPool))
+		if err != nil {
+			return err
+		}
+
+		clusterStatus := GetClusterStatus(ctx, nodePool, eventPool, autoscalingGroupPool, desiredCapacities)
+
+		pauseControls := GetPauseControls(ctx, client)
+		clusterStatus.BoostingPaused = pauseControls.Boosting
+		clusterStatus.DrainingPaused = pauseControls.Draining
+
+		if *output == SimulationOutputJSON {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(clusterStatus)
+		}
+
+		printClusterStatusTable(clusterStatus)
+		return nil
+	}()
+
+	exitOnCommandError(StatusCommand, err)
+}
+
+// getNodeDrainEvent return the rebalance recommendation of a node, or a new event when it has none,
+// so the node is drained and terminated the same way the nodes under risk are
+func getNodeDrainEvent(eventPool *EventPool, nodeName string) *v1.Event {
+
+	if event, found := eventPool.Get(nodeName); found {
+		return event
+	}
+
+	return &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         "default",
+			Name:              nodeName + "." + strconv.FormatInt(time.Now().UnixNano(), 16),
+			CreationTimestamp: metav1.Now(),
+		},
+		InvolvedObject: v1.ObjectReference{
+			Kind: "Node",
+			Name: nodeName,
+		},
+		Reason: ManualDrainEventReason,
+	}
+}
+
+// DrainNodeByHand drain a node and terminate its instance, going through the same drain and termination
+// processes used by the controller: webhooks, eviction policies, verifications, audit log, etc.
+// Nodes can be given by the ID of their instance too. Results are written into out
+func DrainNodeByHand(ctx *Ctx, client kubernetes.Interface, awsClient *AwsClient, out io.Writer, nodeName string) error {
+
+	snapshot, err := TakeClusterSnapshot(ctx, client, awsClient)
+	if err != nil {
+		return err
+	}
+
+	nodePool, eventPool, autoscalingGroupPool, err := NewPoolsFromSnapshot(&snapshot)
+	if err != nil {
+		return err
+	}
+
+	if node, found := nodePool.GetByInstanceId(nodeName); found {
+		nodeName = node.Name
+	}
+
+	event := getNodeDrainEvent(eventPool, nodeName)
+	terminationRequest := NewTerminationRequest(nodePool, autoscalingGroupPool, event)
+	if terminationRequest.InstanceId == "" {
+		return fmt.Errorf(NodeNotFoundErrorMessage, nodeName)
+	}
+
+	if *ctx.Flags.DryRun {
+		fmt.Fprintf(out, ManualDrainDryRunMessage, nodeName, terminationRequest.InstanceId)
+		return nil
+	}
+
+	// Drain the node, enqueueing its instance for termination
+	terminationQueue := &TerminationQueue{}
+	var waitGroup sync.WaitGroup
+	waitGroup.Add(1)
+	DispatchDrainage(ctx, client, NewDrainHelper(ctx, client), nodePool, autoscalingGroupPool, terminationQueue,
+		DrainCandidate{Event: event}, &waitGroup)
+
+	// Vetoed by the webhooks, or nothing to terminate
+	if !IsNodeEnqueuedForTermination(terminationQueue, nodeName) {
+		return nil
+	}
+
+	// Terminate the instance, waiting for AWS to confirm it
+	terminationQueue.Lock.Lock()
+	queuedRequest := terminationQueue.Requests[0]
+	terminationQueue.Lock.Unlock()
+	go ProcessTerminationQueue(ctx, client, awsClient, terminationQueue)
+
+	terminationWaitTimeout := *ctx.Flags.TerminationConfirmTimeout + TerminationWaitMargin
+	for start := time.Now(); IsNodeEnqueuedForTermination(terminationQueue, nodeName); time.Sleep(WatchersLoopTime) {
+		if time.Since(start) > terminationWaitTimeout {
+			return fmt.Errorf(TerminationNotFinishedErrorMessage, terminationRequest.InstanceId, terminationWaitTimeout)
+		}
+	}
+
+	if queuedRequest.GivenUpReason != "" {
+		return fmt.Errorf(TerminationGivenUpAdminErrorMessage, terminationRequest.InstanceId, queuedRequest.GivenUpReason)
+	}
+
+	fmt.Fprintf(out, ManualDrainDoneMessage, nodeName, terminationRequest.InstanceId)
+	return nil
+}
+
+// RunDrainCommand drain a node and terminate its instance as the controller does
+// Usage: aws-spots-booster drain [controller flags] <node>
+func RunDrainCommand(args []string) {
+
+	flagSet, flags := newCommandFlagSet(DrainCommand)
+	_ = flagSet.Parse(args)
+
+	if flagSet.NArg() != 1 {
+		exitWithCommandUsage(flagSet, "aws-spots-booster drain [flags] <node|instance-id>")
+	}
+
+	ctx, client, awsClient, err := newCommandContext(flags)
+	exitOnCommandError(DrainCommand, err)
+
+	err = DrainNodeByHand(ctx, client, awsClient, os.Stdout, flagSet.Arg(0))
+	exitOnCommandError(DrainCommand, err)
+}
+
+// UnboostAutoscalingGroup revert the boost of an ASG, setting its desired capacity back to its ready nodes,
+// or to a given desired capacity over them when it is not negative. Results are written into out
+func UnboostAutoscalingGroup(ctx *Ctx, client kubernetes.Interface, awsClient *AwsClient, out io.Writer,
+	asgName string, desiredCapacity int) error {
+
+	configmap, err := client.CoreV1().ConfigMaps(*ctx.Flags.CAStatusNamespace).Get(context.TODO(), *ctx.Flags.CAConfigmapName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	autoscalingGroups, err := GetAutoscalingGroupsObject(
+		ParseAutoscalingGroupsNames(configmap.Data["status"]),
+		ParseAutoscalingGroupsHealthArguments(configmap.Data["status"]))
+	if err != nil {
+		return err
+	}
+
+	var autoscalingGroup *AutoscalingGroup
+	for _, storedAutoscalingGroup := range *autoscalingGroups {
+		if storedAutoscalingGroup.Name == asgName {
+			autoscalingGroup = storedAutoscalingGroup
+		}
+	}
+	if autoscalingGroup == nil {
+		return fmt.Errorf(AutoscalingGroupNotFoundErrorMessage, asgName)
+	}
+
+	readyCount, _ := strconv.Atoi(autoscalingGroup.Health.Ready)
+	minSize, _ := strconv.Atoi(autoscalingGroup.Health.CloudProviderMinSize)
+
+	// Only the boosted nodes are removed: never below the ready nodes, nor the minimum size
+	target := readyCount
+	if desiredCapacity > readyCount {
+		target = desiredCapacity
+	}
+	if target < minSize {
+		target = minSize
+	}
+
+	currentDesiredCapacities, err := AwsGetAutoScalingGroupsDesiredCapacity(awsClient, []string{asgName})
+	if err != nil {
+		return err
+	}
+	currentDesiredCapacity, found := currentDesiredCapacities[asgName]
+	if !found {
+		return fmt.Errorf(AutoscalingGroupNotFoundErrorMessage, asgName)
+	}
+
+	if target >= currentDesiredCapacity {
+		fmt.Fprintf(out, UnboostNothingMessage, asgName, currentDesiredCapacity)
+		return nil
+	}
+
+	auditRecord := AuditRecord{
+		Action:           AuditActionUnboost,
+		AutoscalingGroup: asgName,
+		Inputs: map[string]int{
+			"ready":   readyCount,
+			"min":     minSize,
+			"current": currentDesiredCapacity,
+			"target":  target,
+		},
+	}
+
+	if *ctx.Flags.DryRun {
+		auditRecord.Outcome = AuditOutcomeDryRun
+		WriteAuditRecord(ctx, auditRecord)
+		fmt.Fprintf(out, UnboostDryRunMessage, asgName, currentDesiredCapacity, target)
+		return nil
+	}
+
+	err = AwsSetDesiredCapacity(context.Background(), awsClient, asgName, int64(target))
+	auditRecord.Outcome = GetAuditOutcome(err)
+	auditRecord.Error = GetAuditErrorString(err)
+	WriteAuditRecord(ctx, auditRecord)
+	if err != nil {
+		return err
+	}
+
+	mAutoscalingGroupDesiredCapacity.WithLabelValues(asgName).Set(float64(target))
+	fmt.Fprintf(out, UnboostDoneMessage, asgName, currentDesiredCapacity, target)
+
+	if autoscalingGroupTags, err := AwsDescribeAutoScalingGroupsTags(awsClient, []string{asgName}); err == nil &&
+		autoscalingGroupTags[asgName][PausedTag] != PausedTagValue {
+		fmt.Fprintf(out, UnboostPauseHintMessage, PauseCommand, PauseTargetAutoscalingGroup, asgName)
+	}
+	return nil
+}
+
+// RunUnboostCommand revert the boost of an ASG, setting its desired capacity back to its ready nodes.
+// The capacity is only decreased, never below the ready nodes nor the minimum size of the ASG
+// Usage: aws-spots-booster unboost [--desired-capacity <n>] [controller flags] <asg>
+func RunUnboostCommand(args []string) {
+
+	flagSet, flags := newCommandFlagSet(UnboostCommand)
+	desiredCapacity := flagSet.Int("desired-capacity", -1, "(optional) desired capacity to set over the ready nodes of the asg, instead of them")
+	_ = flagSet.Parse(args)
+
+	if flagSet.NArg() != 1 {
+		exitWithCommandUsage(flagSet, "aws-spots-booster unboost [flags] <asg>")
+	}
+
+	ctx, client, awsClient, err := newCommandContext(flags)
+	exitOnCommandError(UnboostCommand, err)
+
+	err = UnboostAutoscalingGroup(ctx, client, awsClient, os.Stdout, flagSet.Arg(0), *desiredCapacity)
+	exitOnCommandError(UnboostCommand, err)
+}
+
+// RunPauseCommand pause or resume boosting or draining cluster-wide, using the control ConfigMap,
+// or boosting a single ASG, using its tags
+// Usage: aws-spots-booster pause|resume [controller flags] boosting|draining|asg <asg>
+func RunPauseCommand(command string, args []string) {
+
+	flagSet, flags := newCommandFlagSet(command)
+	_ = flagSet.Parse(args)
+
+	usage := fmt.Sprintf("aws-spots-booster %s [flags] %s|%s|%s <asg>", command, PauseTargetBoosting, PauseTargetDraining, PauseTargetAutoscalingGroup)
+	paused := command == PauseCommand
+
+	target := flagSet.Arg(0)
+	switch {
+	case (target == PauseTargetBoosting || target == PauseTargetDraining) && flagSet.NArg() == 1:
+	case target == PauseTargetAutoscalingGroup && flagSet.NArg() == 2:
+		target = PauseTargetAutoscalingGroup + " " + flagSet.Arg(1)
+	default:
+		exitWithCommandUsage(flagSet, usage)
+	}
+
+	ctx, client, awsClient, err := newCommandContext(flags)
+	exitOnCommandError(command, err)
+
+	participle := map[bool]string{true: "paused", false: "resumed"}[paused]
+	if *ctx.Flags.DryRun {
+		fmt.Printf(PauseControlDryRunMessage, target, participle)
+		return
+	}
+
+	switch flagSet.Arg(0) {
+	case PauseTargetBoosting:
+		err = SetPauseControl(ctx, client, PauseBoostingKey, paused)
+	case PauseTargetDraining:
+		err = SetPauseControl(ctx, client, PauseDrainingKey, paused)
+	case PauseTargetAutoscalingGroup:
+		err = SetAutoscalingGroupPaused(awsClient, flagSet.Arg(1), paused)
+	}
+	exitOnCommandError(command, err)
+
+	fmt.Printf(PauseControlDoneMessage, target, participle)
+}
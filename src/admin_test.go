@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// newAdminTestHarness return a harness with some old nodes on an ASG, boosted to a desired capacity
+func newAdminTestHarness(t *testing.T, minSize int, readyNodes int, desiredCapacity int, args ...string) *Harness {
+	h := NewHarness(t, args...)
+	h.Aws.AddAutoscalingGroup("eks-spot", "spot", minSize, 10)
+	addOldNodes(h, "eks-spot", readyNodes)
+
+	h.Aws.Lock.Lock()
+	h.Aws.AutoscalingGroups["eks-spot"].DesiredCapacity = desiredCapacity
+	h.Aws.Lock.Unlock()
+
+	h.UpdateClusterAutoscalerStatus()
+	return h
+}
+
+func TestUnboostAutoscalingGroup(t *testing.T) {
+	scenarios := map[string]struct {
+		minSize         int
+		desiredCapacity int
+		current         int
+		expected        int
+	}{
+		"back to the ready nodes":      {minSize: 1, desiredCapacity: -1, current: 8, expected: 5},
+		"to a capacity over the ready": {minSize: 1, desiredCapacity: 6, current: 8, expected: 6},
+		"never below the ready nodes":  {minSize: 1, desiredCapacity: 2, current: 8, expected: 5},
+		"never below the minimum size": {minSize: 6, desiredCapacity: -1, current: 8, expected: 6},
+		"never increased":              {minSize: 1, desiredCapacity: 9, current: 8, expected: 8},
+	}
+
+	for name, scenario := range scenarios {
+		h := newAdminTestHarness(t, scenario.minSize, 5, scenario.current)
+
+		var out bytes.Buffer
+		err := UnboostAutoscalingGroup(h.Ctx, h.Client, h.AwsClient(), &out, "eks-spot", scenario.desiredCapacity)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+			continue
+		}
+
+		if desiredCapacity := h.Aws.GetDesiredCapacity("eks-spot"); desiredCapacity != scenario.expected {
+			t.Errorf("%s: expected desired capacity %d, got %d", name, scenario.expected, desiredCapacity)
+		}
+
+		expectedOutput := fmt.Sprintf(UnboostDoneMessage, "eks-spot", scenario.current, scenario.expected)
+		if scenario.expected == scenario.current {
+			expectedOutput = fmt.Sprintf(UnboostNothingMessage, "eks-spot", scenario.current)
+		}
+		if !bytes.HasPrefix(out.Bytes(), []byte(expectedOutput)) {
+			t.Errorf("%s: expected output '%s', got '%s'", name, expectedOutput, out.String())
+		}
+	}
+}
+
+func TestUnboostAutoscalingGroupOnDryRun(t *testing.T) {
+	h := newAdminTestHarness(t, 1, 5, 8, "--dry-run")
+
+	var out bytes.Buffer
+	err := UnboostAutoscalingGroup(h.Ctx, h.Client, h.AwsClient(), &out, "eks-spot", -1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if expectedOutput := fmt.Sprintf(UnboostDryRunMessage, "eks-spot", 8, 5); out.String() != expectedOutput {
+		t.Errorf("expected output '%s', got '%s'", expectedOutput, out.String())
+	}
+	if desiredCapacity := h.Aws.GetDesiredCapacity("eks-spot"); desiredCapacity != 8 {
+		t.Errorf("expected desired capacity 8 on dry-run, got %d", desiredCapacity)
+	}
+	if !h.HasAuditRecord(AuditRecord{Action: AuditActionUnboost, AutoscalingGroup: "eks-spot", Outcome: AuditOutcomeDryRun}) {
+		t.Errorf("expected the unboost to be recorded as dry-run")
+	}
+}
+
+func TestUnboostUnknownAutoscalingGroup(t *testing.T) {
+	h := newAdminTestHarness(t, 1, 5, 8)
+
+	err := UnboostAutoscalingGroup(h.Ctx, h.Client, h.AwsClient(), &bytes.Buffer{}, "eks-unknown", -1)
+	if err == nil || err.Error() != fmt.Sprintf(AutoscalingGroupNotFoundErrorMessage, "eks-unknown") {
+		t.Errorf("expected the asg not to be found, got %v", err)
+	}
+}
+
+func TestDrainNodeByHandOnDryRun(t *testing.T) {
+	h := newAdminTestHarness(t, 1, 3, 3, "--dry-run")
+	h.AddPod("pod-1", "node-1")
+
+	var out bytes.Buffer
+	err := DrainNodeByHand(h.Ctx, h.Client, h.AwsClient(), &out, "node-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	instanceId := h.GetInstanceId("node-1")
+	if expectedOutput := fmt.Sprintf(ManualDrainDryRunMessage, "node-1", instanceId); out.String() != expectedOutput {
+		t.Errorf("expected output '%s', got '%s'", expectedOutput, out.String())
+	}
+	if h.GetNode("node-1").Spec.Unschedulable || !h.PodExists("pod-1") || !h.Aws.IsInstanceRunning(instanceId) {
+		t.Errorf("node-1 was drained or terminated on dry-run")
+	}
+}
+
+func TestDrainNodeByHandTerminatesInstance(t *testing.T) {
+	h := newAdminTestHarness(t, 1, 3, 3)
+	h.AddPod("pod-1", "node-1")
+	h.AddRebalanceRecommendation("node-1")
+
+	// Nodes are found by the ID of their instance too
+	instanceId := h.GetInstanceId("node-1")
+
+	var out bytes.Buffer
+	err := DrainNodeByHand(h.Ctx, h.Client, h.AwsClient(), &out, instanceId)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if expectedOutput := fmt.Sprintf(ManualDrainDoneMessage, "node-1", instanceId); out.String() != expectedOutput {
+		t.Errorf("expected output '%s', got '%s'", expectedOutput, out.String())
+	}
+	if h.PodExists("pod-1") || h.Aws.IsInstanceRunning(instanceId) {
+		t.Errorf("node-1 was not drained and terminated")
+	}
+
+	// Done by the same drain and termination processes of the controller
+	for _, action := range []string{AuditActionDrainStarted, AuditActionTerminationConfirmed} {
+		if !h.HasAuditRecord(AuditRecord{Action: action, Node: "node-1", Outcome: AuditOutcomeSuccess}) {
+			t.Errorf("expected action '%s' to be recorded for node-1", action)
+		}
+	}
+	if h.HasRebalanceRecommendation("node-1") {
+		t.Errorf("the rebalance recommendation of node-1 was not deleted")
+	}
+}
+
+func TestDrainNodeByHandVetoedByWebhook(t *testing.T) {
+	server, calls := newWebhookServer(t, func(call int32, w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+	})
+
+	h := newAdminTestHarness(t, 1, 3, 3, "--pre-drain-webhook-url", server.URL)
+	h.AddPod("pod-1", "node-1")
+
+	err := DrainNodeByHand(h.Ctx, h.Client, h.AwsClient(), &bytes.Buffer{}, "node-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if calls.Load() != 1 {
+		t.Errorf("expected the webhook to be called once, got %d calls", calls.Load())
+	}
+	if !h.PodExists("pod-1") || !h.Aws.IsInstanceRunning(h.GetInstanceId("node-1")) {
+		t.Errorf("node-1 was drained or terminated, but the webhook vetoed it")
+	}
+}
+
+func TestDrainUnknownNodeByHand(t *testing.T) {
+	h := newAdminTestHarness(t, 1, 3, 3)
+
+	err := DrainNodeByHand(h.Ctx, h.Client, h.AwsClient(), &bytes.Buffer{}, "node-unknown")
+	if err == nil || err.Error() != fmt.Sprintf(NodeNotFoundErrorMessage, "node-unknown") {
+		t.Errorf("expected the node not to be found, got %v", err)
+	}
+}
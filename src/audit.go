@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// AuditLogStdout is the value for the audit log path to write the records into stdout
+	AuditLogStdout = "stdout"
+
+	// Actions recorded on the audit log
+	AuditActionBoostSet             = "boost-set"
+	AuditActionBoostClamped         = "boost-clamped"
+	AuditActionBoostSkipped         = "boost-skipped"
+	AuditActionDrainStarted         = "drain-started"
+	AuditActionDrainFinished        = "drain-finished"
+	AuditActionTerminationRequested = "termination-requested"
+	AuditActionTerminationConfirmed = "termination-confirmed"
+	AuditActionTerminationSkipped   = "termination-skipped"
+	AuditActionEventDeleted         = "event-deleted"
+	AuditActionUnboost              = "unboost"
+
+	// Outcomes of the actions
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeFailure = "failure"
+	AuditOutcomeDryRun  = "dry-run"
+
+	// Error messages
+	AuditWriteErrorMessage  = "impossible to write the audit record: %v"
+	AuditRotateErrorMessage = "impossible to rotate the audit log, writing the records into the current file: %v"
+)
+
+// AuditRecord represents a single action done by the controller
+type AuditRecord struct {
+	Timestamp        string         `json:"timestamp"`
+	Action           string         `json:"action"`
+	AutoscalingGroup string         `json:"autoscalingGroup,omitempty"`
+	Node             string         `json:"node,omitempty"`
+	Instance         string         `json:"instance,omitempty"`
+	Event            string         `json:"event,omitempty"`
+	Inputs           map[string]int `json:"inputs,omitempty"`
+	Outcome          string         `json:"outcome"`
+	Reason           string         `json:"reason,omitempty"`
+	Error            string         `json:"error,omitempty"`
+}
+
+// AuditLogger represents an append-only stream of audit records, written as JSON lines
+type AuditLogger struct {
+	Lock sync.Mutex
+
+	Writer io.Writer
+	File   *os.File
+
+	// Rotation settings. Disabled when MaxSize is 0
+	Path       string
+	Size       int64
+	MaxSize    int64
+	MaxBackups int
+
+	// RotationFailed is true while the file can't be rotated
+	RotationFailed bool
+}
+
+// NewAuditLogger return an audit logger writing into a file, or stdout.
+// Nil is returned when the path is empty, which disables the audit log
+func NewAuditLogger(path string, maxSizeMegabytes int, maxBackups int) (auditLogger *AuditLogger, err error) {
+
+	if path == "" {
+		return nil, nil
+	}
+
+	if path == AuditLogStdout {
+		return &AuditLogger{Writer: os.Stdout}, nil
+	}
+
+	auditLogger = &AuditLogger{
+		Path:       path,
+		MaxSize:    int64(maxSizeMegabytes) * 1024 * 1024,
+		MaxBackups: maxBackups,
+	}
+
+	err = openAuditLogFile(auditLogger)
+	return auditLogger, err
+}
+
+// openAuditLogFile open the file of the audit logger in append mode
+func openAuditLogFile(auditLogger *AuditLogger) error {
+
+	file, err := os.OpenFile(auditLogger.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	auditLogger.File = file
+	auditLogger.Writer = file
+	auditLogger.Size = fileInfo.Size()
+
+	return nil
+}
+
+// rotateAuditLogFile move current file to a backup, shifting the older ones and deleting the oldest.
+// Current file is kept open until the new one is ready, so the records can still be written when anything fails
+func rotateAuditLogFile(auditLogger *AuditLogger) error {
+
+	for backup := auditLogger.MaxBackups; backup > 0; backup-- {
+		source := auditLogger.Path
+		if backup > 1 {
+			source = fmt.Sprintf("%s.%d", auditLogger.Path, backup-1)
+		}
+
+		err := os.Rename(source, fmt.Sprintf("%s.%d", auditLogger.Path, backup))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	// Without backups, the file is just truncated
+	if auditLogger.MaxBackups <= 0 {
+		err := os.Remove(auditLogger.Path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	previousFile := auditLogger.File
+	err := openAuditLogFile(auditLogger)
+	if err != nil {
+		return err
+	}
+
+	previousFile.Close()
+	return nil
+}
+
+// WriteAuditRecord append a record to the audit log. Nothing is done when the audit log is disabled
+func WriteAuditRecord(ctx *Ctx, record AuditRecord) {
+
+	auditLogger := ctx.Audit
+	if auditLogger == nil {
+		return
+	}
+
+	record.Timestamp = time.Now().Format(time.RFC3339Nano)
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		ctx.Logger.Infof(AuditWriteErrorMessage, err)
+		return
+	}
+	line = append(line, '\n')
+
+	auditLogger.Lock.Lock()
+	defer auditLogger.Lock.Unlock()
+
+	if auditLogger.File != nil && auditLogger.MaxSize > 0 && auditLogger.Size+int64(len(line)) > auditLogger.MaxSize {
+		err = rotateAuditLogFile(auditLogger)
+
+		// Rotation is retried on each write, but the failure is only logged when it starts
+		if err != nil && !auditLogger.RotationFailed {
+			ctx.Logger.Infof(AuditRotateErrorMessage, err)
+		}
+		auditLogger.RotationFailed = err != nil
+	}
+
+	written, err := auditLogger.Writer.Write(line)
+	auditLogger.Size += int64(written)
+	if err != nil {
+		ctx.Logger.Infof(AuditWriteErrorMessage, err)
+	}
+}
+
+// GetAuditErrorString return the message of an error, or an empty string when there is no error
+func GetAuditErrorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// GetAuditOutcome return the outcome for an action according to its error
+func GetAuditOutcome(err error) string {
+	if err != nil {
+		return AuditOutcomeFailure
+	}
+	return AuditOutcomeSuccess
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuditLogKeepsWritingWhenRotationFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	auditLogger, err := NewAuditLogger(path, 0, 1)
+	if err != nil {
+		t.Fatalf("impossible to create the audit logger: %v", err)
+	}
+	auditLogger.MaxSize = 1
+
+	// A non-empty directory in the place of the backup makes the rename fail
+	err = os.MkdirAll(filepath.Join(path+".1", "busy"), 0755)
+	if err != nil {
+		t.Fatalf("impossible to create the directory: %v", err)
+	}
+
+	core, logs := observer.New(zap.InfoLevel)
+	ctx := &Ctx{Ctx: context.Background(), Logger: zap.New(core).Sugar(), Audit: auditLogger}
+
+	for _, node := range []string{"node-1", "node-2", "node-3"} {
+		WriteAuditRecord(ctx, AuditRecord{Action: AuditActionDrainStarted, Node: node, Outcome: AuditOutcomeSuccess})
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("impossible to read the audit log: %v", err)
+	}
+	if lines := strings.Count(string(content), "\n"); lines != 3 {
+		t.Errorf("expected 3 records in the current file, got %d", lines)
+	}
+	if failures := logs.FilterMessageSnippet("impossible to rotate").Len(); failures != 1 {
+		t.Errorf("expected the rotation failure to be logged once, got %d", failures)
+	}
+
+	// Once the backup can be written, the file is rotated again
+	err = os.RemoveAll(path + ".1")
+	if err != nil {
+		t.Fatalf("impossible to remove the directory: %v", err)
+	}
+	WriteAuditRecord(ctx, AuditRecord{Action: AuditActionDrainStarted, Node: "node-4", Outcome: AuditOutcomeSuccess})
+
+	if auditLogger.RotationFailed {
+		t.Errorf("expected the rotation to succeed")
+	}
+	if _, err = os.Stat(path + ".1"); err != nil {
+		t.Errorf("expected a backup of the audit log: %v", err)
+	}
+}
@@ -21,13 +21,14 @@ const (
 
 	// Error messages
 	ConfigmapRetrieveErrorMessage = "error obtaining cluster-autoscaler status configmap from the cluster"
-	ConfigMapParseErrorMessage    = "error parsing status configmap (hint: syntax has changed between cluster-autoscaler versions?)"
+	ConfigMapParseErrorMessage    = "error parsing status configmap (hint: syntax has changed between cluster-autoscaler versions?): %v"
+	HealthMismatchErrorMessage    = "found %d nodegroup names but %d health statuses with minSize and maxSize in the status"
 )
 
 // WatchStatusConfigmap watches for changes on Cluster Autoscaler's status-configmap on k8s
 // Done this way to reduce the calls done to Kube API
 // This function must be executed as a go routine
-func WatchStatusConfigmap(ctx *Ctx, client *kubernetes.Clientset, autoscalingGroupPool *AutoscalingGroupPool) {
+func WatchStatusConfigmap(ctx *Ctx, client kubernetes.Interface, autoscalingGroupPool *AutoscalingGroupPool) {
 
 	// Ensure retry to create a watcher when failing
 	for {
@@ -52,34 +53,20 @@ func WatchStatusConfigmap(ctx *Ctx, client *kubernetes.Clientset, autoscalingGro
 
 				autoscalingGroups, err := GetAutoscalingGroupsObject(autoscalingGroupsNames, autoscalingGroupsHealthArgs)
 				if err != nil {
-					ctx.Logger.Info(ConfigMapParseErrorMessage)
-				}
-
-				// Create all the ASGs when not already present
-				if len(autoscalingGroupPool.AutoscalingGroups) == 0 {
-					autoscalingGroupPool.Lock.Lock()
-					autoscalingGroupPool.AutoscalingGroups = *autoscalingGroups
-					autoscalingGroupPool.Lock.Unlock()
+					ctx.Logger.Infof(ConfigMapParseErrorMessage, err)
 					continue
 				}
 
-				// Update health values into the ASG objects
-				// Iterate this way not to overwrite changes done by another goroutines
-				for _, objectASG := range autoscalingGroupPool.AutoscalingGroups {
-
-					for _, calculatedASG := range *autoscalingGroups {
-						if calculatedASG.Name == objectASG.Name {
-							autoscalingGroupPool.Lock.Lock()
-							objectASG.Health = calculatedASG.Health
-							autoscalingGroupPool.Lock.Unlock()
-						}
-					}
-				}
+				// Update health values into the ASG objects, keeping the raw status to be recorded on the snapshots.
+				// Known ASGs keep the changes done by another goroutines
+				autoscalingGroupPool.SetStatus(configmapObject.Data["status"], *autoscalingGroups)
 
 			case watch.Deleted:
 				ctx.Logger.Info(ConfigmapDeletedMessage)
 			}
 		}
+
+		mWatchRestartsTotal.WithLabelValues("status-configmap").Inc()
 	}
 }
 
@@ -126,6 +113,11 @@ func GetAutoscalingGroupsObject(autoscalingGroupsNames []string, autoscalingGrou
 
 	var autoscalingGroups AutoscalingGroups
 
+	// Each name must be paired with its health, which can be missing or incomplete on a malformed status
+	if len(autoscalingGroupsNames) != len(autoscalingGroupsHealthStatus) {
+		return &autoscalingGroups, fmt.Errorf(HealthMismatchErrorMessage, len(autoscalingGroupsNames), len(autoscalingGroupsHealthStatus))
+	}
+
 	stringRe := regexp.MustCompile(`(\w+)=([0-9]+)`)
 	replacePattern := `"$1":"$2",`
 
@@ -158,7 +150,7 @@ func GetAutoscalingGroupsObject(autoscalingGroupsNames []string, autoscalingGrou
 // GetAutoscalingGroupsNames return an array with the names of the ASGs from the ASG pool
 func GetAutoscalingGroupsNames(autoscalingGroupPool *AutoscalingGroupPool) (autoscalingGroupNames []string) {
 
-	for _, autoscalingGroup := range autoscalingGroupPool.AutoscalingGroups {
+	for _, autoscalingGroup := range autoscalingGroupPool.Snapshot() {
 		autoscalingGroupNames = append(autoscalingGroupNames, autoscalingGroup.Name)
 	}
 
@@ -170,7 +162,7 @@ func GetAutoscalingGroupsMaxCapacity(autoscalingGroupPool *AutoscalingGroupPool)
 
 	autoscalingGroupsMaxCapacity = map[string]int{}
 
-	for _, autoscalingGroup := range autoscalingGroupPool.AutoscalingGroups {
+	for _, autoscalingGroup := range autoscalingGroupPool.Snapshot() {
 		currentCloudProviderMaxSize, err := strconv.Atoi(autoscalingGroup.Health.CloudProviderMaxSize)
 		if err != nil {
 			break
@@ -180,3 +172,13 @@ func GetAutoscalingGroupsMaxCapacity(autoscalingGroupPool *AutoscalingGroupPool)
 
 	return autoscalingGroupsMaxCapacity, err
 }
+
+// GetAutoscalingGroupNameByNodeGroup return the name of the ASG backing a node-group, looking at its tags
+func GetAutoscalingGroupNameByNodeGroup(autoscalingGroupPool *AutoscalingGroupPool, nodeGroupName string) (autoscalingGroupName string) {
+
+	if autoscalingGroup, found := autoscalingGroupPool.GetByNodegroup(nodeGroupName); found {
+		autoscalingGroupName = autoscalingGroup.Name
+	}
+
+	return autoscalingGroupName
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+)
+
+const testClusterAutoscalerStatus = `Cluster-autoscaler status at 2023-03-01 10:00:00 +0000 UTC:
+Cluster-wide:
+  Health:      Healthy (ready=3 unready=0 notStarted=0 longNotStarted=0 registered=3 longUnregistered=0)
+
+NodeGroups:
+  Name:        eks-spot
+  Health:      Healthy (ready=3 unready=0 notStarted=0 longNotStarted=0 registered=3 longUnregistered=0 cloudProviderTarget=3 (minSize=1, maxSize=10))
+`
+
+func TestNewPoolsFromSnapshotWithMalformedStatus(t *testing.T) {
+	snapshot := &Snapshot{ClusterAutoscalerStatus: testClusterAutoscalerStatus}
+
+	_, _, autoscalingGroupPool, err := NewPoolsFromSnapshot(snapshot)
+	if err != nil || autoscalingGroupPool.Len() != 1 {
+		t.Fatalf("expected a valid status to be parsed, got %d asgs (%v)", autoscalingGroupPool.Len(), err)
+	}
+
+	// A nodegroup without minSize and maxSize has no health arguments to be paired with
+	snapshot.ClusterAutoscalerStatus += `
+  Name:        x
+  Health:      Healthy (ready=1)
+`
+	_, _, _, err = NewPoolsFromSnapshot(snapshot)
+	if err == nil {
+		t.Errorf("expected an error for a nodegroup without health arguments")
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"k8s.io/client-go/util/flowcontrol"
+	"math/rand"
+	"time"
+)
+
+const (
+	// AwsUnknownErrorCode is used for the errors not coming from AWS API, like the network ones
+	AwsUnknownErrorCode = "unknown"
+)
+
+// NewAwsRateLimiter return a rate limiter for the calls done to AWS API, following a retry policy
+func NewAwsRateLimiter(policy AwsRetryPolicy) *AwsRateLimiter {
+	return &AwsRateLimiter{
+		Policy:  policy,
+		Buckets: map[string]flowcontrol.RateLimiter{},
+		Random:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// GetAwsRetryPolicy return the retry policy configured by the flags
+func GetAwsRetryPolicy(flags *ControllerFlags) AwsRetryPolicy {
+	return AwsRetryPolicy{
+		QPS:        float32(*flags.AwsApiQPS),
+		Burst:      *flags.AwsApiBurst,
+		MaxRetries: *flags.AwsApiMaxRetries,
+		BaseDelay:  *flags.AwsApiRetryBaseDelay,
+		MaxDelay:   *flags.AwsApiRetryMaxDelay,
+	}
+}
+
+// NewRateLimitedAwsClient return the clients of the AWS services wrapped, so all the calls done through them
+// share the same rate limits and are retried following the policy
+func NewRateLimitedAwsClient(awsClient *AwsClient, policy AwsRetryPolicy) *AwsClient {
+	limiter := NewAwsRateLimiter(policy)
+
+	return &AwsClient{
+		AutoScaling: &rateLimitedAutoScaling{AutoScalingAPI: awsClient.AutoScaling, limiter: limiter},
+		EC2:         &rateLimitedEC2{EC2API: awsClient.EC2, limiter: limiter},
+	}
+}
+
+// GetAwsErrorCode return the code of an error returned by AWS API
+func GetAwsErrorCode(err error) string {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code()
+	}
+	return AwsUnknownErrorCode
+}
+
+// IsAwsErrorRetryable return true when a call failed because of throttling, contention on the resource,
+// a scaling activity in progress or a transient error, so it can succeed later
+func IsAwsErrorRetryable(err error) bool {
+	switch GetAwsErrorCode(err) {
+	case autoscaling.ErrCodeResourceContentionFault, autoscaling.ErrCodeScalingActivityInProgressFault:
+		return true
+	}
+
+	return request.IsErrorThrottle(err) || request.IsErrorRetryable(err)
+}
+
+// GetAwsRetryDelay return the duration to wait before a retry: an exponential backoff capped to the max delay.
+// Half of it is random, so the retries of the calls throttled at the same time are spread
+func GetAwsRetryDelay(limiter *AwsRateLimiter, try int) time.Duration {
+	delay := limiter.Policy.MaxDelay
+	if try < 32 {
+		if backoff := limiter.Policy.BaseDelay << try; backoff > 0 && backoff < delay {
+			delay = backoff
+		}
+	}
+
+	if delay <= 0 {
+		return 0
+	}
+
+	limiter.Lock.Lock()
+	defer limiter.Lock.Unlock()
+
+	return delay/2 + time.Duration(limiter.Random.Int63n(int64(delay/2)+1))
+}
+
+// waitAwsRateLimit block until the token bucket of an operation allows a new call, or the context is done
+func waitAwsRateLimit(spanCtx context.Context, limiter *AwsRateLimiter, service string, operation string) error {
+	limiter.Lock.Lock()
+	bucket, found := limiter.Buckets[service+"/"+operation]
+	if !found {
+		bucket = flowcontrol.NewFakeAlwaysRateLimiter()
+		if limiter.Policy.QPS > 0 {
+			bucket = flowcontrol.NewTokenBucketRateLimiter(limiter.Policy.QPS, limiter.Policy.Burst)
+		}
+		limiter.Buckets[service+"/"+operation] = bucket
+	}
+	limiter.Lock.Unlock()
+
+	return bucket.Wait(spanCtx)
+}
+
+// CallAwsApi execute a call to an operation of AWS API once allowed by its rate limit,
+// retrying it with backoff while it fails with retryable errors, up to the retries of the policy
+func CallAwsApi(spanCtx context.Context, limiter *AwsRateLimiter, service string, operation string, call func() error) (err error) {
+
+	for try := 0; ; try++ {
+		err = waitAwsRateLimit(spanCtx, limiter, service, operation)
+		if err != nil {
+			return err
+		}
+
+		err = call()
+		if err == nil || !IsAwsErrorRetryable(err) {
+			return err
+		}
+
+		if try >= limiter.Policy.MaxRetries {
+			mAwsApiRetriesExhaustedTotal.WithLabelValues(service, operation).Inc()
+			return err
+		}
+		mAwsApiRetriesTotal.WithLabelValues(service, operation, GetAwsErrorCode(err)).Inc()
+
+		select {
+		case <-spanCtx.Done():
+			return err
+		case <-time.After(GetAwsRetryDelay(limiter, try)):
+		}
+	}
+}
+
+// rateLimitedAutoScaling wraps the calls to the AutoScaling API done by the controller with rate limits and retries.
+// Pages are requested one by one, so a retry doesn't repeat the pages already delivered
+type rateLimitedAutoScaling struct {
+	autoscalingiface.AutoScalingAPI
+	limiter *AwsRateLimiter
+}
+
+func (r *rateLimitedAutoScaling) DescribeTagsPages(input *autoscaling.DescribeTagsInput,
+	fn func(*autoscaling.DescribeTagsOutput, bool) bool) error {
+
+	pageInput := *input
+	for {
+		var output *autoscaling.DescribeTagsOutput
+		err := CallAwsApi(aws.BackgroundContext(), r.limiter, autoscaling.ServiceName, "DescribeTags", func() (err error) {
+			output, err = r.AutoScalingAPI.DescribeTags(&pageInput)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		lastPage := aws.StringValue(output.NextToken) == ""
+		if !fn(output, lastPage) || lastPage {
+			return nil
+		}
+		pageInput.NextToken = output.NextToken
+	}
+}
+
+func (r *rateLimitedAutoScaling) CreateOrUpdateTags(input *autoscaling.CreateOrUpdateTagsInput) (output *autoscaling.CreateOrUpdateTagsOutput, err error) {
+	err = CallAwsApi(aws.BackgroundContext(), r.limiter, autoscaling.ServiceName, "CreateOrUpdateTags", func() (err error) {
+		output, err = r.AutoScalingAPI.CreateOrUpdateTags(input)
+		return err
+	})
+	return output, err
+}
+
+func (r *rateLimitedAutoScaling) DeleteTags(input *autoscaling.DeleteTagsInput) (output *autoscaling.DeleteTagsOutput, err error) {
+	err = CallAwsApi(aws.BackgroundContext(), r.limiter, autoscaling.ServiceName, "DeleteTags", func() (err error) {
+		output, err = r.AutoScalingAPI.DeleteTags(input)
+		return err
+	})
+	return output, err
+}
+
+func (r *rateLimitedAutoScaling) DescribeAutoScalingGroupsPages(input *autoscaling.DescribeAutoScalingGroupsInput,
+	fn func(*autoscaling.DescribeAutoScalingGroupsOutput, bool) bool) error {
+
+	pageInput := *input
+	for {
+		var output *autoscaling.DescribeAutoScalingGroupsOutput
+		err := CallAwsApi(aws.BackgroundContext(), r.limiter, autoscaling.ServiceName, "DescribeAutoScalingGroups", func() (err error) {
+			output, err = r.AutoScalingAPI.DescribeAutoScalingGroups(&pageInput)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		lastPage := aws.StringValue(output.NextToken) == ""
+		if !fn(output, lastPage) || lastPage {
+			return nil
+		}
+		pageInput.NextToken = output.NextToken
+	}
+}
+
+func (r *rateLimitedAutoScaling) DescribeAutoScalingInstances(input *autoscaling.DescribeAutoScalingInstancesInput) (output *autoscaling.DescribeAutoScalingInstancesOutput, err error) {
+	err = CallAwsApi(aws.BackgroundContext(), r.limiter, autoscaling.ServiceName, "DescribeAutoScalingInstances", func() (err error) {
+		output, err = r.AutoScalingAPI.DescribeAutoScalingInstances(input)
+		return err
+	})
+	return output, err
+}
+
+func (r *rateLimitedAutoScaling) SetDesiredCapacityWithContext(spanCtx aws.Context, input *autoscaling.SetDesiredCapacityInput,
+	options ...request.Option) (output *autoscaling.SetDesiredCapacityOutput, err error) {
+	err = CallAwsApi(spanCtx, r.limiter, autoscaling.ServiceName, "SetDesiredCapacity", func() (err error) {
+		output, err = r.AutoScalingAPI.SetDesiredCapacityWithContext(spanCtx, input, options...)
+		return err
+	})
+	return output, err
+}
+
+func (r *rateLimitedAutoScaling) TerminateInstanceInAutoScalingGroupWithContext(spanCtx aws.Context, input *autoscaling.TerminateInstanceInAutoScalingGroupInput,
+	options ...request.Option) (output *autoscaling.TerminateInstanceInAutoScalingGroupOutput, err error) {
+	err = CallAwsApi(spanCtx, r.limiter, autoscaling.ServiceName, "TerminateInstanceInAutoScalingGroup", func() (err error) {
+		output, err = r.AutoScalingAPI.TerminateInstanceInAutoScalingGroupWithContext(spanCtx, input, options...)
+		return err
+	})
+	return output, err
+}
+
+// rateLimitedEC2 wraps the calls to the EC2 API done by the controller with rate limits and retries
+type rateLimitedEC2 struct {
+	ec2iface.EC2API
+	limiter *AwsRateLimiter
+}
+
+func (r *rateLimitedEC2) DescribeInstances(input *ec2.DescribeInstancesInput) (output *ec2.DescribeInstancesOutput, err error) {
+	err = CallAwsApi(aws.BackgroundContext(), r.limiter, ec2.ServiceName, "DescribeInstances", func() (err error) {
+		output, err = r.EC2API.DescribeInstances(input)
+		return err
+	})
+	return output, err
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"testing"
+	"time"
+)
+
+// newTestAwsRateLimiter return a rate limiter without rate limits and fast retries
+func newTestAwsRateLimiter(maxRetries int) *AwsRateLimiter {
+	return NewAwsRateLimiter(AwsRetryPolicy{
+		MaxRetries: maxRetries,
+		BaseDelay:  time.Millisecond,
+		MaxDelay:   5 * time.Millisecond,
+	})
+}
+
+func TestCallAwsApiRetries(t *testing.T) {
+	tests := []struct {
+		name          string
+		errorCodes    []string
+		maxRetries    int
+		expectedCalls int
+		expectedError bool
+	}{
+		{"throttled until it succeeds", []string{"Throttling", autoscaling.ErrCodeScalingActivityInProgressFault}, 3, 3, false},
+		{"retries exhausted", []string{"Throttling", "Throttling", "Throttling"}, 2, 3, true},
+		{"not retryable", []string{"ValidationError", "Throttling"}, 3, 1, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calls := 0
+			err := CallAwsApi(context.Background(), newTestAwsRateLimiter(test.maxRetries), "autoscaling", "Test", func() error {
+				calls++
+				if calls <= len(test.errorCodes) {
+					return awserr.New(test.errorCodes[calls-1], "failed", nil)
+				}
+				return nil
+			})
+
+			if calls != test.expectedCalls {
+				t.Errorf("expected %d calls, got %d", test.expectedCalls, calls)
+			}
+			if (err != nil) != test.expectedError {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestGetAwsRetryDelay(t *testing.T) {
+	limiter := NewAwsRateLimiter(AwsRetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second})
+
+	for try, expected := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond,
+		800 * time.Millisecond, time.Second, time.Second} {
+		delay := GetAwsRetryDelay(limiter, try)
+		if delay < expected/2 || delay > expected {
+			t.Errorf("delay of try %d out of [%s, %s]: %s", try, expected/2, expected, delay)
+		}
+	}
+
+	// Huge tries don't overflow the backoff
+	if delay := GetAwsRetryDelay(limiter, 100); delay < time.Second/2 || delay > time.Second {
+		t.Errorf("delay of a huge try is not capped to the max delay: %s", delay)
+	}
+}
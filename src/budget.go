@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"golang.org/x/exp/maps"
+	v1 "k8s.io/api/core/v1"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+)
+
+const (
+	// Reasons to trim the boosts
+	BudgetReasonASGNodes     = "asg-nodes"
+	BudgetReasonClusterNodes = "cluster-nodes"
+	BudgetReasonHourlyCost   = "hourly-cost"
+
+	// Info messages
+	BoostTrimmedMessage = "boost for asg '%s' trimmed by %d nodes to fit the budget '%s'"
+	UnknownPriceMessage = "price unknown for instance types %v of asg '%s', its boost is not counted on the cost budget"
+)
+
+// boostTrim represents the nodes trimmed from the boost of an ASG to fit a budget
+type boostTrim struct {
+	asgName string
+	reason  string
+	nodes   int
+	target  int
+}
+
+// NewBoostBudgetTracker return a tracker for the trims done by the budgets ready to be used
+func NewBoostBudgetTracker() *BoostBudgetTracker {
+	return &BoostBudgetTracker{
+		Budgeted: map[string]int{},
+	}
+}
+
+// LoadInstancePrices read a JSON file with the hourly price for each instance type. Example: {"m5.large": 0.096}
+func LoadInstancePrices(path string) (instancePrices map[string]float64, err error) {
+
+	instancePrices = map[string]float64{}
+	if path == "" {
+		return instancePrices, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return instancePrices, err
+	}
+
+	err = json.Unmarshal(content, &instancePrices)
+	return instancePrices, err
+}
+
+// GetNodeGroupHourlyPrice return the hourly price of a node for a node-group.
+// The most expensive instance type of its nodes is used, to be conservative
+func GetNodeGroupHourlyPrice(nodePool *NodePool, instancePrices map[string]float64, nodeGroupName string) (price float64, unknownTypes []string) {
+
+	for _, node := range nodePool.NodegroupSnapshot(nodeGroupName) {
+		instanceType := node.Labels[InstanceTypeLabel]
+		instancePrice, found := instancePrices[instanceType]
+		if !found {
+			unknownTypes = append(unknownTypes, instanceType)
+			continue
+		}
+		price = math.Max(price, instancePrice)
+	}
+
+	return price, unknownTypes
+}
+
+// ApplyBoostBudgets trim the desired capacities so the boosted nodes fit into the configured budgets.
+// Boosted nodes are those over the ready ones, including the extra nodes added over calculations.
+// The trims of an ASG are recorded only when its budgeted capacity changes, or always when the tracker is nil.
+// Returns the trimmed desired capacities, and the trimmed nodes per ASG and reason
+func ApplyBoostBudgets(ctx *Ctx, budgetTracker *BoostBudgetTracker, autoscalingGroupPool *AutoscalingGroupPool, nodePool *NodePool,
+	instancePrices map[string]float64, asgsDesiredCapacity map[string]int) (trimmedDesiredCapacity map[string]int, trimmedNodes map[string]map[string]int) {
+
+	trimmedDesiredCapacity = maps.Clone(asgsDesiredCapacity)
+	trimmedNodes = map[string]map[string]int{}
+
+	// Get the boosted nodes and the price of each of them for the ASGs
+	boostedNodes := map[string]int{}
+	nodePrices := map[string]float64{}
+
+	for asgName, desiredCapacity := range asgsDesiredCapacity {
+		autoscalingGroup, found := autoscalingGroupPool.Get(asgName)
+		if !found {
+			continue
+		}
+
+		readyCount, err := strconv.Atoi(autoscalingGroup.Health.Ready)
+		if err != nil {
+			continue
+		}
+
+		boostedNodes[asgName] = desiredCapacity + *ctx.Flags.ExtraNodesOverCalculations - readyCount
+
+		if *ctx.Flags.HourlyCostBudget > 0 {
+			var unknownTypes []string
+			nodePrices[asgName], unknownTypes = GetNodeGroupHourlyPrice(nodePool, instancePrices, autoscalingGroup.Tags[AWSAutoscalingGroupsNodeGroupTag])
+			if len(unknownTypes) > 0 {
+				ctx.Logger.Infof(UnknownPriceMessage, unknownTypes, asgName)
+			}
+		}
+	}
+
+	var trims []boostTrim
+	trim := func(asgName string, nodes int, reason string) {
+		if nodes <= 0 {
+			return
+		}
+		boostedNodes[asgName] -= nodes
+		trimmedDesiredCapacity[asgName] -= nodes
+
+		if trimmedNodes[asgName] == nil {
+			trimmedNodes[asgName] = map[string]int{}
+		}
+		trimmedNodes[asgName][reason] += nodes
+		trims = append(trims, boostTrim{asgName: asgName, reason: reason, nodes: nodes, target: trimmedDesiredCapacity[asgName]})
+	}
+
+	// Iterate always in the same order to trim the same ASGs between loops
+	asgNames := maps.Keys(boostedNodes)
+	sort.Strings(asgNames)
+
+	// Trim each ASG to its own limit
+	if maxPerASG := *ctx.Flags.MaxBoostedNodesPerASG; maxPerASG > 0 {
+		for _, asgName := range asgNames {
+			trim(asgName, boostedNodes[asgName]-maxPerASG, BudgetReasonASGNodes)
+		}
+	}
+
+	// Trim the ASGs to fit the cluster-wide limit
+	if maxCluster := *ctx.Flags.MaxBoostedNodesCluster; maxCluster > 0 {
+		var allowedNodes int
+		for _, asgName := range asgNames {
+			nodes := int(math.Max(0, float64(boostedNodes[asgName])))
+			allowed := int(math.Min(float64(nodes), float64(maxCluster-allowedNodes)))
+			trim(asgName, nodes-allowed, BudgetReasonClusterNodes)
+			allowedNodes += allowed
+		}
+	}
+
+	// Trim the ASGs to fit the hourly cost budget
+	if budget := *ctx.Flags.HourlyCostBudget; budget > 0 {
+		var allowedCost float64
+		for _, asgName := range asgNames {
+			nodes := int(math.Max(0, float64(boostedNodes[asgName])))
+			allowed := nodes
+			if nodePrices[asgName] > 0 {
+				allowed = int(math.Min(float64(nodes), math.Floor((budget-allowedCost)/nodePrices[asgName])))
+				allowed = int(math.Max(0, float64(allowed)))
+			}
+			trim(asgName, nodes-allowed, BudgetReasonHourlyCost)
+			allowedCost += float64(allowed) * nodePrices[asgName]
+		}
+	}
+
+	recordBoostTrims(ctx, budgetTracker, asgsDesiredCapacity, trimmedDesiredCapacity, trims)
+
+	// Report the trimmed nodes
+	for _, asgName := range asgNames {
+		for _, reason := range []string{BudgetReasonASGNodes, BudgetReasonClusterNodes, BudgetReasonHourlyCost} {
+			mBoostTrimmedNodes.WithLabelValues(asgName, reason).Set(float64(trimmedNodes[asgName][reason]))
+		}
+		mBoostedNodes.WithLabelValues(asgName).Set(math.Max(0, float64(boostedNodes[asgName])))
+	}
+
+	return trimmedDesiredCapacity, trimmedNodes
+}
+
+// recordBoostTrims log, audit and emit an event for the trims of the ASGs whose budgeted capacity changed
+// since the last time they were recorded, so a trim persisting over the loops is recorded once
+func recordBoostTrims(ctx *Ctx, budgetTracker *BoostBudgetTracker, asgsDesiredCapacity map[string]int,
+	trimmedDesiredCapacity map[string]int, trims []boostTrim) {
+
+	trimmedAsgs := map[string]bool{}
+	for _, boostTrim := range trims {
+		trimmedAsgs[boostTrim.asgName] = true
+	}
+
+	changedAsgs := trimmedAsgs
+	if budgetTracker != nil {
+		changedAsgs = map[string]bool{}
+
+		budgetTracker.Lock.Lock()
+
+		// Forget the ASGs not trimmed anymore, so a new trim is recorded even when it leaves the same capacity
+		for asgName := range budgetTracker.Budgeted {
+			if !trimmedAsgs[asgName] {
+				delete(budgetTracker.Budgeted, asgName)
+			}
+		}
+
+		for asgName := range trimmedAsgs {
+			budgeted, found := budgetTracker.Budgeted[asgName]
+			changedAsgs[asgName] = !found || budgeted != trimmedDesiredCapacity[asgName]
+			budgetTracker.Budgeted[asgName] = trimmedDesiredCapacity[asgName]
+		}
+		budgetTracker.Lock.Unlock()
+	}
+
+	for _, boostTrim := range trims {
+		if !changedAsgs[boostTrim.asgName] {
+			continue
+		}
+		ctx.Logger.Infof(BoostTrimmedMessage, boostTrim.asgName, boostTrim.nodes, boostTrim.reason)
+
+		WriteAuditRecord(ctx, AuditRecord{
+			Action:           AuditActionBoostClamped,
+			AutoscalingGroup: boostTrim.asgName,
+			Inputs: map[string]int{
+				"calculated": asgsDesiredCapacity[boostTrim.asgName],
+				"target":     boostTrim.target,
+				"trimmed":    boostTrim.nodes,
+			},
+			Outcome: AuditOutcomeSuccess,
+			Reason:  "budget-" + boostTrim.reason,
+		})
+		RecordAutoscalingGroupEvent(ctx, boostTrim.asgName, v1.EventTypeNormal, BoostClampedReason, BoostClampedEventMessage,
+			boostTrim.asgName, boostTrim.target+boostTrim.nodes, boostTrim.target, "budget-"+boostTrim.reason)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestBoostTrimIsRecordedOnlyWhenBudgetChanges(t *testing.T) {
+	h := NewHarness(t, "--max-boosted-nodes-per-asg", "1")
+
+	autoscalingGroupPool := NewAutoscalingGroupPool()
+	autoscalingGroupPool.SetStatus("status", AutoscalingGroups{{Name: "eks-spot", Health: HealthStatus{Ready: "3"}}})
+
+	budgetTracker := NewBoostBudgetTracker()
+	countTrimRecords := func() (count int) {
+		for _, auditRecord := range h.AuditRecords() {
+			if auditRecord.Action == AuditActionBoostClamped {
+				count++
+			}
+		}
+		return count
+	}
+
+	// Calculated capacity for each loop, and the trim records expected after it
+	loops := []struct {
+		calculated      int
+		expectedTarget  int
+		expectedRecords int
+	}{
+		{calculated: 6, expectedTarget: 4, expectedRecords: 1},
+		{calculated: 6, expectedTarget: 4, expectedRecords: 1},
+		{calculated: 7, expectedTarget: 4, expectedRecords: 1},
+		{calculated: 4, expectedTarget: 4, expectedRecords: 1},
+		{calculated: 6, expectedTarget: 4, expectedRecords: 2},
+		{calculated: 2, expectedTarget: 2, expectedRecords: 2},
+	}
+
+	for loop, expected := range loops {
+		trimmedDesiredCapacity, _ := ApplyBoostBudgets(h.Ctx, budgetTracker, autoscalingGroupPool, NewNodePool(), nil,
+			map[string]int{"eks-spot": expected.calculated})
+
+		if trimmedDesiredCapacity["eks-spot"] != expected.expectedTarget {
+			t.Errorf("loop %d: expected target %d, got %d", loop, expected.expectedTarget, trimmedDesiredCapacity["eks-spot"])
+		}
+		if records := countTrimRecords(); records != expected.expectedRecords {
+			t.Errorf("loop %d: expected %d trim records, got %d", loop, expected.expectedRecords, records)
+		}
+	}
+
+	// Without tracker, as in the simulations, every trim is recorded
+	ApplyBoostBudgets(h.Ctx, nil, autoscalingGroupPool, NewNodePool(), nil, map[string]int{"eks-spot": 6})
+	ApplyBoostBudgets(h.Ctx, nil, autoscalingGroupPool, NewNodePool(), nil, map[string]int{"eks-spot": 6})
+	if records := countTrimRecords(); records != 4 {
+		t.Errorf("expected every trim to be recorded without tracker, got %d records", records)
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"net/http"
+	"time"
+)
+
+const (
+	// ClusterCircuitBreakerName is the name used to refer to the cluster-wide circuit breaker
+	ClusterCircuitBreakerName = "cluster"
+
+	// Reasons for the alert events emitted by the circuit breaker
+	CircuitBreakerTrippedReason = "CircuitBreakerTripped"
+	CircuitBreakerResetReason   = "CircuitBreakerReset"
+
+	// Info messages
+	CircuitBreakerResetMessage        = "circuit breaker reset for '%s'"
+	CircuitBreakerSkippedBoostMessage = "skipping changes for asg '%s': circuit breaker is tripped"
+
+	// Error messages
+	CircuitBreakerTrippedMessage     = "circuit breaker tripped for '%s': %s. boosting is frozen for %s"
+	CircuitBreakerTooManyBoosts      = "%d boosts in the last %s (max %d)"
+	CircuitBreakerTooManyFreshEvents = "%d events from freshly launched nodes in the last %s (max %d)"
+)
+
+// NewCircuitBreaker return a circuit breaker ready to be used
+func NewCircuitBreaker() *CircuitBreaker {
+	return &CircuitBreaker{
+		Boosts:          map[string][]time.Time{},
+		FreshNodeEvents: map[string][]time.Time{},
+		SeenEvents:      map[string]time.Time{},
+		Tripped:         map[string]time.Time{},
+	}
+}
+
+// pruneTimes return only the moments that are inside the window
+func pruneTimes(times []time.Time, window time.Duration) (prunedTimes []time.Time) {
+	for _, moment := range times {
+		if time.Since(moment) <= window {
+			prunedTimes = append(prunedTimes, moment)
+		}
+	}
+	return prunedTimes
+}
+
+// pruneSeenEvents forget the events reviewed more than a window ago that are not in the pool anymore.
+// Events still in the pool are kept, even out of the window, so they are never counted twice
+func pruneSeenEvents(seenEvents map[string]time.Time, currentEvents []*v1.Event, window time.Duration) {
+	currentUIDs := map[string]bool{}
+	for _, event := range currentEvents {
+		currentUIDs[string(event.UID)] = true
+	}
+
+	for uid, seenAt := range seenEvents {
+		if !currentUIDs[uid] && time.Since(seenAt) > window {
+			delete(seenEvents, uid)
+		}
+	}
+}
+
+// IsBoostAllowed return false when the circuit breaker is tripped for an ASG or for the whole cluster
+func IsBoostAllowed(circuitBreaker *CircuitBreaker, autoscalingGroupName string) bool {
+	circuitBreaker.Lock.Lock()
+	defer circuitBreaker.Lock.Unlock()
+
+	_, asgTripped := circuitBreaker.Tripped[autoscalingGroupName]
+	return !asgTripped && circuitBreaker.ClusterTripped.IsZero()
+}
+
+// RecordBoost count a boost applied to an ASG. Boosts are only applied when they increase the desired capacity
+// on AWS, so each of them counts, even when the target is the same one applied before a scale-down
+func RecordBoost(circuitBreaker *CircuitBreaker, autoscalingGroupName string) {
+	circuitBreaker.Lock.Lock()
+	defer circuitBreaker.Lock.Unlock()
+
+	now := time.Now()
+	circuitBreaker.Boosts[autoscalingGroupName] = append(circuitBreaker.Boosts[autoscalingGroupName], now)
+	circuitBreaker.ClusterBoosts = append(circuitBreaker.ClusterBoosts, now)
+}
+
+// RecordFreshNodeEvents count the events coming from nodes that were launched recently, once per event.
+// Those are usually the replacements launched by a previous boost, which are at risk again
+func RecordFreshNodeEvents(ctx *Ctx, circuitBreaker *CircuitBreaker, eventPool *EventPool, nodePool *NodePool, autoscalingGroupPool *AutoscalingGroupPool) {
+
+	// Look for the nodegroups of the nodes involved in new events
+	freshNodeEventsByNodeGroup := map[string]int{}
+
+	events := eventPool.Snapshot()
+
+	circuitBreaker.Lock.Lock()
+	pruneSeenEvents(circuitBreaker.SeenEvents, events, *ctx.Flags.CircuitBreakerWindow)
+
+	for _, event := range events {
+		if _, seen := circuitBreaker.SeenEvents[string(event.UID)]; seen {
+			continue
+		}
+		circuitBreaker.SeenEvents[string(event.UID)] = time.Now()
+
+		node, found := nodePool.Get(event.InvolvedObject.Name)
+		if !found {
+			continue
+		}
+
+		nodeAge := event.CreationTimestamp.Sub(node.CreationTimestamp.Time)
+		if nodeAge < -*ctx.Flags.MaxTimeConsiderNewNodes {
+			freshNodeEventsByNodeGroup[node.Labels[AWSNodeGroupLabel]]++
+		}
+	}
+	circuitBreaker.Lock.Unlock()
+
+	for nodeGroupName, freshNodeEvents := range freshNodeEventsByNodeGroup {
+		autoscalingGroupName := GetAutoscalingGroupNameByNodeGroup(autoscalingGroupPool, nodeGroupName)
+		if autoscalingGroupName == "" {
+			continue
+		}
+
+		circuitBreaker.Lock.Lock()
+		for i := 0; i < freshNodeEvents; i++ {
+			circuitBreaker.FreshNodeEvents[autoscalingGroupName] = append(circuitBreaker.FreshNodeEvents[autoscalingGroupName], time.Now())
+		}
+		circuitBreaker.Lock.Unlock()
+	}
+}
+
+// EvaluateCircuitBreaker trip the circuit breaker for those ASGs (or the whole cluster) exceeding the thresholds,
+// and reset those that were tripped more than a cool-down ago
+func EvaluateCircuitBreaker(ctx *Ctx, circuitBreaker *CircuitBreaker) {
+
+	window := *ctx.Flags.CircuitBreakerWindow
+	cooldown := *ctx.Flags.CircuitBreakerCooldown
+
+	var tripReasons = map[string]string{}
+	var resets []string
+
+	circuitBreaker.Lock.Lock()
+
+	// Forget about everything out of the window
+	for autoscalingGroupName, boosts := range circuitBreaker.Boosts {
+		circuitBreaker.Boosts[autoscalingGroupName] = pruneTimes(boosts, window)
+	}
+	for autoscalingGroupName, events := range circuitBreaker.FreshNodeEvents {
+		circuitBreaker.FreshNodeEvents[autoscalingGroupName] = pruneTimes(events, window)
+	}
+	circuitBreaker.ClusterBoosts = pruneTimes(circuitBreaker.ClusterBoosts, window)
+
+	// Reset tripped breakers after the cool-down
+	for autoscalingGroupName, trippedAt := range circuitBreaker.Tripped {
+		if time.Since(trippedAt) > cooldown {
+			delete(circuitBreaker.Tripped, autoscalingGroupName)
+			resets = append(resets, autoscalingGroupName)
+		}
+	}
+	if !circuitBreaker.ClusterTripped.IsZero() && time.Since(circuitBreaker.ClusterTripped) > cooldown {
+		circuitBreaker.ClusterTripped = time.Time{}
+		resets = append(resets, ClusterCircuitBreakerName)
+	}
+
+	// Trip the breakers exceeding the thresholds
+	maxBoostsPerASG := *ctx.Flags.CircuitBreakerMaxBoostsPerASG
+	for autoscalingGroupName, boosts := range circuitBreaker.Boosts {
+		if _, tripped := circuitBreaker.Tripped[autoscalingGroupName]; tripped || maxBoostsPerASG <= 0 {
+			continue
+		}
+		if len(boosts) > maxBoostsPerASG {
+			tripReasons[autoscalingGroupName] = fmt.Sprintf(CircuitBreakerTooManyBoosts, len(boosts), window, maxBoostsPerASG)
+		}
+	}
+
+	maxFreshNodesEvents := *ctx.Flags.CircuitBreakerMaxFreshNodesEvents
+	for autoscalingGroupName, events := range circuitBreaker.FreshNodeEvents {
+		if _, tripped := circuitBreaker.Tripped[autoscalingGroupName]; tripped || maxFreshNodesEvents <= 0 {
+			continue
+		}
+		if len(events) > maxFreshNodesEvents {
+			tripReasons[autoscalingGroupName] = fmt.Sprintf(CircuitBreakerTooManyFreshEvents, len(events), window, maxFreshNodesEvents)
+		}
+	}
+
+	maxBoostsCluster := *ctx.Flags.CircuitBreakerMaxBoostsCluster
+	if circuitBreaker.ClusterTripped.IsZero() && maxBoostsCluster > 0 && len(circuitBreaker.ClusterBoosts) > maxBoostsCluster {
+		tripReasons[ClusterCircuitBreakerName] = fmt.Sprintf(CircuitBreakerTooManyBoosts, len(circuitBreaker.ClusterBoosts), window, maxBoostsCluster)
+	}
+
+	for name := range tripReasons {
+		if name == ClusterCircuitBreakerName {
+			circuitBreaker.ClusterTripped = time.Now()
+			continue
+		}
+		circuitBreaker.Tripped[name] = time.Now()
+	}
+
+	circuitBreaker.Lock.Unlock()
+
+	// Alert about the changes
+	for _, name := range resets {
+		ctx.Logger.Infof(CircuitBreakerResetMessage, name)
+		mCircuitBreakerTripped.WithLabelValues(name).Set(0)
+		RecordAutoscalingGroupEvent(ctx, name, v1.EventTypeNormal, CircuitBreakerResetReason, CircuitBreakerResetMessage, name)
+	}
+
+	for name, reason := range tripReasons {
+		ctx.Logger.Warnf(CircuitBreakerTrippedMessage, name, reason, cooldown)
+		mCircuitBreakerTripped.WithLabelValues(name).Set(1)
+		mCircuitBreakerTripsTotal.WithLabelValues(name).Inc()
+
+		RecordAutoscalingGroupEvent(ctx, name, v1.EventTypeWarning, CircuitBreakerTrippedReason,
+			CircuitBreakerTrippedMessage, name, reason, cooldown)
+	}
+}
+
+// ResetCircuitBreaker reset the circuit breaker for an ASG, or all of them when the name is empty
+func ResetCircuitBreaker(circuitBreaker *CircuitBreaker, autoscalingGroupName string) (resets []string) {
+	circuitBreaker.Lock.Lock()
+	defer circuitBreaker.Lock.Unlock()
+
+	for name := range circuitBreaker.Tripped {
+		if autoscalingGroupName == "" || autoscalingGroupName == name {
+			delete(circuitBreaker.Tripped, name)
+			delete(circuitBreaker.Boosts, name)
+			delete(circuitBreaker.FreshNodeEvents, name)
+			resets = append(resets, name)
+		}
+	}
+
+	if (autoscalingGroupName == "" || autoscalingGroupName == ClusterCircuitBreakerName) && !circuitBreaker.ClusterTripped.IsZero() {
+		circuitBreaker.ClusterTripped = time.Time{}
+		circuitBreaker.ClusterBoosts = nil
+		resets = append(resets, ClusterCircuitBreakerName)
+	}
+
+	return resets
+}
+
+// IsAdminRequestAuthorized return true when a request carries the admin token as bearer, or no token is configured
+func IsAdminRequestAuthorized(ctx *Ctx, request *http.Request) bool {
+	if *ctx.Flags.AdminToken == "" {
+		return true
+	}
+
+	expected := "Bearer " + *ctx.Flags.AdminToken
+	return subtle.ConstantTimeCompare([]byte(request.Header.Get("Authorization")), []byte(expected)) == 1
+}
+
+// CircuitBreakerResetHandler return an HTTP handler to reset the circuit breaker manually
+// Usage: POST /circuit-breaker/reset?autoscaling-group=<name>. All the breakers are reset when no name is given
+func CircuitBreakerResetHandler(ctx *Ctx, circuitBreaker *CircuitBreaker) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost {
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		if !IsAdminRequestAuthorized(ctx, request) {
+			writer.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		resets := ResetCircuitBreaker(circuitBreaker, request.URL.Query().Get("autoscaling-group"))
+		for _, name := range resets {
+			ctx.Logger.Infof(CircuitBreakerResetMessage, name)
+			mCircuitBreakerTripped.WithLabelValues(name).Set(0)
+
+			RecordAutoscalingGroupEvent(ctx, name, v1.EventTypeNormal, CircuitBreakerResetReason, CircuitBreakerResetMessage, name)
+		}
+
+		writer.WriteHeader(http.StatusOK)
+		_, _ = fmt.Fprintf(writer, "%v\n", resets)
+	}
+}
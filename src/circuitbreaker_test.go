@@ -0,0 +1,137 @@
+package main
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newSeenTestEventPool return an event pool with an event of a node, identified by its UID
+func newSeenTestEventPool(uid string) *EventPool {
+	eventPool := NewEventPool()
+	eventPool.Upsert(&v1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: uid, Namespace: "default", UID: types.UID(uid)},
+		InvolvedObject: v1.ObjectReference{Name: "node-1"},
+	})
+	return eventPool
+}
+
+func TestCircuitBreakerForgetsSeenEvents(t *testing.T) {
+	h := NewHarness(t)
+	circuitBreaker := NewCircuitBreaker()
+	eventPool := newSeenTestEventPool("current")
+
+	outOfWindow := time.Now().Add(-2 * *h.Ctx.Flags.CircuitBreakerWindow)
+	circuitBreaker.SeenEvents["current"] = outOfWindow
+	circuitBreaker.SeenEvents["gone"] = outOfWindow
+	circuitBreaker.SeenEvents["gone-recently"] = time.Now()
+
+	RecordFreshNodeEvents(h.Ctx, circuitBreaker, eventPool, NewNodePool(), NewAutoscalingGroupPool())
+
+	// Events still in the pool are kept even out of the window, so they are not counted again
+	for uid, expected := range map[string]bool{"current": true, "gone": false, "gone-recently": true} {
+		if _, found := circuitBreaker.SeenEvents[uid]; found != expected {
+			t.Errorf("expected seen event '%s' to be kept: %t", uid, expected)
+		}
+	}
+}
+
+func TestReplacementRiskTrackerForgetsSeenEvents(t *testing.T) {
+	h := NewHarness(t)
+	tracker := NewReplacementRiskTracker()
+	eventPool := newSeenTestEventPool("current")
+
+	outOfWindow := time.Now().Add(-2 * *h.Ctx.Flags.ReplacementRiskWindow)
+	tracker.SeenEvents["current"] = outOfWindow
+	tracker.SeenEvents["gone"] = outOfWindow
+
+	RecordReplacementsAtRisk(h.Ctx, tracker, eventPool, NewNodePool(), NewAutoscalingGroupPool())
+
+	if _, found := tracker.SeenEvents["current"]; !found {
+		t.Errorf("seen event still in the pool was forgotten")
+	}
+	if _, found := tracker.SeenEvents["gone"]; found {
+		t.Errorf("seen event gone out of the window was kept")
+	}
+}
+
+func TestCircuitBreakerResetHandlerRequiresToken(t *testing.T) {
+	h := NewHarness(t, "--admin-token", "s3cr3t")
+	handler := CircuitBreakerResetHandler(h.Ctx, NewCircuitBreaker())
+
+	tests := []struct {
+		name           string
+		method         string
+		authorization  string
+		expectedStatus int
+	}{
+		{"without token", http.MethodPost, "", http.StatusUnauthorized},
+		{"wrong token", http.MethodPost, "Bearer wrong", http.StatusUnauthorized},
+		{"right token", http.MethodPost, "Bearer s3cr3t", http.StatusOK},
+		{"wrong method", http.MethodGet, "Bearer s3cr3t", http.StatusMethodNotAllowed},
+	}
+
+	for _, test := range tests {
+		request := httptest.NewRequest(test.method, "/circuit-breaker/reset?autoscaling-group=eks-spot", nil)
+		if test.authorization != "" {
+			request.Header.Set("Authorization", test.authorization)
+		}
+
+		recorder := httptest.NewRecorder()
+		handler(recorder, request)
+		if recorder.Code != test.expectedStatus {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.expectedStatus, recorder.Code)
+		}
+	}
+}
+
+func TestCircuitBreakerResetKeepsReplacementBoosts(t *testing.T) {
+	circuitBreaker := NewCircuitBreaker()
+	tracker := NewReplacementRiskTracker()
+
+	RecordBoost(circuitBreaker, "eks-spot")
+	RecordReplacementBoost(tracker, "eks-spot")
+	circuitBreaker.Tripped["eks-spot"] = time.Now()
+
+	if resets := ResetCircuitBreaker(circuitBreaker, "eks-spot"); len(resets) != 1 {
+		t.Fatalf("expected the breaker of the asg to be reset, got %v", resets)
+	}
+	if len(circuitBreaker.Boosts["eks-spot"]) != 0 {
+		t.Errorf("expected the boosts counted by the breaker to be forgotten on reset")
+	}
+
+	// Nodes launched by the boost are still linked to it
+	if _, found := GetBoostLaunchingNode(tracker, "eks-spot", time.Now().Add(time.Minute), 10*time.Minute); !found {
+		t.Errorf("the boost was forgotten by the replacements tracker on reset")
+	}
+}
+
+func TestReplacementBackoffThreshold(t *testing.T) {
+	h := NewHarness(t, "--max-replacements-at-risk", "2")
+
+	tests := []struct {
+		occurrences     int
+		expectedBackoff bool
+	}{
+		{occurrences: 1, expectedBackoff: false},
+		{occurrences: 2, expectedBackoff: false},
+		{occurrences: 3, expectedBackoff: true},
+	}
+
+	for _, test := range tests {
+		tracker := NewReplacementRiskTracker()
+		for i := 0; i < test.occurrences; i++ {
+			tracker.Occurrences["eks-spot"] = append(tracker.Occurrences["eks-spot"], time.Now())
+		}
+
+		EvaluateReplacementsAtRisk(h.Ctx, tracker)
+		if backedOff := IsBoostBackedOff(tracker, "eks-spot"); backedOff != test.expectedBackoff {
+			t.Errorf("%d replacements under risk with 2 allowed: expected back off %t, got %t",
+				test.occurrences, test.expectedBackoff, backedOff)
+		}
+	}
+}
@@ -1,33 +1,55 @@
 package main
 
 import (
-	"fmt"
+	"context"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"golang.org/x/exp/maps"
 	_ "golang.org/x/exp/slices"
+	v1 "k8s.io/api/core/v1"
 	"k8s.io/utils/strings/slices"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 )
 
 const (
+	// Info messages
+	TargetRejectedMessage        = "calculated target '%d' for asg '%s' rejected: not greater than current desired capacity '%d' on aws"
+	TargetRejectedUnknownMessage = "calculated target '%d' for asg '%s' rejected: current desired capacity is unknown on aws"
+	TagsChangedMessage           = "tags changed on aws for asg '%s'"
+
+	// Error messages
+	CurrentDesiredCapacityErrorMessage = "impossible to get current desired capacity of the asgs from aws, skipping changes: %v"
+	DescribeTagsErrorMessage           = "impossible to get the tags of the asgs from aws, keeping the previous ones: %v"
+
 	// Constants related to the cloud provider
 	AWSAutoscalingGroupsNodeGroupTag = "eks:nodegroup-name"
 
+	// Limits of the requests to describe the tags: names of ASGs sent per filter, and tags received per page
+	DescribeTagsMaxFilterValues = 100
+	DescribeTagsMaxRecords      = 100
+
 	// Constants related to processes
 	ASGWatcherTriesBeforeFailing             = 2
 	ASGWatcherSecondsBetweenTries            = 5
 	ASGWatcherSecondsBetweenSynchronizations = 5
 )
 
-// WatchAutoScalingGroupsTags TODO
-func WatchAutoScalingGroupsTags(ctx *Ctx, awsClient *session.Session, autoscalingGroupPool *AutoscalingGroupPool) {
+// WatchAutoScalingGroupsTags keep the tags of the ASGs in the pool up-to-date with AWS.
+// Tags are cached for a while, so only those of new ASGs or expired ones are described on each synchronization
+// This function must be executed as a go routine
+func WatchAutoScalingGroupsTags(ctx *Ctx, awsClient *AwsClient, autoscalingGroupPool *AutoscalingGroupPool) {
 
 	var autoscalingGroupNames []string
 
+	tagsCache := NewAutoscalingGroupTagsCache(*ctx.Flags.AutoscalingGroupTagsTTL)
+
 	for {
 		// Try to get ASG names from memory several times
 		for try := 0; try <= ASGWatcherTriesBeforeFailing; try++ {
@@ -44,42 +66,90 @@ func WatchAutoScalingGroupsTags(ctx *Ctx, awsClient *session.Session, autoscalin
 			time.Sleep(ASGWatcherSecondsBetweenTries * time.Second)
 		}
 
-		// Get ASGs tags from AWS
-		tagsOutput, err := AwsDescribeAutoScalingGroupsTags(awsClient, autoscalingGroupNames)
-		if err != nil {
-			ctx.Logger.Info("say something") // TODO Improve logging
+		// Get the tags of the ASGs not cached from AWS. Those described before an error are cached anyway
+		expiredAutoscalingGroupNames := GetExpiredAutoscalingGroupNames(tagsCache, autoscalingGroupNames, time.Now())
+		if len(expiredAutoscalingGroupNames) > 0 {
+			asgGroupedTags, err := AwsDescribeAutoScalingGroupsTags(awsClient, expiredAutoscalingGroupNames)
+			if err != nil {
+				ctx.Logger.Infof(DescribeTagsErrorMessage, err)
+			}
+
+			for _, autoscalingGroupName := range StoreAutoscalingGroupTags(tagsCache, asgGroupedTags, time.Now()) {
+				ctx.Logger.Debugf(TagsChangedMessage, autoscalingGroupName)
+			}
 		}
 
-		// Group tags by ASG name
-		asgGroupedTags := map[string]map[string]string{}
-		for _, tag := range tagsOutput.Tags {
+		// Store the tags into the actual ASGs object, only changing those that differ
+		autoscalingGroupPool.SetTags(GetCachedAutoscalingGroupTags(tagsCache, autoscalingGroupNames))
 
-			_, asgKeyFound := asgGroupedTags[*tag.ResourceId]
-			if !asgKeyFound {
-				asgGroupedTags[*tag.ResourceId] = map[string]string{}
-			}
+		time.Sleep(ASGWatcherSecondsBetweenSynchronizations * time.Second)
+	}
+}
+
+// NewAutoscalingGroupTagsCache return an empty cache for the tags of the ASGs
+func NewAutoscalingGroupTagsCache(ttl time.Duration) *AutoscalingGroupTagsCache {
+	return &AutoscalingGroupTagsCache{
+		TTL:         ttl,
+		Tags:        map[string]map[string]string{},
+		DescribedAt: map[string]time.Time{},
+	}
+}
 
-			asgGroupedTags[*tag.ResourceId][*tag.Key] = *tag.Value
+// GetExpiredAutoscalingGroupNames return the names of a list of ASGs whose tags are not cached or expired
+func GetExpiredAutoscalingGroupNames(tagsCache *AutoscalingGroupTagsCache, autoscalingGroupNames []string, now time.Time) (expired []string) {
+	for _, autoscalingGroupName := range autoscalingGroupNames {
+		describedAt, found := tagsCache.DescribedAt[autoscalingGroupName]
+		if !found || now.Sub(describedAt) >= tagsCache.TTL {
+			expired = append(expired, autoscalingGroupName)
 		}
+	}
+
+	return expired
+}
 
-		// Store the tags into the actual ASGs object
-		// Doing this way to block the pool the minimum time possible
-		for _, asg := range autoscalingGroupPool.AutoscalingGroups {
-			autoscalingGroupPool.Lock.Lock()
-			asg.Tags = asgGroupedTags[asg.Name]
-			autoscalingGroupPool.Lock.Unlock()
+// StoreAutoscalingGroupTags cache the tags described for some ASGs, returning the names of those whose tags changed
+func StoreAutoscalingGroupTags(tagsCache *AutoscalingGroupTagsCache, asgGroupedTags map[string]map[string]string, now time.Time) (changed []string) {
+	for autoscalingGroupName, tags := range asgGroupedTags {
+		cachedTags, found := tagsCache.Tags[autoscalingGroupName]
+		if !found || !maps.Equal(cachedTags, tags) {
+			changed = append(changed, autoscalingGroupName)
+			tagsCache.Tags[autoscalingGroupName] = tags
 		}
+		tagsCache.DescribedAt[autoscalingGroupName] = now
+	}
 
-		time.Sleep(ASGWatcherSecondsBetweenSynchronizations * time.Second)
+	sort.Strings(changed)
+	return changed
+}
+
+// GetCachedAutoscalingGroupTags return the cached tags of a list of ASGs, forgetting those of the ASGs not present anymore
+func GetCachedAutoscalingGroupTags(tagsCache *AutoscalingGroupTagsCache, autoscalingGroupNames []string) (asgGroupedTags map[string]map[string]string) {
+
+	asgGroupedTags = map[string]map[string]string{}
+	for _, autoscalingGroupName := range autoscalingGroupNames {
+		if tags, found := tagsCache.Tags[autoscalingGroupName]; found {
+			asgGroupedTags[autoscalingGroupName] = tags
+		}
 	}
+
+	for autoscalingGroupName := range tagsCache.Tags {
+		if _, found := asgGroupedTags[autoscalingGroupName]; !found {
+			delete(tagsCache.Tags, autoscalingGroupName)
+			delete(tagsCache.DescribedAt, autoscalingGroupName)
+		}
+	}
+
+	return asgGroupedTags
 }
 
-// AwsCreateSession TODO
-func AwsCreateSession() (*session.Session, error) {
+// AwsCreateSession return the clients of the AWS services used by the controller, sharing a new session.
+// The calls are rate limited and retried by the clients following the policy, instead of by the SDK
+func AwsCreateSession(retryPolicy AwsRetryPolicy) (*AwsClient, error) {
 
 	awsSession, err := session.NewSessionWithOptions(session.Options{
 		Config: aws.Config{
 			CredentialsChainVerboseErrors: aws.Bool(true),
+			MaxRetries:                    aws.Int(0),
 		},
 		SharedConfigState: session.SharedConfigEnable,
 	})
@@ -87,53 +157,106 @@ func AwsCreateSession() (*session.Session, error) {
 	// Specify profile for config and region for requests
 	client := session.Must(awsSession, err)
 
-	return client, err
+	// Measure all the calls done to AWS, tracing those done inside a trace
+	addAwsApiCallHandlers(&client.Handlers)
+
+	awsClient := &AwsClient{
+		AutoScaling: autoscaling.New(client),
+		EC2:         ec2.New(client),
+	}
+
+	return NewRateLimitedAwsClient(awsClient, retryPolicy), err
+}
+
+// addAwsApiCallHandlers add the handlers to measure the calls done to AWS API, tracing those done inside a trace
+func addAwsApiCallHandlers(handlers *request.Handlers) {
+	handlers.Validate.PushFrontNamed(request.NamedHandler{
+		Name: "aws-spots-booster/tracing",
+		Fn:   startAwsApiCallSpan,
+	})
+	handlers.Complete.PushBackNamed(request.NamedHandler{
+		Name: "aws-spots-booster/tracing",
+		Fn:   endAwsApiCallSpan,
+	})
+	handlers.Complete.PushBackNamed(request.NamedHandler{
+		Name: "aws-spots-booster/metrics",
+		Fn:   recordAwsApiCall,
+	})
 }
 
-// AwsDescribeAutoScalingGroupsTags TODO
-func AwsDescribeAutoScalingGroupsTags(awsClient *session.Session, autoscalingGroupNames []string) (tagsOutput *autoscaling.DescribeTagsOutput, err error) {
-	svc := autoscaling.New(awsClient)
+// recordAwsApiCall update the metrics about AWS API calls once a request is complete. Each retry is a new request
+func recordAwsApiCall(r *request.Request) {
+	service := r.ClientInfo.ServiceName
+	operation := r.Operation.Name
 
-	var parsedAutoscalingGroupNames []*string
-	for _, autoscalingGroupName := range autoscalingGroupNames {
-		parsedAutoscalingGroupNames = append(parsedAutoscalingGroupNames, aws.String(autoscalingGroupName))
+	mAwsApiCallsTotal.WithLabelValues(service, operation).Inc()
+	mAwsApiCallDurationSeconds.WithLabelValues(service, operation).Observe(time.Since(r.Time).Seconds())
+
+	if r.Error == nil {
+		return
 	}
 
-	input := &autoscaling.DescribeTagsInput{
-		Filters: []*autoscaling.Filter{
-			{
-				Name:   aws.String("auto-scaling-group"),
-				Values: parsedAutoscalingGroupNames,
-			},
-		},
+	mAwsApiErrorsTotal.WithLabelValues(service, operation, GetAwsErrorCode(r.Error)).Inc()
+
+	if request.IsErrorThrottle(r.Error) {
+		mAwsApiThrottlesTotal.WithLabelValues(service, operation).Inc()
 	}
+}
 
-	tagsOutput, err = svc.DescribeTags(input)
-	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case autoscaling.ErrCodeInvalidNextToken:
-				fmt.Println(autoscaling.ErrCodeInvalidNextToken, aerr.Error())
-			case autoscaling.ErrCodeResourceContentionFault:
-				fmt.Println(autoscaling.ErrCodeResourceContentionFault, aerr.Error())
-			default:
-				fmt.Println(aerr.Error())
+// AwsDescribeAutoScalingGroupsTags return the tags of a list of ASGs grouped by their name, going through all the pages.
+// The names are sent in batches, as the values allowed per filter are limited.
+// On errors, the tags of the batches already described are returned too
+func AwsDescribeAutoScalingGroupsTags(awsClient *AwsClient, autoscalingGroupNames []string) (asgGroupedTags map[string]map[string]string, err error) {
+	svc := awsClient.AutoScaling
+
+	asgGroupedTags = map[string]map[string]string{}
+
+	for batchStart := 0; batchStart < len(autoscalingGroupNames); batchStart += DescribeTagsMaxFilterValues {
+		batchEnd := batchStart + DescribeTagsMaxFilterValues
+		if batchEnd > len(autoscalingGroupNames) {
+			batchEnd = len(autoscalingGroupNames)
+		}
+		batch := autoscalingGroupNames[batchStart:batchEnd]
+
+		input := &autoscaling.DescribeTagsInput{
+			Filters: []*autoscaling.Filter{
+				{
+					Name:   aws.String("auto-scaling-group"),
+					Values: aws.StringSlice(batch),
+				},
+			},
+			MaxRecords: aws.Int64(DescribeTagsMaxRecords),
+		}
+
+		// ASGs without tags are described too, so they are not described again until they expire
+		batchTags := map[string]map[string]string{}
+		for _, autoscalingGroupName := range batch {
+			batchTags[autoscalingGroupName] = map[string]string{}
+		}
+
+		err = svc.DescribeTagsPages(input, func(output *autoscaling.DescribeTagsOutput, lastPage bool) bool {
+			for _, tag := range output.Tags {
+				if tags, found := batchTags[aws.StringValue(tag.ResourceId)]; found {
+					tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+				}
 			}
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			fmt.Println(err.Error())
+			return true
+		})
+		if err != nil {
+			return asgGroupedTags, err
 		}
-		return tagsOutput, err
+
+		maps.Copy(asgGroupedTags, batchTags)
 	}
 
-	return tagsOutput, err
+	return asgGroupedTags, nil
 }
 
 // AwsSetDesiredCapacity set the desired capacity for an Auto Scaling group
-func AwsSetDesiredCapacity(awsClient *session.Session, asgName string, desiredCapacity int64) error {
+// The span carried by the context, if any, is used as parent of the call
+func AwsSetDesiredCapacity(spanCtx context.Context, awsClient *AwsClient, asgName string, desiredCapacity int64) error {
 
-	svc := autoscaling.New(awsClient)
+	svc := awsClient.AutoScaling
 
 	input := &autoscaling.SetDesiredCapacityInput{
 		AutoScalingGroupName: aws.String(asgName),
@@ -141,57 +264,141 @@ func AwsSetDesiredCapacity(awsClient *session.Session, asgName string, desiredCa
 		HonorCooldown:        aws.Bool(false),
 	}
 
-	_, err := svc.SetDesiredCapacity(input)
-	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case autoscaling.ErrCodeScalingActivityInProgressFault:
-				fmt.Println(autoscaling.ErrCodeScalingActivityInProgressFault, aerr.Error())
-			case autoscaling.ErrCodeResourceContentionFault:
-				fmt.Println(autoscaling.ErrCodeResourceContentionFault, aerr.Error())
-			default:
-				fmt.Println(aerr.Error())
-			}
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			fmt.Println(err.Error())
-		}
-		return err
-	}
-
+	_, err := svc.SetDesiredCapacityWithContext(spanCtx, input)
 	return err
 }
 
-// TODO
-func AwsTerminateInstance(awsClient *session.Session, instanceName string) error {
-	svc := autoscaling.New(awsClient)
+// AwsTerminateInstance terminate an instance of an Auto Scaling group, decrementing its desired capacity
+// The span carried by the context, if any, is used as parent of the call
+func AwsTerminateInstance(spanCtx context.Context, awsClient *AwsClient, instanceName string) error {
+	svc := awsClient.AutoScaling
 
 	input := &autoscaling.TerminateInstanceInAutoScalingGroupInput{
 		InstanceId:                     aws.String(instanceName),
 		ShouldDecrementDesiredCapacity: aws.Bool(true),
 	}
 
-	_, err := svc.TerminateInstanceInAutoScalingGroup(input)
+	_, err := svc.TerminateInstanceInAutoScalingGroupWithContext(spanCtx, input)
+	return err
+}
+
+// AwsSetAutoScalingGroupTag create or update a tag on an ASG, not propagated to its instances
+func AwsSetAutoScalingGroupTag(awsClient *AwsClient, asgName string, key string, value string) error {
+	svc := awsClient.AutoScaling
+
+	input := &autoscaling.CreateOrUpdateTagsInput{
+		Tags: []*autoscaling.Tag{
+			{
+				ResourceId:        aws.String(asgName),
+				ResourceType:      aws.String("auto-scaling-group"),
+				Key:               aws.String(key),
+				Value:             aws.String(value),
+				PropagateAtLaunch: aws.Bool(false),
+			},
+		},
+	}
+
+	_, err := svc.CreateOrUpdateTags(input)
+	return err
+}
+
+// AwsDeleteAutoScalingGroupTag delete a tag from an ASG
+func AwsDeleteAutoScalingGroupTag(awsClient *AwsClient, asgName string, key string) error {
+	svc := awsClient.AutoScaling
+
+	input := &autoscaling.DeleteTagsInput{
+		Tags: []*autoscaling.Tag{
+			{
+				ResourceId:   aws.String(asgName),
+				ResourceType: aws.String("auto-scaling-group"),
+				Key:          aws.String(key),
+			},
+		},
+	}
+
+	_, err := svc.DeleteTags(input)
+	return err
+}
+
+// AwsDescribeAutoScalingGroups return the details of a list of ASGs, going through all the pages
+func AwsDescribeAutoScalingGroups(awsClient *AwsClient, autoscalingGroupNames []string) (autoscalingGroups []*autoscaling.Group, err error) {
+	svc := awsClient.AutoScaling
+
+	input := &autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: aws.StringSlice(autoscalingGroupNames),
+	}
+
+	err = svc.DescribeAutoScalingGroupsPages(input, func(output *autoscaling.DescribeAutoScalingGroupsOutput, lastPage bool) bool {
+		autoscalingGroups = append(autoscalingGroups, output.AutoScalingGroups...)
+		return true
+	})
+
+	return autoscalingGroups, err
+}
+
+// AwsGetAutoScalingGroupsDesiredCapacity return a map with the names of the ASGs and their current desired capacity on AWS
+func AwsGetAutoScalingGroupsDesiredCapacity(awsClient *AwsClient, autoscalingGroupNames []string) (desiredCapacities map[string]int, err error) {
+
+	desiredCapacities = map[string]int{}
+
+	// Describing no names would return all the ASGs of the account
+	if len(autoscalingGroupNames) == 0 {
+		return desiredCapacities, nil
+	}
+
+	autoscalingGroups, err := AwsDescribeAutoScalingGroups(awsClient, autoscalingGroupNames)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case autoscaling.ErrCodeScalingActivityInProgressFault:
-				fmt.Println(autoscaling.ErrCodeScalingActivityInProgressFault, aerr.Error())
-			case autoscaling.ErrCodeResourceContentionFault:
-				fmt.Println(autoscaling.ErrCodeResourceContentionFault, aerr.Error())
-			default:
-				fmt.Println(aerr.Error())
-			}
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			fmt.Println(err.Error())
+		return desiredCapacities, err
+	}
+
+	for _, autoscalingGroup := range autoscalingGroups {
+		desiredCapacities[aws.StringValue(autoscalingGroup.AutoScalingGroupName)] = int(aws.Int64Value(autoscalingGroup.DesiredCapacity))
+	}
+
+	return desiredCapacities, nil
+}
+
+// AwsDescribeAutoScalingInstance return the autoscaling details of an instance, or nil when it is not part of any ASG
+func AwsDescribeAutoScalingInstance(awsClient *AwsClient, instanceId string) (*autoscaling.InstanceDetails, error) {
+	svc := awsClient.AutoScaling
+
+	input := &autoscaling.DescribeAutoScalingInstancesInput{
+		InstanceIds: []*string{aws.String(instanceId)},
+	}
+
+	output, err := svc.DescribeAutoScalingInstances(input)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(output.AutoScalingInstances) == 0 {
+		return nil, nil
+	}
+
+	return output.AutoScalingInstances[0], nil
+}
+
+// AwsDescribeInstance return the EC2 details of an instance, or nil when it does not exist
+func AwsDescribeInstance(awsClient *AwsClient, instanceId string) (*ec2.Instance, error) {
+	svc := awsClient.EC2
+
+	input := &ec2.DescribeInstancesInput{
+		InstanceIds: []*string{aws.String(instanceId)},
+	}
+
+	output, err := svc.DescribeInstances(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "InvalidInstanceID.NotFound" {
+			return nil, nil
 		}
-		return err
+		return nil, err
 	}
 
-	return err
+	if len(output.Reservations) == 0 || len(output.Reservations[0].Instances) == 0 {
+		return nil, nil
+	}
+
+	return output.Reservations[0].Instances[0], nil
 }
 
 // CalculateDesiredCapacityASGs return a list of ASGs, the values for them are the number of instances needed
@@ -200,7 +407,7 @@ func CalculateDesiredCapacityASGs(autoscalingGroupPool *AutoscalingGroupPool, no
 
 	asgsDesiredCapacity = map[string]int{}
 
-	for _, asg := range autoscalingGroupPool.AutoscalingGroups {
+	for _, asg := range autoscalingGroupPool.Snapshot() {
 
 		nodeGroupName := asg.Tags[AWSAutoscalingGroupsNodeGroupTag]
 		if nodeGroupEventsCount[nodeGroupName] > 0 {
@@ -219,9 +426,12 @@ func CalculateDesiredCapacityASGs(autoscalingGroupPool *AutoscalingGroupPool, no
 	return asgsDesiredCapacity, err
 }
 
-// SetDesiredCapacityASGs change DesiredCapacity field for a batch of ASGs in the cloud provider
+// SetDesiredCapacityASGs change DesiredCapacity field for a batch of ASGs in the cloud provider.
+// The calculated capacities, before the budgets, and the events by node-group are only recorded on the audit log
 // Arguments related to capacity are not pointers but explicit copies to avoid external modifications during changes
-func SetDesiredCapacityASGs(ctx *Ctx, awsClient *session.Session, autoscalingGroupPool *AutoscalingGroupPool, asgsDesiredCapacity map[string]int) (err error) {
+func SetDesiredCapacityASGs(ctx *Ctx, awsClient *AwsClient, autoscalingGroupPool *AutoscalingGroupPool, circuitBreaker *CircuitBreaker,
+	replacementRiskTracker *ReplacementRiskTracker, reconciler *Reconciler, asgsCalculatedCapacity map[string]int,
+	nodeGroupEventsCount map[string]int, asgsDesiredCapacity map[string]int) (err error) {
 
 	// Get ignored node-groups from flags
 	ignoredAsgs := strings.Split(*ctx.Flags.IgnoredAutoscalingGroups, ",")
@@ -233,38 +443,198 @@ func SetDesiredCapacityASGs(ctx *Ctx, awsClient *session.Session, autoscalingGro
 		return
 	}
 
+	// Skip the ASGs whose target was already applied, so AWS is not called when nothing changed.
+	// Those not targeted anymore are forgotten, to be applied again when they are targeted later
+	ForgetAppliedCapacities(reconciler, maps.Keys(asgsDesiredCapacity)...)
+
+	changedAsgsDesiredCapacity := map[string]int{}
+	for asgName, asgDesiredCapacity := range asgsDesiredCapacity {
+		target := asgDesiredCapacity + *ctx.Flags.ExtraNodesOverCalculations
+		if target > asgsMaxCapacity[asgName] {
+			target = asgsMaxCapacity[asgName]
+		}
+
+		if IsCapacityApplied(reconciler, asgName, target) {
+			ctx.Logger.Debugf(UnchangedTargetMessage, asgName, target)
+			continue
+		}
+		changedAsgsDesiredCapacity[asgName] = asgDesiredCapacity
+	}
+	asgsDesiredCapacity = changedAsgsDesiredCapacity
+
+	// On dry-run, the plan is replaced even when there is nothing to do
+	if len(asgsDesiredCapacity) == 0 && !*ctx.Flags.DryRun {
+		return nil
+	}
+
+	// Get current desired capacity from AWS, as Cluster Autoscaler's numbers can be behind it
+	currentDesiredCapacities, err := AwsGetAutoScalingGroupsDesiredCapacity(awsClient, maps.Keys(asgsDesiredCapacity))
+	if err != nil {
+		ctx.Logger.Infof(CurrentDesiredCapacityErrorMessage, err)
+		return nil
+	}
+
+	// Get ready nodes from Cluster Autoscaler's status, and the events of their node-groups, to be recorded on the audit log
+	asgsReadyCount := map[string]int{}
+	asgsEventsCount := map[string]int{}
+	for _, autoscalingGroup := range autoscalingGroupPool.Snapshot() {
+		asgsReadyCount[autoscalingGroup.Name], _ = strconv.Atoi(autoscalingGroup.Health.Ready)
+		asgsEventsCount[autoscalingGroup.Name] = nodeGroupEventsCount[autoscalingGroup.Tags[AWSAutoscalingGroupsNodeGroupTag]]
+	}
+
+	// Changes intended for the ASGs, published as the plan on dry-run
+	plannedBoosts := map[string]PlannedBoost{}
+	if *ctx.Flags.DryRun {
+		defer SetPlannedBoosts(ctx, plannedBoosts)
+	}
+
 outterLoop:
 	for asgName, asgDesiredCapacity := range asgsDesiredCapacity {
 
+		auditRecord := AuditRecord{
+			AutoscalingGroup: asgName,
+			Inputs: map[string]int{
+				"calculated": asgsCalculatedCapacity[asgName],
+				"budgeted":   asgDesiredCapacity,
+				"ready":      asgsReadyCount[asgName],
+				"events":     asgsEventsCount[asgName],
+				"extra":      *ctx.Flags.ExtraNodesOverCalculations,
+				"max":        asgsMaxCapacity[asgName],
+				"current":    currentDesiredCapacities[asgName],
+			},
+		}
+
+		if currentDesiredCapacity, found := currentDesiredCapacities[asgName]; found {
+			mAutoscalingGroupDesiredCapacity.WithLabelValues(asgName).Set(float64(currentDesiredCapacity))
+		}
+
+		// Record the skipped ASGs on the audit log
+		auditSkip := func(reason string) {
+			auditRecord.Action = AuditActionBoostSkipped
+			auditRecord.Outcome = AuditOutcomeSuccess
+			auditRecord.Reason = reason
+			WriteAuditRecord(ctx, auditRecord)
+
+			plannedBoosts[asgName] = PlannedBoost{
+				AutoscalingGroup: asgName,
+				Current:          currentDesiredCapacities[asgName],
+				Intended:         currentDesiredCapacities[asgName],
+				Reason:           reason,
+			}
+		}
+
 		// Skip ASG when must be ignored by flags configuration
 		for _, ignoredASG := range ignoredAsgs {
 			if asgName == ignoredASG {
 				ctx.Logger.Infof("skipping changes for ignored asg: %s", asgName) // TODO INFO
+				auditSkip("ignored")
 				continue outterLoop
 			}
 		}
 
+		// Skip ASG when paused by its tags
+		if IsAutoscalingGroupPaused(autoscalingGroupPool, asgName) {
+			ctx.Logger.Infof(AutoscalingGroupPausedMessage, asgName)
+			auditSkip("paused")
+			continue
+		}
+
+		// Skip ASG when its topology is not safe for boosting
+		if autoscalingGroup, found := autoscalingGroupPool.Get(asgName); found && !IsTopologyBoostable(ctx, autoscalingGroup) {
+			classification := GetTopologyClassification(autoscalingGroup)
+			ctx.Logger.Infof(TopologySkippedBoostMessage, asgName, classification, AllowUnbalancedTag, AllowUnbalancedTagValue)
+			auditSkip("topology-" + classification)
+			continue
+		}
+
+		// Skip ASG when the circuit breaker is protecting it
+		if !IsBoostAllowed(circuitBreaker, asgName) {
+			ctx.Logger.Infof(CircuitBreakerSkippedBoostMessage, asgName)
+			auditSkip("circuit-breaker")
+			continue
+		}
+
+		// Skip ASG when its replacements keep receiving events
+		if IsBoostBackedOff(replacementRiskTracker, asgName) {
+			ctx.Logger.Infof(ReplacementBackoffSkipMessage, asgName)
+			auditSkip("replacements-at-risk")
+			continue
+		}
+
 		asgDesiredCapacity = asgDesiredCapacity + *ctx.Flags.ExtraNodesOverCalculations
 		ctx.Logger.Infof("setting desired capacity for '%s' to '%d'", asgName, asgDesiredCapacity) // TODO INFO
 
 		// Check whether desired capacity is into the max capacity
 		if asgDesiredCapacity > asgsMaxCapacity[asgName] {
+			auditRecord.Action = AuditActionBoostClamped
+			auditRecord.Outcome = AuditOutcomeSuccess
+			auditRecord.Reason = "max-size"
+			auditRecord.Inputs["target"] = asgDesiredCapacity
+			WriteAuditRecord(ctx, auditRecord)
+			RecordAutoscalingGroupEvent(ctx, asgName, v1.EventTypeNormal, BoostClampedReason, BoostClampedEventMessage,
+				asgName, asgDesiredCapacity, asgsMaxCapacity[asgName], "max-size")
+
 			asgDesiredCapacity = asgsMaxCapacity[asgName]
 			ctx.Logger.Infof("setting desired capacity for '%s' to the asg max '%d'", asgName, asgsMaxCapacity[asgName]) // TODO INFO
 		}
+		auditRecord.Inputs["target"] = asgDesiredCapacity
 
+		// Only increase the capacity during a boost, never shrink a group in the middle of a scale-up
+		currentDesiredCapacity, found := currentDesiredCapacities[asgName]
+		if !found {
+			ctx.Logger.Infof(TargetRejectedUnknownMessage, asgDesiredCapacity, asgName)
+			auditSkip("current-unknown")
+			continue
+		}
+		if asgDesiredCapacity <= currentDesiredCapacity {
+			ctx.Logger.Infof(TargetRejectedMessage, asgDesiredCapacity, asgName, currentDesiredCapacity)
+			auditSkip("not-increasing")
+
+			// AWS already has the capacity, so there is nothing to apply until the target changes
+			if !*ctx.Flags.DryRun {
+				RecordAppliedCapacity(reconciler, asgName, asgDesiredCapacity)
+			}
+			continue
+		}
+
+		auditRecord.Action = AuditActionBoostSet
 		if *ctx.Flags.DryRun {
+			auditRecord.Outcome = AuditOutcomeDryRun
+			WriteAuditRecord(ctx, auditRecord)
+
+			plannedBoosts[asgName] = PlannedBoost{
+				AutoscalingGroup: asgName,
+				Current:          currentDesiredCapacity,
+				Intended:         asgDesiredCapacity,
+				Reason:           auditRecord.Reason,
+			}
 			continue
 		}
 
-		// Send the request to AWS
+		// Send the request to AWS, tracing it on the nodes served by this boost
+		boostCtx, endBoostSpans := StartBoostSpans(ctx.Traces, asgName, asgDesiredCapacity)
 		err = AwsSetDesiredCapacity(
+			boostCtx,
 			awsClient,
 			asgName,
 			int64(asgDesiredCapacity))
+		endBoostSpans(err)
+
+		auditRecord.Outcome = GetAuditOutcome(err)
+		auditRecord.Error = GetAuditErrorString(err)
+		WriteAuditRecord(ctx, auditRecord)
+
 		if err != nil {
 			ctx.Logger.Infof("impossible to reflect changes on aws asg '%s': %v", asgName, err) // TODO ERROR
+			continue
 		}
+
+		RecordBoost(circuitBreaker, asgName)
+		RecordReplacementBoost(replacementRiskTracker, asgName)
+		RecordAppliedCapacity(reconciler, asgName, asgDesiredCapacity)
+		mBoostsAppliedTotal.WithLabelValues(asgName).Inc()
+		mAutoscalingGroupDesiredCapacity.WithLabelValues(asgName).Set(float64(asgDesiredCapacity))
+		RecordAutoscalingGroupEvent(ctx, asgName, v1.EventTypeNormal, BoostAppliedReason, BoostAppliedEventMessage, asgName, asgDesiredCapacity)
 	}
 
 	return err
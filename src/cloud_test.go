@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAwsDescribeAutoScalingGroupsTagsInBatches(t *testing.T) {
+	fakeAws := NewFakeAws()
+
+	var autoscalingGroupNames []string
+	for i := 0; i < DescribeTagsMaxFilterValues+20; i++ {
+		asgName := fmt.Sprintf("eks-%03d", i)
+		fakeAws.AddAutoscalingGroup(asgName, fmt.Sprintf("nodegroup-%03d", i), 1, 10)
+		fakeAws.SetTag(asgName, "team", "platform")
+		autoscalingGroupNames = append(autoscalingGroupNames, asgName)
+	}
+	autoscalingGroupNames = append(autoscalingGroupNames, "eks-missing")
+
+	awsClient := NewRateLimitedAwsClient(fakeAws.Client(), AwsRetryPolicy{})
+	asgGroupedTags, err := AwsDescribeAutoScalingGroupsTags(awsClient, autoscalingGroupNames)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(asgGroupedTags) != len(autoscalingGroupNames) {
+		t.Fatalf("expected tags for %d asgs, got %d", len(autoscalingGroupNames), len(asgGroupedTags))
+	}
+	for i, asgName := range autoscalingGroupNames[:len(autoscalingGroupNames)-1] {
+		expectedTags := map[string]string{AWSAutoscalingGroupsNodeGroupTag: fmt.Sprintf("nodegroup-%03d", i), "team": "platform"}
+		if !reflect.DeepEqual(asgGroupedTags[asgName], expectedTags) {
+			t.Errorf("unexpected tags for asg '%s': %v", asgName, asgGroupedTags[asgName])
+		}
+	}
+
+	// ASGs without tags are described too, so they are cached
+	if tags, found := asgGroupedTags["eks-missing"]; !found || len(tags) != 0 {
+		t.Errorf("expected empty tags for asg 'eks-missing', got %v", tags)
+	}
+}
+
+func TestAutoscalingGroupTagsCache(t *testing.T) {
+	now := time.Now()
+	tagsCache := NewAutoscalingGroupTagsCache(time.Minute)
+
+	changed := StoreAutoscalingGroupTags(tagsCache, map[string]map[string]string{
+		"eks-spot":      {PausedTag: PausedTagValue},
+		"eks-on-demand": {},
+	}, now.Add(-2*time.Minute))
+	if !reflect.DeepEqual(changed, []string{"eks-on-demand", "eks-spot"}) {
+		t.Errorf("expected all the asgs to change the first time, got %v", changed)
+	}
+
+	StoreAutoscalingGroupTags(tagsCache, map[string]map[string]string{"eks-on-demand": {}}, now)
+
+	expired := GetExpiredAutoscalingGroupNames(tagsCache, []string{"eks-spot", "eks-on-demand", "eks-new"}, now)
+	if !reflect.DeepEqual(expired, []string{"eks-spot", "eks-new"}) {
+		t.Errorf("expected expired and new asgs to be described, got %v", expired)
+	}
+
+	changed = StoreAutoscalingGroupTags(tagsCache, map[string]map[string]string{"eks-spot": {PausedTag: PausedTagValue}}, now)
+	if len(changed) != 0 {
+		t.Errorf("expected no changes for the same tags, got %v", changed)
+	}
+
+	// ASGs not present anymore are forgotten
+	asgGroupedTags := GetCachedAutoscalingGroupTags(tagsCache, []string{"eks-spot"})
+	if len(asgGroupedTags) != 1 || len(tagsCache.Tags) != 1 || len(tagsCache.DescribedAt) != 1 {
+		t.Errorf("expected only asg 'eks-spot' to be kept, got %v", tagsCache.Tags)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"time"
+)
+
+const (
+	// SoftCordonTaintKey is the taint applied to nodes under risk to move new pods away from them gradually
+	SoftCordonTaintKey   = "asbooster.docplanner.com/rebalance-recommendation"
+	SoftCordonTaintValue = "true"
+
+	// SoftCordonedAtAnnotation stores the moment the soft-cordon taint was applied to a node
+	SoftCordonedAtAnnotation = "asbooster.docplanner.com/soft-cordoned-at"
+
+	// Info messages
+	SoftCordonAppliedMessage   = "soft-cordon applied to the node '%s' with effect '%s'"
+	SoftCordonEscalatedMessage = "soft-cordon escalated on the node '%s' to effect '%s'"
+	SoftCordonRemovedMessage   = "soft-cordon removed from the node '%s': there are no events for it"
+
+	// Error messages
+	SoftCordonErrorMessage = "impossible to update soft-cordon taint on the node '%s': %v"
+)
+
+// SoftCordonNodesUnderRisk taint the nodes under risk as soon as their events arrive.
+// First PreferNoSchedule is applied, and escalated to NoSchedule after some time,
+// so schedulers move new pods away gradually while replacement capacity boots
+// This function must be executed as a go routine
+func SoftCordonNodesUnderRisk(ctx *Ctx, client kubernetes.Interface, eventPool *EventPool, nodePool *NodePool) {
+
+	for {
+		if *ctx.Flags.DryRun {
+			time.Sleep(WatchersLoopTime)
+			continue
+		}
+
+		// Only the nodes under risk and the ones already soft-cordoned can need changes
+		nodesUnderRisk := map[string]bool{}
+		for _, event := range eventPool.Snapshot() {
+			nodesUnderRisk[event.InvolvedObject.Name] = true
+		}
+
+		for nodeName := range nodesUnderRisk {
+			if node, found := nodePool.Get(nodeName); found {
+				ReconcileSoftCordon(ctx, client, node, true)
+			}
+		}
+
+		for _, node := range nodePool.SoftCordonedSnapshot() {
+			if !nodesUnderRisk[node.Name] {
+				ReconcileSoftCordon(ctx, client, node, false)
+			}
+		}
+
+		time.Sleep(WatchersLoopTime)
+	}
+}
+
+// ReconcileSoftCordon apply, escalate or remove the soft-cordon taint on a node according to its risk.
+// The node can be shared with the pool, so it is only copied when it needs to be modified
+func ReconcileSoftCordon(ctx *Ctx, client kubernetes.Interface, node *v1.Node, underRisk bool) {
+
+	var currentTaint *v1.Taint
+	for taintIndex, taint := range node.Spec.Taints {
+		if taint.Key == SoftCordonTaintKey {
+			currentTaint = &node.Spec.Taints[taintIndex]
+		}
+	}
+
+	var err error
+	switch {
+
+	// Node is not under risk anymore, clean it
+	case !underRisk && currentTaint != nil:
+		node = node.DeepCopy()
+		err = KubernetesUntaintNode(client, node, SoftCordonTaintKey, []string{SoftCordonedAtAnnotation})
+		if err == nil {
+			ctx.Logger.Infof(SoftCordonRemovedMessage, node.Name)
+		}
+
+	// Node recently under risk, prefer not scheduling pods on it
+	case underRisk && currentTaint == nil:
+		node = node.DeepCopy()
+		err = KubernetesTaintNode(client, node, v1.Taint{
+			Key:    SoftCordonTaintKey,
+			Value:  SoftCordonTaintValue,
+			Effect: v1.TaintEffectPreferNoSchedule,
+		}, map[string]string{
+			SoftCordonedAtAnnotation: time.Now().Format(time.RFC3339),
+		})
+		if err == nil {
+			ctx.Logger.Infof(SoftCordonAppliedMessage, node.Name, v1.TaintEffectPreferNoSchedule)
+		}
+
+	// Node under risk for a while, forbid scheduling pods on it
+	case underRisk && currentTaint.Effect == v1.TaintEffectPreferNoSchedule:
+		softCordonedAt, parseErr := time.Parse(time.RFC3339, node.Annotations[SoftCordonedAtAnnotation])
+		if parseErr == nil && time.Since(softCordonedAt) < *ctx.Flags.SoftCordonEscalationDelay {
+			return
+		}
+
+		node = node.DeepCopy()
+		err = KubernetesTaintNode(client, node, v1.Taint{
+			Key:    SoftCordonTaintKey,
+			Value:  SoftCordonTaintValue,
+			Effect: v1.TaintEffectNoSchedule,
+		}, map[string]string{})
+		if err == nil {
+			ctx.Logger.Infof(SoftCordonEscalatedMessage, node.Name, v1.TaintEffectNoSchedule)
+		}
+	}
+
+	if err != nil {
+		ctx.Logger.Infof(SoftCordonErrorMessage, node.Name, err)
+	}
+}
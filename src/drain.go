@@ -1,7 +1,7 @@
 package main
 
 import (
-	"github.com/aws/aws-sdk-go/aws/session"
+	"go.opentelemetry.io/otel/trace"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/client-go/kubernetes"
@@ -15,20 +15,32 @@ import (
 const (
 
 	// Info messages
-	WorkerLaunchedMessage  = "worker launched in background: draining the node: %s"
-	DrainNotAllowedMessage = "drain is not allowed now, will be reviewed in the next loop"
+	WorkerLaunchedMessage = "worker launched in background: draining the node: %s"
 
 	// Error messages
 	DrainingErrorMessage              = "error draining the node '%s': %v"
 	EventNotDeletedErrorMessage       = "impossible to delete event from K8s: %v"
 	InstanceNotFoundErrorMessage      = "instance '%s' not found. was it deleted by aws?: %v"
 	UpdateNodeAnnotationsErrorMessage = "impossible to annotate a recently ready node '%s': %v"
+
+	// DrainTimeoutErrorText is the text included by kubectl in the errors of the drains that reached the timeout
+	DrainTimeoutErrorText = "global timeout reached"
 )
 
-// DrainNodesUnderRisk TODO
-func DrainNodesUnderRisk(ctx *Ctx, client *kubernetes.Clientset, awsClient *session.Session, eventPool *EventPool, nodePool *NodePool) {
+// GetDrainResult return the result of a drain according to its error: succeeded, failed or timed-out
+func GetDrainResult(err error) string {
+	if err == nil {
+		return DrainResultSucceeded
+	}
+	if strings.Contains(err.Error(), DrainTimeoutErrorText) {
+		return DrainResultTimedOut
+	}
+	return DrainResultFailed
+}
+
+// NewDrainHelper return the kubectl helper used to drain the nodes, configured by the flags
+func NewDrainHelper(ctx *Ctx, client kubernetes.Interface) *drain.Helper {
 
-	// Prepare kubectl to drain nodes
 	drainHelper := &drain.Helper{
 		Client: client,
 		Force:  true,
@@ -39,6 +51,9 @@ func DrainNodesUnderRisk(ctx *Ctx, client *kubernetes.Clientset, awsClient *sess
 		DeleteEmptyDirData:  true,
 		Timeout:             *ctx.Flags.DrainTimeout,
 
+		// Pods can be excluded from the drain by annotations
+		AdditionalFilters: []drain.PodFilter{SkipEvictionPodFilter},
+
 		Out:    os.Stdout,
 		ErrOut: os.Stdout,
 	}
@@ -48,110 +63,240 @@ func DrainNodesUnderRisk(ctx *Ctx, client *kubernetes.Clientset, awsClient *sess
 		drainHelper.GracePeriodSeconds = 0
 	}
 
+	return drainHelper
+}
+
+// DrainNodesUnderRisk TODO
+func DrainNodesUnderRisk(ctx *Ctx, client kubernetes.Interface, eventPool *EventPool, nodePool *NodePool,
+	autoscalingGroupPool *AutoscalingGroupPool, terminationQueue *TerminationQueue) {
+
+	// Prepare kubectl to drain nodes
+	drainHelper := NewDrainHelper(ctx, client)
+
 	for {
-		// Lock process on dry-run
+		// Only plan the drains on dry-run
 		if *ctx.Flags.DryRun == true {
-			ctx.Logger.Info(DrainNotAllowedMessage)
+			PlanDrains(ctx, eventPool, nodePool, autoscalingGroupPool, terminationQueue)
+			time.Sleep(*ctx.Flags.TimeBetweenDrains)
+			continue
+		}
+
+		// Lock process when paused by the control configmap
+		if GetPauseControls(ctx, client).Draining {
+			ctx.Logger.Info(DrainingPausedMessage)
 			time.Sleep(*ctx.Flags.TimeBetweenDrains)
 			continue
 		}
 
 		var waitGroup sync.WaitGroup
+		loopStart := time.Now()
 
 		// 1. Check whether the eventPool is already filled by the watcher
-		if len(eventPool.Events.Items) == 0 {
+		if eventPool.Len() == 0 {
 			time.Sleep(*ctx.Flags.TimeBetweenDrains)
 			continue
 		}
 
-		// Get recently added nodes, ignoring those with 'IgnoreRecentReadyNodeAnnotation' annotation
-		recentlyAddedNodes := GetRecentlyReadyNodesByNodeGroup(nodePool, *ctx.Flags.MaxTimeConsiderNewNodes, true)
-		groupedEvents := GetEventsByNodeGroup(eventPool, nodePool)
+		// 2. Launch the drains of the selected nodes in parallel
+		aggregate := NewNodegroupsAggregate(eventPool, nodePool, *ctx.Flags.MaxTimeConsiderNewNodes, true)
+		for _, drainCandidates := range SelectDrainCandidates(ctx, aggregate, nodePool, terminationQueue) {
+			for _, drainCandidate := range drainCandidates {
 
-		// 2. Loop over each nodegroup launching some drainage in parallel
-		for nodegroupName, nodegroupNodes := range recentlyAddedNodes {
+				// Execute a drain for a node under risk
+				waitGroup.Add(1)
+				go DispatchDrainage(ctx, client, drainHelper, nodePool, autoscalingGroupPool, terminationQueue, drainCandidate, &waitGroup)
+			}
+		}
 
-			nodegroupNodes = GetSortedNodeList(nodegroupNodes, true)
-			nodegroupReadyCount := len(nodegroupNodes)
+		waitGroup.Wait()
+		observeLoopDuration(DrainLoopName, loopStart)
+		time.Sleep(*ctx.Flags.TimeBetweenDrains)
+	}
+}
 
-			// No events for this nodegroup, jump
-			if len(groupedEvents[nodegroupName]) == 0 {
-				continue
-			}
+// SelectDrainCandidates return, per nodegroup, the batch of nodes under risk that can be drained now.
+// Each of them is paired with a recently ready node of its nodegroup, so no more nodes are drained than new ones are ready
+// The recently ready nodes of the aggregate must ignore those annotated with IgnoreRecentReadyNodeAnnotation
+func SelectDrainCandidates(ctx *Ctx, aggregate *NodegroupsAggregate, nodePool *NodePool, terminationQueue *TerminationQueue) (drainCandidates map[string][]DrainCandidate) {
 
-			// Get a batch of events from this nodegroup pool
-			var currentMaxNumberDrainingEvents int
-			var currentDrainingEvents []*v1.Event
+	drainCandidates = map[string][]DrainCandidate{}
+	groupedEvents := map[string][]*v1.Event{}
 
-			// Set a maximum number of drains for this nodegroup
-			currentMaxNumberDrainingEvents = *ctx.Flags.MaxConcurrentDrains
-			if nodegroupReadyCount < *ctx.Flags.MaxConcurrentDrains {
-				currentMaxNumberDrainingEvents = nodegroupReadyCount
-			}
+	// Loop over each nodegroup selecting a batch of events
+	for nodegroupName, nodegroupNodes := range aggregate.RecentlyReadyNodes {
 
-			// Fewer events than allowed concurrent drains, get them all
-			if len(groupedEvents[nodegroupName]) < currentMaxNumberDrainingEvents {
-				currentDrainingEvents = groupedEvents[nodegroupName][0:len(groupedEvents[nodegroupName])]
-			}
+		// Sort a copy, as the aggregate is shared
+		nodegroupNodes = GetSortedNodeList(append([]*v1.Node{}, nodegroupNodes...), true)
+		nodegroupReadyCount := len(nodegroupNodes)
 
-			// More events than allowed concurrent drains, get only the maximum allowed
-			if len(groupedEvents[nodegroupName]) >= currentMaxNumberDrainingEvents {
-				currentDrainingEvents = groupedEvents[nodegroupName][0:currentMaxNumberDrainingEvents]
+		// Ignore the events of nodes already drained, waiting for their instances to be terminated or given up,
+		// and those of nodes excluded from drain by annotation
+		var pendingEvents []*v1.Event
+		for _, event := range aggregate.Events[nodegroupName] {
+			if IsNodeEnqueuedForTermination(terminationQueue, event.InvolvedObject.Name) {
+				continue
 			}
+			if IsNodeExcludedFromDrain(nodePool, event.InvolvedObject.Name) {
+				ctx.Logger.Infof(NodeExcludedFromDrainMessage, event.InvolvedObject.Name)
+				continue
+			}
+			if IsNodeTerminationGivenUp(nodePool, event.InvolvedObject.Name) {
+				ctx.Logger.Infof(NodeTerminationGivenUpMessage, event.InvolvedObject.Name)
+				continue
+			}
+			pendingEvents = append(pendingEvents, event)
+		}
+		groupedEvents[nodegroupName] = pendingEvents
 
-			for currentEventIndex, _ := range currentDrainingEvents {
+		// No events for this nodegroup, jump
+		if len(groupedEvents[nodegroupName]) == 0 {
+			continue
+		}
 
-				// Annotate a bare new Ready-node to avoid future drain calculations based on it
-				err := KubernetesAnnotateNode(client, nodegroupNodes[currentEventIndex], map[string]string{
-					IgnoreRecentReadyNodeAnnotation: IgnoreRecentReadyNodeAnnotationValue,
-				})
-				if err != nil {
-					ctx.Logger.Infof(UpdateNodeAnnotationsErrorMessage, nodegroupNodes[currentEventIndex].Name, err)
-				}
+		// Get a batch of events from this nodegroup pool
+		var currentMaxNumberDrainingEvents int
+		var currentDrainingEvents []*v1.Event
 
-				// Execute a drain for a node under risk
-				waitGroup.Add(1)
-				go DispatchDrainage(ctx, client, awsClient, drainHelper, nodePool, currentDrainingEvents[currentEventIndex], &waitGroup)
-			}
+		// Set a maximum number of drains for this nodegroup
+		currentMaxNumberDrainingEvents = *ctx.Flags.MaxConcurrentDrains
+		if nodegroupReadyCount < *ctx.Flags.MaxConcurrentDrains {
+			currentMaxNumberDrainingEvents = nodegroupReadyCount
 		}
 
-		waitGroup.Wait()
-		time.Sleep(*ctx.Flags.TimeBetweenDrains)
+		// Fewer events than allowed concurrent drains, get them all
+		if len(groupedEvents[nodegroupName]) < currentMaxNumberDrainingEvents {
+			currentDrainingEvents = groupedEvents[nodegroupName][0:len(groupedEvents[nodegroupName])]
+		}
+
+		// More events than allowed concurrent drains, get only the maximum allowed
+		if len(groupedEvents[nodegroupName]) >= currentMaxNumberDrainingEvents {
+			currentDrainingEvents = groupedEvents[nodegroupName][0:currentMaxNumberDrainingEvents]
+		}
+
+		for currentEventIndex, _ := range currentDrainingEvents {
+			drainCandidates[nodegroupName] = append(drainCandidates[nodegroupName], DrainCandidate{
+				Event:           currentDrainingEvents[currentEventIndex],
+				ReplacementNode: nodegroupNodes[currentEventIndex],
+			})
+		}
 	}
+
+	return drainCandidates
 }
 
-// DispatchDrainage drain a node according to data provided by an event
+// DispatchDrainage drain a node according to data provided by an event, and enqueue its instance for termination.
+// The replacement node of the candidate, if any, is only annotated as used once the drain is allowed by the webhooks
 // This function is expected to be executed as a goroutine
-func DispatchDrainage(ctx *Ctx, client *kubernetes.Clientset, awsClient *session.Session, drainHelper *drain.Helper, nodePool *NodePool, event *v1.Event, waitGroup *sync.WaitGroup) {
+func DispatchDrainage(ctx *Ctx, client kubernetes.Interface, drainHelper *drain.Helper, nodePool *NodePool,
+	autoscalingGroupPool *AutoscalingGroupPool, terminationQueue *TerminationQueue, drainCandidate DrainCandidate, waitGroup *sync.WaitGroup) {
+	defer waitGroup.Done()
+
+	event := drainCandidate.Event
+
+	// Record the instance before draining, as the node can disappear from the pool later
+	terminationRequest := NewTerminationRequest(nodePool, autoscalingGroupPool, event)
+
+	// Ask external services whether the drain can be done now.
+	// Vetoed drains keep their replacement node available for the following loops
+	if !RunPreDrainWebhook(ctx, terminationRequest.WebhookPayload) {
+		return
+	}
+
+	// Annotate a bare new Ready-node to avoid future drain calculations based on it
+	if drainCandidate.ReplacementNode != nil {
+		err := KubernetesAnnotateNode(client, drainCandidate.ReplacementNode.DeepCopy(), map[string]string{
+			IgnoreRecentReadyNodeAnnotation: IgnoreRecentReadyNodeAnnotationValue,
+		})
+		if err != nil {
+			ctx.Logger.Infof(UpdateNodeAnnotationsErrorMessage, drainCandidate.ReplacementNode.Name, err)
+		}
+	}
+
+	// Store the workloads to be evicted, to wait for their replacement pods later
+	var evictedWorkloads []WorkloadReference
+	if *ctx.Flags.WaitReplacementPods {
+		podDeleteList, errs := drainHelper.GetPodsForDeletion(event.InvolvedObject.Name)
+		if len(errs) == 0 {
+			evictedWorkloads = GetWorkloadsFromPods(ctx, client, podDeleteList.Pods())
+		}
+	}
+
+	auditRecord := AuditRecord{
+		Action:           AuditActionDrainStarted,
+		AutoscalingGroup: terminationRequest.AutoscalingGroupName,
+		Node:             event.InvolvedObject.Name,
+		Instance:         terminationRequest.InstanceId,
+		Event:            event.Namespace + "/" + event.Name,
+		Outcome:          AuditOutcomeSuccess,
+	}
+	WriteAuditRecord(ctx, auditRecord)
+
 	ctx.Logger.Infof(WorkerLaunchedMessage, event.InvolvedObject.Name) // TODO INFO
-	err := drain.RunNodeDrain(drainHelper, event.InvolvedObject.Name)
+	RecordNodeEvent(ctx, event.InvolvedObject.Name, v1.EventTypeNormal, DrainStartedReason, DrainStartedEventMessage, terminationRequest.InstanceId)
+
+	nodegroupName := terminationRequest.WebhookPayload.Nodegroup
+	mDrainsTotal.WithLabelValues(nodegroupName, DrainResultStarted).Inc()
+
+	// Trace the drain on the node's trace, including the calls done to Kubernetes
+	drainCtx, drainSpan := StartSpan(GetNodeTraceContext(ctx.Traces, event.InvolvedObject.Name), DrainSpanName,
+		trace.WithAttributes(
+			NodeAttribute.String(event.InvolvedObject.Name),
+			NodegroupAttribute.String(nodegroupName),
+		))
+	nodeDrainHelper := *drainHelper
+	nodeDrainHelper.Ctx = drainCtx
+
+	drainStart := time.Now()
+	err := RunNodeDrainWithPolicies(ctx, &nodeDrainHelper, event.InvolvedObject.Name)
+	EndSpan(drainSpan, err)
+
+	drainResult := GetDrainResult(err)
+	mDrainsTotal.WithLabelValues(nodegroupName, drainResult).Inc()
+	mDrainDurationSeconds.WithLabelValues(nodegroupName, drainResult).Observe(time.Since(drainStart).Seconds())
 
 	if err != nil {
 		ctx.Logger.Infof(DrainingErrorMessage, event.InvolvedObject.Name, err)
+		RecordNodeEvent(ctx, event.InvolvedObject.Name, v1.EventTypeWarning, DrainFailedReason, DrainFailedEventMessage, err)
+	} else {
+		RecordNodeEvent(ctx, event.InvolvedObject.Name, v1.EventTypeNormal, DrainSucceededReason, DrainSucceededEventMessage)
 	}
 
-	// Terminate the problematic instance
-	// Example of field: providerID: aws:///eu-central-1a/i-042377dc1ee1257a1
-	var providerIDSubstrings []string
-	var instanceName string
-	for _, node := range nodePool.Nodes.Items {
-		if node.Name == event.InvolvedObject.Name {
-			providerIDSubstrings = strings.Split(node.Spec.ProviderID, "/")
-			ctx.Logger.Info(providerIDSubstrings[len(providerIDSubstrings)-1])
-			instanceName = providerIDSubstrings[len(providerIDSubstrings)-1]
+	auditRecord.Action = AuditActionDrainFinished
+	auditRecord.Outcome = GetAuditOutcome(err)
+	auditRecord.Error = GetAuditErrorString(err)
+	WriteAuditRecord(ctx, auditRecord)
+
+	// Terminate the problematic instance from the termination queue
+	// Node not found, so there is nothing to terminate. Forget about the event
+	if terminationRequest.InstanceId == "" {
+		ctx.Logger.Infof(InstanceIdNotFoundErrorMessage, event.InvolvedObject.Name)
+		auditRecord.Action = AuditActionTerminationSkipped
+		auditRecord.Outcome = AuditOutcomeSuccess
+		auditRecord.Reason = "instance-not-found"
+		auditRecord.Error = ""
+		WriteAuditRecord(ctx, auditRecord)
+
+		err = KubernetesDeleteEvent(client, event.Namespace, event.Name)
+		if err != nil && !errors.IsNotFound(err) {
+			ctx.Logger.Infof(EventNotDeletedErrorMessage, err)
 		}
-	}
 
-	err = AwsTerminateInstance(awsClient, instanceName)
-	if err != nil && !errors.IsNotFound(err) {
-		ctx.Logger.Infof(InstanceNotFoundErrorMessage, instanceName, err)
+		auditRecord.Action = AuditActionEventDeleted
+		auditRecord.Outcome = GetAuditOutcome(err)
+		auditRecord.Error = GetAuditErrorString(err)
+		WriteAuditRecord(ctx, auditRecord)
+	} else if EnqueueTermination(terminationQueue, terminationRequest) {
+		ctx.Logger.Infof(TerminationEnqueuedMessage, terminationRequest.InstanceId, event.InvolvedObject.Name)
 	}
 
-	// Delete the event from Kubernetes
-	err = KubernetesDeleteEvent(client, event.Namespace, event.Name)
-	if err != nil && !errors.IsNotFound(err) {
-		ctx.Logger.Infof(EventNotDeletedErrorMessage, err)
+	// Hold the drain slot until the evicted workloads are available again
+	if len(evictedWorkloads) > 0 {
+		ctx.Logger.Infof(WaitingWorkloadsMessage, len(evictedWorkloads), event.InvolvedObject.Name)
+		err = WaitForWorkloadsAvailable(client, evictedWorkloads, *ctx.Flags.ReplacementPodsTimeout)
+		if err != nil {
+			ctx.Logger.Infof(WorkloadsTimeoutErrorMessage, event.InvolvedObject.Name, err)
+			return
+		}
+		ctx.Logger.Infof(WorkloadsAvailableMessage, event.InvolvedObject.Name)
 	}
-
-	waitGroup.Done()
 }
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// addOldNodes join some nodes to the cluster, ready for long enough not to be considered new
+func addOldNodes(h *Harness, asgName string, count int) {
+	for i := 1; i <= count; i++ {
+		h.AddNode(fmt.Sprintf("node-%d", i), asgName, time.Hour)
+	}
+}
+
+func TestBoostOnRebalanceRecommendation(t *testing.T) {
+	t.Parallel()
+
+	h := NewHarness(t)
+	h.Aws.AddAutoscalingGroup("eks-spot", "spot", 1, 10)
+	h.Start()
+
+	addOldNodes(h, "eks-spot", 3)
+	h.UpdateClusterAutoscalerStatus()
+
+	h.AddRebalanceRecommendation("node-1")
+
+	// (Ready Nodes) - (Events) + 2 * (Events) = 3 - 1 + 2
+	h.Eventually("the asg is boosted to 4", func() bool {
+		return h.Aws.GetDesiredCapacity("eks-spot") == 4
+	})
+	h.Eventually("the boost is recorded", func() bool {
+		return h.HasAuditRecord(AuditRecord{Action: AuditActionBoostSet, AutoscalingGroup: "eks-spot", Outcome: AuditOutcomeSuccess})
+	})
+}
+
+func TestBoostAuditRecordsInputsBeforeAndAfterBudgets(t *testing.T) {
+	t.Parallel()
+
+	h := NewHarness(t, "--max-boosted-nodes-per-asg", "1")
+	h.Aws.AddAutoscalingGroup("eks-spot", "spot", 1, 10)
+	h.Start()
+
+	addOldNodes(h, "eks-spot", 4)
+	h.UpdateClusterAutoscalerStatus()
+
+	h.AddRebalanceRecommendation("node-1")
+	h.AddRebalanceRecommendation("node-2")
+
+	// (Ready Nodes) - (Events) + 2 * (Events) = 4 - 2 + 4, trimmed to 1 node over the ready ones
+	expected := map[string]int{"events": 2, "ready": 4, "calculated": 6, "budgeted": 5, "target": 5}
+	h.Eventually("the boost is recorded with the inputs of both events", func() bool {
+		for _, auditRecord := range h.AuditRecords() {
+			if auditRecord.Action != AuditActionBoostSet || auditRecord.Inputs["events"] != 2 {
+				continue
+			}
+			for input, value := range expected {
+				if auditRecord.Inputs[input] != value {
+					t.Fatalf("expected input '%s' to be %d, got inputs %v", input, value, auditRecord.Inputs)
+				}
+			}
+			return true
+		}
+		return false
+	})
+}
+
+func TestThrottledBoostIsRetried(t *testing.T) {
+	t.Parallel()
+
+	h := NewHarness(t)
+	h.Aws.AddAutoscalingGroup("eks-spot", "spot", 1, 10)
+	h.Aws.FailNextCalls("SetDesiredCapacity", "Throttling", 2)
+	h.Aws.FailNextCalls("SetDesiredCapacity", autoscaling.ErrCodeScalingActivityInProgressFault, 1)
+	h.Start()
+
+	addOldNodes(h, "eks-spot", 3)
+	h.UpdateClusterAutoscalerStatus()
+
+	h.AddRebalanceRecommendation("node-1")
+
+	h.Eventually("the asg is boosted to 4", func() bool {
+		return h.Aws.GetDesiredCapacity("eks-spot") == 4
+	})
+
+	for _, auditRecord := range h.AuditRecords() {
+		if auditRecord.Action == AuditActionBoostSet && auditRecord.Outcome != AuditOutcomeSuccess {
+			t.Errorf("throttled boost failed instead of being retried: %+v", auditRecord)
+		}
+	}
+}
+
+func TestUnchangedBoostIsNotAppliedAgain(t *testing.T) {
+	t.Parallel()
+
+	h := NewHarness(t)
+	h.Aws.AddAutoscalingGroup("eks-spot", "spot", 1, 10)
+	h.Start()
+
+	addOldNodes(h, "eks-spot", 3)
+	h.UpdateClusterAutoscalerStatus()
+
+	h.AddRebalanceRecommendation("node-1")
+
+	h.Eventually("the asg is boosted to 4", func() bool {
+		return h.Aws.GetDesiredCapacity("eks-spot") == 4
+	})
+
+	// Changes that do not move the target trigger synchronizations, but AWS is not called again
+	h.AddNode("node-4", "eks-spot", time.Hour)
+	h.AddNode("node-5", "eks-spot", time.Hour)
+	time.Sleep(time.Second)
+
+	for _, auditRecord := range h.AuditRecords() {
+		if auditRecord.AutoscalingGroup != "eks-spot" {
+			continue
+		}
+		if auditRecord.Action == AuditActionBoostSkipped {
+			t.Errorf("unchanged boost was reviewed against aws again: %+v", auditRecord)
+		}
+	}
+}
+
+func TestBoostClampedToMaxSize(t *testing.T) {
+	t.Parallel()
+
+	h := NewHarness(t)
+	h.Aws.AddAutoscalingGroup("eks-spot", "spot", 1, 4)
+	h.Start()
+
+	addOldNodes(h, "eks-spot", 3)
+	h.UpdateClusterAutoscalerStatus()
+
+	h.AddRebalanceRecommendation("node-1")
+	h.AddRebalanceRecommendation("node-2")
+
+	h.Eventually("the boost is clamped", func() bool {
+		return h.HasAuditRecord(AuditRecord{Action: AuditActionBoostClamped, AutoscalingGroup: "eks-spot", Reason: "max-size"})
+	})
+	h.Eventually("the asg is boosted to its max size", func() bool {
+		return h.Aws.GetDesiredCapacity("eks-spot") == 4
+	})
+}
+
+func TestBoostSkippedForPausedAutoscalingGroup(t *testing.T) {
+	t.Parallel()
+
+	h := NewHarness(t)
+	h.Aws.AddAutoscalingGroup("eks-spot", "spot", 1, 10)
+	h.Aws.SetTag("eks-spot", PausedTag, PausedTagValue)
+	h.Start()
+
+	addOldNodes(h, "eks-spot", 3)
+	h.UpdateClusterAutoscalerStatus()
+
+	h.AddRebalanceRecommendation("node-1")
+
+	h.Eventually("the boost is skipped", func() bool {
+		return h.HasAuditRecord(AuditRecord{Action: AuditActionBoostSkipped, AutoscalingGroup: "eks-spot", Reason: "paused"})
+	})
+	if desiredCapacity := h.Aws.GetDesiredCapacity("eks-spot"); desiredCapacity != 3 {
+		t.Errorf("expected desired capacity 3 for the paused asg, got %d", desiredCapacity)
+	}
+}
+
+func TestVetoedDrainKeepsReplacementNode(t *testing.T) {
+	t.Parallel()
+
+	server, calls := newWebhookServer(t, func(call int32, w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+	})
+
+	h := NewHarness(t, "--pre-drain-webhook-url", server.URL)
+	h.Aws.AddAutoscalingGroup("eks-spot", "spot", 1, 10)
+	h.Start()
+
+	addOldNodes(h, "eks-spot", 3)
+	h.AddPod("pod-1", "node-1")
+	h.UpdateClusterAutoscalerStatus()
+
+	h.AddRebalanceRecommendation("node-1")
+	h.Eventually("the asg is boosted to 4", func() bool {
+		return h.Aws.GetDesiredCapacity("eks-spot") == 4
+	})
+	h.PauseBoosting()
+
+	h.AddNode("node-4", "eks-spot", time.Minute)
+	h.UpdateClusterAutoscalerStatus()
+
+	h.Eventually("the drain of node-1 is vetoed on several loops", func() bool {
+		return calls.Load() >= 3
+	})
+
+	if !h.PodExists("pod-1") {
+		t.Errorf("node-1 was drained, but the webhook vetoed it")
+	}
+	if _, found := h.GetNode("node-4").Annotations[IgnoreRecentReadyNodeAnnotation]; found {
+		t.Errorf("replacement node-4 was marked as used by a vetoed drain")
+	}
+}
+
+func TestDryRunPlansDrainBatches(t *testing.T) {
+	t.Parallel()
+
+	h := NewHarness(t, "--dry-run", "--max-concurrent-drains", "1")
+	h.Aws.AddAutoscalingGroup("eks-spot", "spot", 1, 10)
+	h.Start()
+
+	server := httptest.NewServer(PlanHandler(h.Ctx))
+	defer server.Close()
+
+	// Three nodes under risk, but only two replacements for them, newest first
+	addOldNodes(h, "eks-spot", 3)
+	h.AddNode("node-new-1", "eks-spot", 2*time.Minute)
+	h.AddNode("node-new-2", "eks-spot", time.Minute)
+	h.UpdateClusterAutoscalerStatus()
+
+	for _, nodeName := range []string{"node-1", "node-2", "node-3"} {
+		h.AddRebalanceRecommendation(nodeName)
+	}
+
+	getPlan := func() *Plan {
+		plan := &Plan{}
+		response, err := http.Get(server.URL + "/plan")
+		if err != nil {
+			return plan
+		}
+		defer response.Body.Close()
+
+		_ = json.NewDecoder(response.Body).Decode(plan)
+		return plan
+	}
+
+	// (Ready Nodes) - (Events) + 2 * (Events) = 5 - 3 + 6, then wait for the drains planned after it
+	var eventsSeenAt time.Time
+	h.Eventually("the plan boosts for the three events", func() bool {
+		eventsSeenAt = time.Now()
+		return getPlan().Boosts["eks-spot"].Intended == 8
+	})
+
+	var plan *Plan
+	h.Eventually("the drains are planned again", func() bool {
+		plan = getPlan()
+		return plan.DrainsUpdatedAt.After(eventsSeenAt)
+	})
+
+	// Each batch assumes the previous ones are done: their events are gone and their replacements are used
+	if len(plan.Drains) != 2 {
+		t.Fatalf("expected 2 planned drains, one per replacement, got %+v", plan.Drains)
+	}
+	drainedNodes := map[string]bool{}
+	for i, plannedDrain := range plan.Drains {
+		expectedReplacement := []string{"node-new-2", "node-new-1"}[i]
+		if plannedDrain.Batch != i+1 || plannedDrain.ReplacementNode != expectedReplacement {
+			t.Errorf("expected batch %d replaced by '%s', got %+v", i+1, expectedReplacement, plannedDrain)
+		}
+		if plannedDrain.AutoscalingGroup != "eks-spot" || plannedDrain.Instance != h.GetInstanceId(plannedDrain.Node) {
+			t.Errorf("expected the instance of '%s' on asg 'eks-spot', got %+v", plannedDrain.Node, plannedDrain)
+		}
+		if drainedNodes[plannedDrain.Node] {
+			t.Errorf("node '%s' was planned to be drained twice", plannedDrain.Node)
+		}
+		drainedNodes[plannedDrain.Node] = true
+	}
+
+	// Nothing is done on dry-run
+	if desiredCapacity := h.Aws.GetDesiredCapacity("eks-spot"); desiredCapacity != 5 {
+		t.Errorf("expected desired capacity 5 on dry-run, got %d", desiredCapacity)
+	}
+	for nodeName := range drainedNodes {
+		if h.GetNode(nodeName).Spec.Unschedulable {
+			t.Errorf("node '%s' was cordoned on dry-run", nodeName)
+		}
+	}
+}
+
+func TestDrainAndTerminateNodeUnderRisk(t *testing.T) {
+	t.Parallel()
+
+	h := NewHarness(t)
+	h.Aws.AddAutoscalingGroup("eks-spot", "spot", 1, 10)
+	h.Start()
+
+	addOldNodes(h, "eks-spot", 3)
+	h.AddPod("pod-1", "node-1")
+	h.AddPod("pod-2", "node-2")
+	h.UpdateClusterAutoscalerStatus()
+
+	h.AddRebalanceRecommendation("node-1")
+
+	h.Eventually("the asg is boosted to 4", func() bool {
+		return h.Aws.GetDesiredCapacity("eks-spot") == 4
+	})
+
+	// Nothing is drained until a new node is ready to replace the one under risk
+	if !h.PodExists("pod-1") {
+		t.Fatalf("node-1 was drained before its replacement was ready")
+	}
+
+	// Freeze the boosts, so the capacity is not boosted again while the node under risk is replaced
+	h.PauseBoosting()
+
+	// The instance launched by the boost joins the cluster
+	h.AddNode("node-4", "eks-spot", time.Minute)
+	h.UpdateClusterAutoscalerStatus()
+
+	instanceId := h.GetInstanceId("node-1")
+
+	h.Eventually("node-1 is drained", func() bool {
+		return !h.PodExists("pod-1")
+	})
+	h.Eventually("the instance of node-1 is terminated", func() bool {
+		return !h.Aws.IsInstanceRunning(instanceId)
+	})
+	h.Eventually("the rebalance recommendation of node-1 is deleted", func() bool {
+		return !h.HasRebalanceRecommendation("node-1")
+	})
+
+	if !h.PodExists("pod-2") {
+		t.Errorf("pods of node-2 were evicted, but node-2 was not under risk")
+	}
+
+	if h.GetNode("node-4").Annotations[IgnoreRecentReadyNodeAnnotation] != IgnoreRecentReadyNodeAnnotationValue {
+		t.Errorf("replacement node-4 was not annotated to be ignored by the following drains")
+	}
+
+	h.Aws.Lock.Lock()
+	terminatedInstances := h.Aws.TerminatedInstances
+	h.Aws.Lock.Unlock()
+	if !reflect.DeepEqual(terminatedInstances, []string{instanceId}) {
+		t.Errorf("expected only the instance '%s' to be terminated, got %v", instanceId, terminatedInstances)
+	}
+
+	// The termination decrements the capacity added by the boost
+	if desiredCapacity := h.Aws.GetDesiredCapacity("eks-spot"); desiredCapacity != 3 {
+		t.Errorf("expected desired capacity 3 after the termination, got %d", desiredCapacity)
+	}
+
+	if !h.HasAuditRecord(AuditRecord{Action: AuditActionTerminationConfirmed, Node: "node-1", Outcome: AuditOutcomeSuccess}) {
+		t.Errorf("the termination of node-1 was not confirmed on the audit log")
+	}
+}
+
+func TestTerminationsWithoutRateLimit(t *testing.T) {
+	t.Parallel()
+
+	h := NewHarness(t, "--terminations-per-minute", "0")
+	h.Aws.AddAutoscalingGroup("eks-spot", "spot", 1, 10)
+	h.Start()
+
+	addOldNodes(h, "eks-spot", 3)
+	h.UpdateClusterAutoscalerStatus()
+
+	h.AddRebalanceRecommendation("node-1")
+	h.Eventually("the asg is boosted to 4", func() bool {
+		return h.Aws.GetDesiredCapacity("eks-spot") == 4
+	})
+	h.PauseBoosting()
+
+	h.AddNode("node-4", "eks-spot", time.Minute)
+	h.UpdateClusterAutoscalerStatus()
+
+	instanceId := h.GetInstanceId("node-1")
+	h.Eventually("the instance of node-1 is terminated", func() bool {
+		return !h.Aws.IsInstanceRunning(instanceId)
+	})
+}
+
+// countDrains return the number of drains started on a node
+func countDrains(h *Harness, nodeName string) (drains int) {
+	for _, auditRecord := range h.AuditRecords() {
+		if auditRecord.Action == AuditActionDrainStarted && auditRecord.Node == nodeName {
+			drains++
+		}
+	}
+	return drains
+}
+
+// expectTerminationGivenUp check that a drained node was left as it is, annotated with the reason,
+// keeping its event and not being drained again
+func expectTerminationGivenUp(t *testing.T, h *Harness, nodeName string, reason string, postDrainCalls *atomic.Int32) {
+	h.Eventually("the termination of "+nodeName+" is given up", func() bool {
+		return h.GetNode(nodeName).Annotations[TerminationGivenUpAnnotation] == reason
+	})
+
+	// Give the drain loop time to select the node again, if it would
+	time.Sleep(time.Second)
+
+	if drains := countDrains(h, nodeName); drains != 1 {
+		t.Errorf("expected %s to be drained once, got %d drains", nodeName, drains)
+	}
+	if !h.HasRebalanceRecommendation(nodeName) {
+		t.Errorf("the rebalance recommendation of %s was deleted, but its instance was not terminated", nodeName)
+	}
+	if h.HasAuditRecord(AuditRecord{Action: AuditActionEventDeleted, Node: nodeName}) {
+		t.Errorf("the event of %s was recorded as deleted", nodeName)
+	}
+	if calls := postDrainCalls.Load(); calls != 0 {
+		t.Errorf("expected no post-drain notification, got %d", calls)
+	}
+}
+
+func TestRefusedTerminationKeepsNodeDrained(t *testing.T) {
+	t.Parallel()
+
+	webhookServer, postDrainCalls := newWebhookServer(t, func(_ int32, w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	h := NewHarness(t, "--terminations-per-minute", "0", "--post-drain-webhook-url", webhookServer.URL)
+	h.Aws.AddAutoscalingGroup("eks-spot", "spot", 1, 10)
+	h.Start()
+
+	addOldNodes(h, "eks-spot", 3)
+	h.AddPod("pod-1", "node-1")
+	h.UpdateClusterAutoscalerStatus()
+
+	// The instance is not a spot one, so it is not safe to terminate it
+	instanceId := h.GetInstanceId("node-1")
+	h.Aws.SetInstanceLifecycle(instanceId, "on-demand")
+
+	h.AddRebalanceRecommendation("node-1")
+	h.Eventually("the asg is boosted to 4", func() bool {
+		return h.Aws.GetDesiredCapacity("eks-spot") == 4
+	})
+	h.PauseBoosting()
+
+	h.AddNode("node-4", "eks-spot", time.Minute)
+	h.AddNode("node-5", "eks-spot", time.Minute)
+	h.UpdateClusterAutoscalerStatus()
+
+	h.Eventually("node-1 is drained", func() bool {
+		return !h.PodExists("pod-1")
+	})
+	expectTerminationGivenUp(t, h, "node-1", TerminationRefusedReason, postDrainCalls)
+
+	if !h.Aws.IsInstanceRunning(instanceId) {
+		t.Errorf("the instance of node-1 was terminated, but it is not a spot one")
+	}
+	if !h.HasAuditRecord(AuditRecord{Action: AuditActionTerminationSkipped, Node: "node-1", Reason: TerminationRefusedReason}) {
+		t.Errorf("the refused termination of node-1 was not audited")
+	}
+}
+
+func TestUnconfirmedTerminationIsGivenUp(t *testing.T) {
+	t.Parallel()
+
+	webhookServer, postDrainCalls := newWebhookServer(t, func(_ int32, w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	h := NewHarness(t, "--terminations-per-minute", "0", "--termination-confirm-timeout", "1s",
+		"--post-drain-webhook-url", webhookServer.URL)
+	h.Aws.AddAutoscalingGroup("eks-spot", "spot", 1, 10)
+	h.Start()
+
+	addOldNodes(h, "eks-spot", 3)
+	h.UpdateClusterAutoscalerStatus()
+
+	// AWS accepts the termination, but the instance keeps running
+	instanceId := h.GetInstanceId("node-1")
+	h.Aws.StickInstance(instanceId)
+
+	h.AddRebalanceRecommendation("node-1")
+	h.Eventually("the asg is boosted to 4", func() bool {
+		return h.Aws.GetDesiredCapacity("eks-spot") == 4
+	})
+	h.PauseBoosting()
+
+	h.AddNode("node-4", "eks-spot", time.Minute)
+	h.AddNode("node-5", "eks-spot", time.Minute)
+	h.UpdateClusterAutoscalerStatus()
+
+	expectTerminationGivenUp(t, h, "node-1", TerminationConfirmTimeoutReason, postDrainCalls)
+
+	h.Aws.Lock.Lock()
+	terminatedInstances := h.Aws.TerminatedInstances
+	h.Aws.Lock.Unlock()
+	if !reflect.DeepEqual(terminatedInstances, []string{instanceId}) {
+		t.Errorf("expected the instance '%s' to be terminated once, got %v", instanceId, terminatedInstances)
+	}
+}
+
+func TestCircuitBreakerTripsOnRepeatedBoostsToSameTarget(t *testing.T) {
+	t.Parallel()
+
+	h := NewHarness(t, "--resync-period", "300ms", "--circuit-breaker-max-boosts-per-asg", "2")
+	h.Aws.AddAutoscalingGroup("eks-spot", "spot", 1, 10)
+	h.Start()
+
+	addOldNodes(h, "eks-spot", 3)
+	h.UpdateClusterAutoscalerStatus()
+	h.AddRebalanceRecommendation("node-1")
+
+	// Cluster Autoscaler scales the ASG down each time, and the same boost is applied again
+	for boost := 1; boost <= 3; boost++ {
+		h.Eventually(fmt.Sprintf("the asg is boosted to 4 (boost %d)", boost), func() bool {
+			return h.Aws.GetDesiredCapacity("eks-spot") == 4
+		})
+
+		h.Aws.Lock.Lock()
+		h.Aws.AutoscalingGroups["eks-spot"].DesiredCapacity = 3
+		h.Aws.Lock.Unlock()
+	}
+
+	h.Eventually("the circuit breaker skips the boost", func() bool {
+		return h.HasAuditRecord(AuditRecord{Action: AuditActionBoostSkipped, AutoscalingGroup: "eks-spot", Reason: "circuit-breaker"})
+	})
+	if desiredCapacity := h.Aws.GetDesiredCapacity("eks-spot"); desiredCapacity != 3 {
+		t.Errorf("expected the asg to be left at 3 once the circuit breaker tripped, got %d", desiredCapacity)
+	}
+}
@@ -32,15 +32,13 @@ const (
 // WatchNodes watches for nodes on k8s and keep a pool up-to-date with them
 // Done this way to reduce the calls done to Kube API
 // This function must be executed as a go routine
-func WatchNodes(ctx *Ctx, client *kubernetes.Clientset, nodePool *NodePool) {
+func WatchNodes(ctx *Ctx, client kubernetes.Interface, nodePool *NodePool) {
 
 	// Ensure retry to create a watcher when failing
 	for {
 
 		// Something failed, reset the pool
-		nodePool.Lock.Lock()
-		nodePool.Nodes = v1.NodeList{}
-		nodePool.Lock.Unlock()
+		nodePool.Reset()
 
 		nodesWatcher, err := client.CoreV1().Nodes().Watch(context.TODO(), metav1.ListOptions{})
 		if err != nil {
@@ -53,48 +51,29 @@ func WatchNodes(ctx *Ctx, client *kubernetes.Clientset, nodePool *NodePool) {
 
 			ctx.Logger.Debugf(NodeChangedMessage, nodeObject.Name) // TODO INFO
 
-			nodePool.Lock.Lock()
-
 			switch event.Type {
-			case watch.Added:
-				nodePool.Nodes.Items = append(nodePool.Nodes.Items, *nodeObject)
-
-			// Substitute previous with it
-			case watch.Modified:
-				for storedNodeIndex, storedNode := range nodePool.Nodes.Items {
-					if nodeObject.Name == storedNode.Name {
-						nodePool.Nodes.Items[storedNodeIndex] = *nodeObject
-						break
-					}
-				}
-			// Remove it from the pool: approach is last item to current position, then delete last
+			case watch.Added, watch.Modified:
+				nodePool.Upsert(nodeObject)
+
 			case watch.Deleted:
-				for storedNodeIndex, storedNode := range nodePool.Nodes.Items {
-					if nodeObject.Name == storedNode.Name {
-						nodePool.Nodes.Items[storedNodeIndex] = nodePool.Nodes.Items[len(nodePool.Nodes.Items)-1]
-						nodePool.Nodes.Items = nodePool.Nodes.Items[:len(nodePool.Nodes.Items)-1]
-						break
-					}
-				}
+				nodePool.Delete(nodeObject.Name)
 			}
-			nodePool.Lock.Unlock()
 		}
 
+		mWatchRestartsTotal.WithLabelValues("nodes").Inc()
 		time.Sleep(WatchersLoopTime)
 	}
 }
 
 // WatchEvents watches for some reasoned events on k8s and keep a pool up-to-date with them
 // This function must be executed as a go routine
-func WatchEvents(ctx *Ctx, client *kubernetes.Clientset, eventReason string, eventPool *EventPool) {
+func WatchEvents(ctx *Ctx, client kubernetes.Interface, eventReason string, eventPool *EventPool) {
 
 	// Ensure retry to create a watcher when failing
 	for {
 
 		// Something failed, reset the pool
-		eventPool.Lock.Lock()
-		eventPool.Events = v1.EventList{}
-		eventPool.Lock.Unlock()
+		eventPool.Reset()
 
 		eventWatcher, err := client.CoreV1().Events("default").Watch(context.TODO(), metav1.ListOptions{
 			FieldSelector: fmt.Sprintf("reason=%s", eventReason),
@@ -110,64 +89,35 @@ func WatchEvents(ctx *Ctx, client *kubernetes.Clientset, eventReason string, eve
 
 			ctx.Logger.Debugf(EventChangedMessage, eventObject.Namespace, eventObject.Name)
 
-			eventPool.Lock.Lock()
-
 			switch event.Type {
 			case watch.Added:
 
 				// Filter repeated events coming from same nodes. New will replace the old
-				for storedEventIndex, storedEvent := range eventPool.Events.Items {
-					if eventObject.InvolvedObject.Name == storedEvent.InvolvedObject.Name {
-						eventPool.Events.Items[storedEventIndex] = *eventObject
-						break
-					}
-				}
-
-				// Not found, store it
-				eventPool.Events.Items = append(eventPool.Events.Items, *eventObject)
+				eventPool.Upsert(eventObject)
+				StartNodeTrace(ctx.Traces, eventObject)
 
 			case watch.Deleted:
-				// Remove the event from the pool: last item to current position, then delete last
-				for storedEventIndex, storedEvent := range eventPool.Events.Items {
-
-					if eventObject.InvolvedObject.Name == storedEvent.InvolvedObject.Name {
-						eventPool.Events.Items[storedEventIndex] = eventPool.Events.Items[len(eventPool.Events.Items)-1]
-						eventPool.Events.Items = eventPool.Events.Items[:len(eventPool.Events.Items)-1]
-						break
-					}
-				}
+				EndNodeTrace(ctx.Traces, eventObject.InvolvedObject.Name)
+				eventPool.Delete(eventObject)
 			}
-
-			eventPool.Lock.Unlock()
 		}
 
+		mWatchRestartsTotal.WithLabelValues("events").Inc()
 		time.Sleep(WatchersLoopTime)
 	}
 }
 
 // CleanKubernetesEvents delete old/nosense events from Kubernetes
 // This function must be executed as a go routine
-func CleanKubernetesEvents(ctx *Ctx, client *kubernetes.Clientset, eventPool *EventPool, nodePool *NodePool, hours int) {
+func CleanKubernetesEvents(ctx *Ctx, client kubernetes.Interface, eventPool *EventPool, nodePool *NodePool, hours int) {
 
-	var nodeFound bool
 	for {
 
 		// Review stored events in the pool
-		for _, event := range eventPool.Events.Items {
-
-			// 0. Check if the pool has items: it changes dynamically
-			if len(eventPool.Events.Items) == 0 {
-				break
-			}
+		for _, event := range eventPool.Snapshot() {
 
 			// 1. Check if node is still alive
-			nodeFound = false
-
-			for _, node := range nodePool.Nodes.Items {
-				if event.InvolvedObject.Name == node.Name {
-					nodeFound = true
-				}
-			}
+			_, nodeFound := nodePool.Get(event.InvolvedObject.Name)
 
 			// TODO: check if following behaviour is needed on real production systems
 			// 2. Check if the event is too old
@@ -191,6 +141,19 @@ func CleanKubernetesEvents(ctx *Ctx, client *kubernetes.Clientset, eventPool *Ev
 				if err != nil && !errors.IsNotFound(err) {
 					ctx.Logger.Info(EventNotDeletedFromK8sMessage)
 				}
+
+				reason := "too-old"
+				if !nodeFound {
+					reason = "node-gone"
+				}
+				WriteAuditRecord(ctx, AuditRecord{
+					Action:  AuditActionEventDeleted,
+					Node:    event.InvolvedObject.Name,
+					Event:   event.Namespace + "/" + event.Name,
+					Outcome: GetAuditOutcome(err),
+					Reason:  reason,
+					Error:   GetAuditErrorString(err),
+				})
 			}
 		}
 
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/kubectl/pkg/drain"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// EvictionGraceSecondsAnnotation sets the grace period used when evicting a pod
+	EvictionGraceSecondsAnnotation = "asbooster.docplanner.com/eviction-grace-seconds"
+
+	// SkipEvictionAnnotation excludes a pod from the eviction. It will die together with the node
+	SkipEvictionAnnotation      = "asbooster.docplanner.com/skip-eviction"
+	SkipEvictionAnnotationValue = "true"
+
+	// DrainLastAnnotation makes a pod to be evicted once the rest of the pods of the node are gone
+	DrainLastAnnotation      = "asbooster.docplanner.com/drain-last"
+	DrainLastAnnotationValue = "true"
+
+	// Error messages
+	EvictionGraceSecondsErrorMessage = "invalid value for annotation '%s' on the pod '%s/%s': %v"
+	EvictionStageTimeoutErrorMessage = "%s, %d pods of the node '%s' not evicted yet"
+)
+
+// SkipEvictionPodFilter is a drain filter that skips the pods annotated with SkipEvictionAnnotation
+func SkipEvictionPodFilter(pod v1.Pod) drain.PodDeleteStatus {
+	if pod.Annotations[SkipEvictionAnnotation] == SkipEvictionAnnotationValue {
+		return drain.MakePodDeleteStatusSkip()
+	}
+	return drain.MakePodDeleteStatusOkay()
+}
+
+// GetPodEvictionGracePeriod return the grace period to use when evicting a pod.
+// The value from EvictionGraceSecondsAnnotation is used when present, default one otherwise
+func GetPodEvictionGracePeriod(pod *v1.Pod, defaultGracePeriod int) (gracePeriod int, err error) {
+
+	value, found := pod.Annotations[EvictionGraceSecondsAnnotation]
+	if !found {
+		return defaultGracePeriod, nil
+	}
+
+	gracePeriod, err = strconv.Atoi(value)
+	if err != nil || gracePeriod < 0 {
+		return defaultGracePeriod, fmt.Errorf("expected a non-negative integer, got '%s'", value)
+	}
+
+	return gracePeriod, nil
+}
+
+// GetPodEvictionStages split a list of pods into ordered stages to evict them.
+// Pods annotated with DrainLastAnnotation are placed in the last stage
+func GetPodEvictionStages(pods []v1.Pod) (stages [][]v1.Pod) {
+
+	var firstStage, lastStage []v1.Pod
+	for _, pod := range pods {
+		if pod.Annotations[DrainLastAnnotation] == DrainLastAnnotationValue {
+			lastStage = append(lastStage, pod)
+			continue
+		}
+		firstStage = append(firstStage, pod)
+	}
+
+	for _, stage := range [][]v1.Pod{firstStage, lastStage} {
+		if len(stage) > 0 {
+			stages = append(stages, stage)
+		}
+	}
+
+	return stages
+}
+
+// RunNodeDrainWithPolicies drain a node honouring the eviction policies set on the pods by annotations.
+// Pods are evicted in stages, each stage grouped by grace period to evict the groups concurrently.
+// The timeout of the helper is shared by all the stages, so the whole drain never takes longer than it
+func RunNodeDrainWithPolicies(ctx *Ctx, drainHelper *drain.Helper, nodeName string) error {
+
+	podDeleteList, errs := drainHelper.GetPodsForDeletion(nodeName)
+	if errs != nil {
+		return utilerrors.NewAggregate(errs)
+	}
+	if warnings := podDeleteList.Warnings(); warnings != "" {
+		fmt.Fprintf(drainHelper.ErrOut, "WARNING: %s\n", warnings)
+	}
+
+	var deadline time.Time
+	if drainHelper.Timeout > 0 {
+		deadline = time.Now().Add(drainHelper.Timeout)
+	}
+
+	stages := GetPodEvictionStages(podDeleteList.Pods())
+	for stageIndex, stage := range stages {
+
+		// Give this stage only the time remaining from the drain
+		stageTimeout := drainHelper.Timeout
+		if !deadline.IsZero() {
+			stageTimeout = time.Until(deadline)
+			if stageTimeout <= 0 {
+				pendingPods := 0
+				for _, pendingStage := range stages[stageIndex:] {
+					pendingPods += len(pendingStage)
+				}
+				return fmt.Errorf(EvictionStageTimeoutErrorMessage, DrainTimeoutErrorText, pendingPods, nodeName)
+			}
+		}
+
+		// Group the pods of this stage by their grace period
+		podsByGracePeriod := map[int][]v1.Pod{}
+		for _, pod := range stage {
+			gracePeriod, err := GetPodEvictionGracePeriod(&pod, drainHelper.GracePeriodSeconds)
+			if err != nil {
+				ctx.Logger.Infof(EvictionGraceSecondsErrorMessage, EvictionGraceSecondsAnnotation, pod.Namespace, pod.Name, err)
+			}
+			podsByGracePeriod[gracePeriod] = append(podsByGracePeriod[gracePeriod], pod)
+		}
+
+		gracePeriods := make([]int, 0, len(podsByGracePeriod))
+		for gracePeriod := range podsByGracePeriod {
+			gracePeriods = append(gracePeriods, gracePeriod)
+		}
+		sort.Ints(gracePeriods)
+
+		// Evict each group with a copy of the helper using its own grace period
+		var waitGroup sync.WaitGroup
+		var errsLock sync.Mutex
+		var stageErrs []error
+
+		for _, gracePeriod := range gracePeriods {
+			groupHelper := *drainHelper
+			groupHelper.GracePeriodSeconds = gracePeriod
+			groupHelper.Timeout = stageTimeout
+
+			waitGroup.Add(1)
+			go func(groupHelper drain.Helper, pods []v1.Pod) {
+				defer waitGroup.Done()
+
+				err := groupHelper.DeleteOrEvictPods(pods)
+				if err != nil {
+					errsLock.Lock()
+					stageErrs = append(stageErrs, err)
+					errsLock.Unlock()
+				}
+			}(groupHelper, podsByGracePeriod[gracePeriod])
+		}
+		waitGroup.Wait()
+
+		// Don't evict the following stages when some pod is still alive
+		if len(stageErrs) > 0 {
+			return utilerrors.NewAggregate(stageErrs)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+	"testing"
+	"time"
+)
+
+func TestRunNodeDrainWithPoliciesSharesTimeoutAcrossStages(t *testing.T) {
+	h := NewHarness(t, "--drain-timeout", "2s")
+
+	h.AddPod("pod-first", "node-1")
+	h.AddPod("pod-last", "node-1")
+
+	pod, err := h.Client.CoreV1().Pods("default").Get(context.TODO(), "pod-last", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("impossible to get the pod: %v", err)
+	}
+	pod.Annotations = map[string]string{DrainLastAnnotation: DrainLastAnnotationValue}
+	if _, err = h.Client.CoreV1().Pods("default").Update(context.TODO(), pod, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("impossible to annotate the pod: %v", err)
+	}
+
+	// The first stage is slow but succeeds, while the pod of the last stage never goes away
+	h.Client.PrependReactor("delete", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		switch action.(k8stesting.DeleteAction).GetName() {
+		case "pod-first":
+			time.Sleep(1500 * time.Millisecond)
+			return false, nil, nil
+		case "pod-last":
+			return true, nil, nil
+		}
+		return false, nil, nil
+	})
+
+	start := time.Now()
+	err = RunNodeDrainWithPolicies(h.Ctx, NewDrainHelper(h.Ctx, h.Client), "node-1")
+	elapsed := time.Since(start)
+
+	if err == nil {
+		t.Fatalf("expected the drain to time out")
+	}
+	if elapsed > 3*time.Second {
+		t.Errorf("expected the drain to take about its timeout, took %s", elapsed)
+	}
+	if h.PodExists("pod-first") || !h.PodExists("pod-last") {
+		t.Errorf("expected only the pod of the first stage to be evicted")
+	}
+}
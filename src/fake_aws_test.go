@@ -0,0 +1,438 @@
+package main
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const (
+	// Limits of the fake DescribeTags. Pages are small, so the pagination is exercised by the tests
+	FakeDescribeTagsMaxFilterValues = DescribeTagsMaxFilterValues
+	FakeDescribeTagsPageSize        = 3
+)
+
+// FakeAutoscalingGroup represents an ASG stored in the fake AWS
+type FakeAutoscalingGroup struct {
+	Name              string
+	MinSize           int
+	MaxSize           int
+	DesiredCapacity   int
+	AvailabilityZones []string
+	Tags              map[string]string
+}
+
+// FakeInstance represents an EC2 instance stored in the fake AWS
+type FakeInstance struct {
+	InstanceId           string
+	AutoscalingGroupName string
+	AvailabilityZone     string
+	Lifecycle            string
+	State                string
+}
+
+// FakeAws represents an in-memory AWS account with the ASGs and instances used by the controller
+type FakeAws struct {
+	Lock sync.Mutex
+
+	AutoscalingGroups map[string]*FakeAutoscalingGroup
+	Instances         map[string]*FakeInstance
+
+	// Instances terminated through the ASG API, in order
+	TerminatedInstances []string
+
+	// Instances whose termination is accepted by the ASG API, but never happens
+	StuckInstances map[string]bool
+
+	// Errors to return on the next calls to each operation, in order
+	InjectedErrors map[string][]error
+
+	lastInstanceId int
+}
+
+// NewFakeAws return an empty fake AWS account
+func NewFakeAws() *FakeAws {
+	return &FakeAws{
+		AutoscalingGroups: map[string]*FakeAutoscalingGroup{},
+		Instances:         map[string]*FakeInstance{},
+		InjectedErrors:    map[string][]error{},
+	}
+}
+
+// Client return the clients of the controller pointing to the fake
+func (f *FakeAws) Client() *AwsClient {
+	return &AwsClient{
+		AutoScaling: &fakeAutoScaling{FakeAws: f},
+		EC2:         &fakeEC2{FakeAws: f},
+	}
+}
+
+// AddAutoscalingGroup create an empty ASG backing an EKS nodegroup, spread over two zones
+func (f *FakeAws) AddAutoscalingGroup(name string, nodegroup string, minSize int, maxSize int) {
+	f.Lock.Lock()
+	defer f.Lock.Unlock()
+
+	f.AutoscalingGroups[name] = &FakeAutoscalingGroup{
+		Name:              name,
+		MinSize:           minSize,
+		MaxSize:           maxSize,
+		AvailabilityZones: []string{"eu-west-1a", "eu-west-1b"},
+		Tags:              map[string]string{AWSAutoscalingGroupsNodeGroupTag: nodegroup},
+	}
+}
+
+// LaunchInstance start a spot instance in an ASG, balancing the zones, and return its id.
+// The desired capacity grows when the instances don't fit into it, as if they were launched by a scale-up
+func (f *FakeAws) LaunchInstance(asgName string) (instanceId string, availabilityZone string) {
+	f.Lock.Lock()
+	defer f.Lock.Unlock()
+
+	autoscalingGroup := f.AutoscalingGroups[asgName]
+	instances := f.getRunningInstances(asgName)
+
+	f.lastInstanceId++
+	instanceId = fmt.Sprintf("i-%017x", f.lastInstanceId)
+	availabilityZone = autoscalingGroup.AvailabilityZones[len(instances)%len(autoscalingGroup.AvailabilityZones)]
+
+	f.Instances[instanceId] = &FakeInstance{
+		InstanceId:           instanceId,
+		AutoscalingGroupName: asgName,
+		AvailabilityZone:     availabilityZone,
+		Lifecycle:            SpotInstanceLifecycle,
+		State:                ec2.InstanceStateNameRunning,
+	}
+
+	if len(instances)+1 > autoscalingGroup.DesiredCapacity {
+		autoscalingGroup.DesiredCapacity = len(instances) + 1
+	}
+
+	return instanceId, availabilityZone
+}
+
+// GetDesiredCapacity return the current desired capacity of an ASG
+func (f *FakeAws) GetDesiredCapacity(asgName string) int {
+	f.Lock.Lock()
+	defer f.Lock.Unlock()
+
+	return f.AutoscalingGroups[asgName].DesiredCapacity
+}
+
+// SetTag create or update a tag on an ASG
+func (f *FakeAws) SetTag(asgName string, key string, value string) {
+	f.Lock.Lock()
+	defer f.Lock.Unlock()
+
+	f.AutoscalingGroups[asgName].Tags[key] = value
+}
+
+// IsInstanceRunning return true when the instance exists and was not terminated
+func (f *FakeAws) IsInstanceRunning(instanceId string) bool {
+	f.Lock.Lock()
+	defer f.Lock.Unlock()
+
+	instance, found := f.Instances[instanceId]
+	return found && instance.State == ec2.InstanceStateNameRunning
+}
+
+// SetInstanceLifecycle change the lifecycle of an instance, like "on-demand" to make it not a spot one
+func (f *FakeAws) SetInstanceLifecycle(instanceId string, lifecycle string) {
+	f.Lock.Lock()
+	defer f.Lock.Unlock()
+	f.Instances[instanceId].Lifecycle = lifecycle
+}
+
+// StickInstance make the termination of an instance to be accepted, while the instance keeps running
+func (f *FakeAws) StickInstance(instanceId string) {
+	f.Lock.Lock()
+	defer f.Lock.Unlock()
+	if f.StuckInstances == nil {
+		f.StuckInstances = map[string]bool{}
+	}
+	f.StuckInstances[instanceId] = true
+}
+
+// FailNextCalls make the next calls to an operation fail with an error code, like the throttling ones
+func (f *FakeAws) FailNextCalls(operation string, code string, count int) {
+	f.Lock.Lock()
+	defer f.Lock.Unlock()
+
+	for i := 0; i < count; i++ {
+		f.InjectedErrors[operation] = append(f.InjectedErrors[operation], awserr.New(code, "injected by the test", nil))
+	}
+}
+
+// popInjectedError return the next error to fail a call to an operation, if any. Lock must be held
+func (f *FakeAws) popInjectedError(operation string) error {
+	injectedErrors := f.InjectedErrors[operation]
+	if len(injectedErrors) == 0 {
+		return nil
+	}
+
+	f.InjectedErrors[operation] = injectedErrors[1:]
+	return injectedErrors[0]
+}
+
+// getRunningInstances return the instances of an ASG not terminated, sorted by id. Lock must be held
+func (f *FakeAws) getRunningInstances(asgName string) (instances []*FakeInstance) {
+	for _, instance := range f.Instances {
+		if instance.AutoscalingGroupName == asgName && instance.State == ec2.InstanceStateNameRunning {
+			instances = append(instances, instance)
+		}
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].InstanceId < instances[j].InstanceId })
+	return instances
+}
+
+// fakeAutoScaling implements the calls to the AutoScaling API done by the controller.
+// Calls not implemented panic through the embedded nil interface
+type fakeAutoScaling struct {
+	autoscalingiface.AutoScalingAPI
+	*FakeAws
+}
+
+func (f *fakeAutoScaling) DescribeTags(input *autoscaling.DescribeTagsInput) (*autoscaling.DescribeTagsOutput, error) {
+	f.Lock.Lock()
+	defer f.Lock.Unlock()
+
+	if err := f.popInjectedError("DescribeTags"); err != nil {
+		return nil, err
+	}
+
+	// Tags are returned sorted by ASG and key, paginated as AWS does
+	var tags []*autoscaling.TagDescription
+	for _, filter := range input.Filters {
+		if aws.StringValue(filter.Name) != "auto-scaling-group" {
+			continue
+		}
+
+		if len(filter.Values) > FakeDescribeTagsMaxFilterValues {
+			return nil, awserr.New("ValidationError", "Too many values for the filter", nil)
+		}
+
+		for _, asgName := range aws.StringValueSlice(filter.Values) {
+			autoscalingGroup, found := f.AutoscalingGroups[asgName]
+			if !found {
+				continue
+			}
+
+			for key, value := range autoscalingGroup.Tags {
+				tags = append(tags, &autoscaling.TagDescription{
+					ResourceId:   aws.String(asgName),
+					ResourceType: aws.String("auto-scaling-group"),
+					Key:          aws.String(key),
+					Value:        aws.String(value),
+				})
+			}
+		}
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if *tags[i].ResourceId != *tags[j].ResourceId {
+			return *tags[i].ResourceId < *tags[j].ResourceId
+		}
+		return *tags[i].Key < *tags[j].Key
+	})
+
+	pageStart, _ := strconv.Atoi(aws.StringValue(input.NextToken))
+	pageEnd := pageStart + FakeDescribeTagsPageSize
+	if input.MaxRecords != nil && int(*input.MaxRecords) < FakeDescribeTagsPageSize {
+		pageEnd = pageStart + int(*input.MaxRecords)
+	}
+
+	output := &autoscaling.DescribeTagsOutput{}
+	if pageEnd < len(tags) {
+		output.NextToken = aws.String(strconv.Itoa(pageEnd))
+	} else {
+		pageEnd = len(tags)
+	}
+	output.Tags = tags[pageStart:pageEnd]
+
+	return output, nil
+}
+
+func (f *fakeAutoScaling) CreateOrUpdateTags(input *autoscaling.CreateOrUpdateTagsInput) (*autoscaling.CreateOrUpdateTagsOutput, error) {
+	f.Lock.Lock()
+	defer f.Lock.Unlock()
+
+	if err := f.popInjectedError("CreateOrUpdateTags"); err != nil {
+		return nil, err
+	}
+
+	for _, tag := range input.Tags {
+		autoscalingGroup, found := f.AutoscalingGroups[aws.StringValue(tag.ResourceId)]
+		if !found {
+			return nil, awserr.New("ValidationError", "AutoScalingGroup name not found", nil)
+		}
+		autoscalingGroup.Tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+
+	return &autoscaling.CreateOrUpdateTagsOutput{}, nil
+}
+
+func (f *fakeAutoScaling) DeleteTags(input *autoscaling.DeleteTagsInput) (*autoscaling.DeleteTagsOutput, error) {
+	f.Lock.Lock()
+	defer f.Lock.Unlock()
+
+	if err := f.popInjectedError("DeleteTags"); err != nil {
+		return nil, err
+	}
+
+	for _, tag := range input.Tags {
+		if autoscalingGroup, found := f.AutoscalingGroups[aws.StringValue(tag.ResourceId)]; found {
+			delete(autoscalingGroup.Tags, aws.StringValue(tag.Key))
+		}
+	}
+
+	return &autoscaling.DeleteTagsOutput{}, nil
+}
+
+func (f *fakeAutoScaling) DescribeAutoScalingGroups(input *autoscaling.DescribeAutoScalingGroupsInput) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
+	f.Lock.Lock()
+	defer f.Lock.Unlock()
+
+	if err := f.popInjectedError("DescribeAutoScalingGroups"); err != nil {
+		return nil, err
+	}
+
+	output := &autoscaling.DescribeAutoScalingGroupsOutput{}
+	for _, asgName := range aws.StringValueSlice(input.AutoScalingGroupNames) {
+		autoscalingGroup, found := f.AutoscalingGroups[asgName]
+		if !found {
+			continue
+		}
+
+		group := &autoscaling.Group{
+			AutoScalingGroupName: aws.String(asgName),
+			MinSize:              aws.Int64(int64(autoscalingGroup.MinSize)),
+			MaxSize:              aws.Int64(int64(autoscalingGroup.MaxSize)),
+			DesiredCapacity:      aws.Int64(int64(autoscalingGroup.DesiredCapacity)),
+			AvailabilityZones:    aws.StringSlice(autoscalingGroup.AvailabilityZones),
+		}
+		for _, instance := range f.getRunningInstances(asgName) {
+			group.Instances = append(group.Instances, &autoscaling.Instance{
+				InstanceId:       aws.String(instance.InstanceId),
+				AvailabilityZone: aws.String(instance.AvailabilityZone),
+				LifecycleState:   aws.String(autoscaling.LifecycleStateInService),
+			})
+		}
+		output.AutoScalingGroups = append(output.AutoScalingGroups, group)
+	}
+
+	return output, nil
+}
+
+func (f *fakeAutoScaling) DescribeAutoScalingInstances(input *autoscaling.DescribeAutoScalingInstancesInput) (*autoscaling.DescribeAutoScalingInstancesOutput, error) {
+	f.Lock.Lock()
+	defer f.Lock.Unlock()
+
+	if err := f.popInjectedError("DescribeAutoScalingInstances"); err != nil {
+		return nil, err
+	}
+
+	output := &autoscaling.DescribeAutoScalingInstancesOutput{}
+	for _, instanceId := range aws.StringValueSlice(input.InstanceIds) {
+		instance, found := f.Instances[instanceId]
+		if !found || instance.State != ec2.InstanceStateNameRunning {
+			continue
+		}
+
+		output.AutoScalingInstances = append(output.AutoScalingInstances, &autoscaling.InstanceDetails{
+			InstanceId:           aws.String(instanceId),
+			AutoScalingGroupName: aws.String(instance.AutoscalingGroupName),
+			AvailabilityZone:     aws.String(instance.AvailabilityZone),
+			LifecycleState:       aws.String(autoscaling.LifecycleStateInService),
+		})
+	}
+
+	return output, nil
+}
+
+func (f *fakeAutoScaling) SetDesiredCapacityWithContext(_ aws.Context, input *autoscaling.SetDesiredCapacityInput,
+	_ ...request.Option) (*autoscaling.SetDesiredCapacityOutput, error) {
+	f.Lock.Lock()
+	defer f.Lock.Unlock()
+
+	if err := f.popInjectedError("SetDesiredCapacity"); err != nil {
+		return nil, err
+	}
+
+	autoscalingGroup, found := f.AutoscalingGroups[aws.StringValue(input.AutoScalingGroupName)]
+	if !found {
+		return nil, awserr.New("ValidationError", "AutoScalingGroup name not found", nil)
+	}
+
+	desiredCapacity := int(aws.Int64Value(input.DesiredCapacity))
+	if desiredCapacity < autoscalingGroup.MinSize || desiredCapacity > autoscalingGroup.MaxSize {
+		return nil, awserr.New("ValidationError", "New SetDesiredCapacity value is outside the bounds of the group", nil)
+	}
+
+	autoscalingGroup.DesiredCapacity = desiredCapacity
+	return &autoscaling.SetDesiredCapacityOutput{}, nil
+}
+
+func (f *fakeAutoScaling) TerminateInstanceInAutoScalingGroupWithContext(_ aws.Context, input *autoscaling.TerminateInstanceInAutoScalingGroupInput,
+	_ ...request.Option) (*autoscaling.TerminateInstanceInAutoScalingGroupOutput, error) {
+	f.Lock.Lock()
+	defer f.Lock.Unlock()
+
+	if err := f.popInjectedError("TerminateInstanceInAutoScalingGroup"); err != nil {
+		return nil, err
+	}
+
+	instance, found := f.Instances[aws.StringValue(input.InstanceId)]
+	if !found || instance.State != ec2.InstanceStateNameRunning {
+		return nil, awserr.New("ValidationError", "Instance Id not found", nil)
+	}
+
+	f.TerminatedInstances = append(f.TerminatedInstances, instance.InstanceId)
+	if f.StuckInstances[instance.InstanceId] {
+		return &autoscaling.TerminateInstanceInAutoScalingGroupOutput{}, nil
+	}
+	instance.State = ec2.InstanceStateNameTerminated
+
+	if aws.BoolValue(input.ShouldDecrementDesiredCapacity) {
+		f.AutoscalingGroups[instance.AutoscalingGroupName].DesiredCapacity--
+	}
+
+	return &autoscaling.TerminateInstanceInAutoScalingGroupOutput{}, nil
+}
+
+// fakeEC2 implements the calls to the EC2 API done by the controller
+type fakeEC2 struct {
+	ec2iface.EC2API
+	*FakeAws
+}
+
+func (f *fakeEC2) DescribeInstances(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	f.Lock.Lock()
+	defer f.Lock.Unlock()
+
+	if err := f.popInjectedError("DescribeInstances"); err != nil {
+		return nil, err
+	}
+
+	output := &ec2.DescribeInstancesOutput{}
+	for _, instanceId := range aws.StringValueSlice(input.InstanceIds) {
+		instance, found := f.Instances[instanceId]
+		if !found {
+			return nil, awserr.New("InvalidInstanceID.NotFound", "The instance ID does not exist", nil)
+		}
+
+		output.Reservations = append(output.Reservations, &ec2.Reservation{
+			Instances: []*ec2.Instance{{
+				InstanceId:        aws.String(instanceId),
+				InstanceLifecycle: aws.String(instance.Lifecycle),
+				State:             &ec2.InstanceState{Name: aws.String(instance.State)},
+			}},
+		})
+	}
+
+	return output, nil
+}
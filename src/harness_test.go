@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	// HarnessTimeout is the time waited for a condition of a scenario to be met
+	HarnessTimeout = 30 * time.Second
+
+	// HarnessPollInterval is the time between checks of a condition of a scenario
+	HarnessPollInterval = 100 * time.Millisecond
+)
+
+// syncBuffer is a buffer that can be written by the controller while read by the test
+type syncBuffer struct {
+	lock   sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buffer.String()
+}
+
+// Harness runs the controller against a fake Kubernetes cluster and an in-memory AWS account,
+// so scenarios can be scripted: nodes join, rebalance recommendations arrive, Cluster Autoscaler's status changes, etc.
+type Harness struct {
+	t *testing.T
+
+	Ctx    *Ctx
+	Client *fake.Clientset
+	Aws    *FakeAws
+
+	audit *syncBuffer
+
+	// Instances of the nodes created by the harness
+	lock      sync.Mutex
+	instances map[string]string
+}
+
+// NewHarness return a harness for the controller configured with some flags, on top of the defaults
+func NewHarness(t *testing.T, args ...string) *Harness {
+
+	flagSet := flag.NewFlagSet(t.Name(), flag.ContinueOnError)
+	flags := RegisterControllerFlags(flagSet)
+	err := flagSet.Parse(append([]string{"--time-between-drains", "100ms", "--reconcile-debounce", "100ms",
+		"--aws-api-retry-base-delay", "10ms", "--aws-api-retry-max-delay", "50ms"}, args...))
+	if err != nil {
+		t.Fatalf("invalid flags for the harness: %v", err)
+	}
+
+	harness := &Harness{
+		t:         t,
+		Client:    fake.NewSimpleClientset(),
+		Aws:       NewFakeAws(),
+		audit:     &syncBuffer{},
+		instances: map[string]string{},
+	}
+
+	// Logs are discarded, as the goroutines of the controller keep running once the test is done.
+	// The audit log is kept to check the actions, and shown when a scenario fails
+	harness.Ctx = &Ctx{
+		Ctx:    context.Background(),
+		Logger: zap.NewNop().Sugar(),
+		Flags:  flags,
+		Audit:  &AuditLogger{Writer: harness.audit},
+		Traces: NewNodeTracePool(),
+		Plan:   NewPlan(),
+	}
+
+	// Pods are evicted by deleting them, as the fake doesn't support the eviction API
+	harness.Client.Resources = []*metav1.APIResourceList{{
+		GroupVersion: "v1",
+		APIResources: []metav1.APIResource{{Name: "pods", Namespaced: true, Kind: "Pod"}},
+	}}
+
+	// The fake ignores field selectors, so the pods of a node are filtered as the API server does
+	harness.Client.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		fieldSelector := action.(k8stesting.ListAction).GetListRestrictions().Fields
+		if fieldSelector == nil || fieldSelector.Empty() {
+			return false, nil, nil
+		}
+
+		objects, err := harness.Client.Tracker().List(v1.SchemeGroupVersion.WithResource("pods"),
+			v1.SchemeGroupVersion.WithKind("Pod"), action.GetNamespace())
+		if err != nil {
+			return true, nil, err
+		}
+
+		podList := &v1.PodList{}
+		for _, pod := range objects.(*v1.PodList).Items {
+			if fieldSelector.Matches(fields.Set{"spec.nodeName": pod.Spec.NodeName}) {
+				podList.Items = append(podList.Items, pod)
+			}
+		}
+		return true, podList, nil
+	})
+
+	return harness
+}
+
+// AwsClient return a client of the controller talking to the fake AWS, configured with the flags of the harness
+func (h *Harness) AwsClient() *AwsClient {
+	return NewRateLimitedAwsClient(h.Aws.Client(), GetAwsRetryPolicy(h.Ctx.Flags))
+}
+
+// Start run the whole controller in the background, waiting for its watchers to be ready.
+// The fake doesn't send the existing objects to new watchers, so the objects must be created after this
+func (h *Harness) Start() {
+
+	go SynchronizeBoosts(h.Ctx, h.Client, h.AwsClient(), NewCircuitBreaker())
+
+	h.Eventually("the watchers are started", func() bool {
+		watchedResources := map[string]bool{}
+		for _, action := range h.Client.Actions() {
+			if action.GetVerb() == "watch" {
+				watchedResources[action.GetResource().Resource] = true
+			}
+		}
+		return watchedResources["nodes"] && watchedResources["events"] && watchedResources["configmaps"]
+	})
+}
+
+// Eventually wait for a condition to be met, failing the scenario when it takes too long
+func (h *Harness) Eventually(description string, condition func() bool) {
+	h.t.Helper()
+
+	for start := time.Now(); time.Since(start) < HarnessTimeout; time.Sleep(HarnessPollInterval) {
+		if condition() {
+			return
+		}
+	}
+
+	h.t.Fatalf("timed out waiting until %s. audit log:\n%s", description, h.audit.String())
+}
+
+// AuditRecords return the actions recorded by the controller until now
+func (h *Harness) AuditRecords() (auditRecords []AuditRecord) {
+	for _, line := range strings.Split(strings.TrimSpace(h.audit.String()), "\n") {
+		var auditRecord AuditRecord
+		if json.Unmarshal([]byte(line), &auditRecord) == nil {
+			auditRecords = append(auditRecords, auditRecord)
+		}
+	}
+	return auditRecords
+}
+
+// HasAuditRecord return true when the controller recorded an action matching some fields.
+// Empty fields of the expected record are not compared
+func (h *Harness) HasAuditRecord(expected AuditRecord) bool {
+	for _, auditRecord := range h.AuditRecords() {
+		if auditRecord.Action == expected.Action &&
+			(expected.AutoscalingGroup == "" || auditRecord.AutoscalingGroup == expected.AutoscalingGroup) &&
+			(expected.Node == "" || auditRecord.Node == expected.Node) &&
+			(expected.Outcome == "" || auditRecord.Outcome == expected.Outcome) &&
+			(expected.Reason == "" || auditRecord.Reason == expected.Reason) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddNode launch an instance on an ASG and join its node to the cluster, ready for some time
+func (h *Harness) AddNode(name string, asgName string, readyFor time.Duration) {
+	h.t.Helper()
+
+	instanceId, availabilityZone := h.Aws.LaunchInstance(asgName)
+
+	h.Aws.Lock.Lock()
+	nodegroup := h.Aws.AutoscalingGroups[asgName].Tags[AWSAutoscalingGroupsNodeGroupTag]
+	h.Aws.Lock.Unlock()
+
+	readySince := metav1.NewTime(time.Now().Add(-readyFor))
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			CreationTimestamp: readySince,
+			Labels: map[string]string{
+				AWSNodeGroupLabel:                  nodegroup,
+				"node.kubernetes.io/instance-type": "m5.large",
+			},
+		},
+		Spec: v1.NodeSpec{
+			ProviderID: fmt.Sprintf("aws:///%s/%s", availabilityZone, instanceId),
+		},
+		Status: v1.NodeStatus{
+			Conditions: []v1.NodeCondition{{
+				Type:               v1.NodeReady,
+				Status:             v1.ConditionTrue,
+				LastHeartbeatTime:  metav1.Now(),
+				LastTransitionTime: readySince,
+			}},
+		},
+	}
+
+	_, err := h.Client.CoreV1().Nodes().Create(context.TODO(), node, metav1.CreateOptions{})
+	if err != nil {
+		h.t.Fatalf("impossible to create the node '%s': %v", name, err)
+	}
+
+	h.lock.Lock()
+	h.instances[name] = instanceId
+	h.lock.Unlock()
+}
+
+// GetInstanceId return the instance of a node created by the harness
+func (h *Harness) GetInstanceId(nodeName string) string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.instances[nodeName]
+}
+
+// GetNode return a node from the cluster
+func (h *Harness) GetNode(name string) *v1.Node {
+	h.t.Helper()
+
+	node, err := h.Client.CoreV1().Nodes().Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		h.t.Fatalf("impossible to get the node '%s': %v", name, err)
+	}
+	return node
+}
+
+// AddPod run a pod not managed by any controller on a node
+func (h *Harness) AddPod(name string, nodeName string) {
+	h.t.Helper()
+
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+		Spec:       v1.PodSpec{NodeName: nodeName},
+		Status:     v1.PodStatus{Phase: v1.PodRunning},
+	}
+
+	_, err := h.Client.CoreV1().Pods("default").Create(context.TODO(), pod, metav1.CreateOptions{})
+	if err != nil {
+		h.t.Fatalf("impossible to create the pod '%s': %v", name, err)
+	}
+}
+
+// PodExists return true when a pod is still in the cluster
+func (h *Harness) PodExists(name string) bool {
+	_, err := h.Client.CoreV1().Pods("default").Get(context.TODO(), name, metav1.GetOptions{})
+	return !errors.IsNotFound(err)
+}
+
+// AddRebalanceRecommendation emit the event sent by Node Termination Handler when a node is under risk
+func (h *Harness) AddRebalanceRecommendation(nodeName string) {
+	h.t.Helper()
+
+	event := &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         "default",
+			Name:              fmt.Sprintf("%s.%x", nodeName, time.Now().UnixNano()),
+			CreationTimestamp: metav1.Now(),
+		},
+		InvolvedObject: v1.ObjectReference{Kind: "Node", Name: nodeName},
+		Reason:         RebalanceEvent,
+		Message:        "Rebalance recommendation received. Instance will be cordoned at " + time.Now().UTC().Format(time.RFC3339),
+		Type:           v1.EventTypeNormal,
+	}
+
+	_, err := h.Client.CoreV1().Events("default").Create(context.TODO(), event, metav1.CreateOptions{})
+	if err != nil {
+		h.t.Fatalf("impossible to create the event for the node '%s': %v", nodeName, err)
+	}
+}
+
+// HasRebalanceRecommendation return true when a node has an event under risk in the cluster
+func (h *Harness) HasRebalanceRecommendation(nodeName string) bool {
+	eventList, err := h.Client.CoreV1().Events("default").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return true
+	}
+
+	for _, event := range eventList.Items {
+		if event.Reason == RebalanceEvent && event.InvolvedObject.Name == nodeName {
+			return true
+		}
+	}
+	return false
+}
+
+// UpdateClusterAutoscalerStatus write Cluster Autoscaler's status from the fake ASGs and the nodes of the cluster,
+// counting as ready the nodes whose instances are running
+func (h *Harness) UpdateClusterAutoscalerStatus() {
+	h.t.Helper()
+
+	nodeList, err := h.Client.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		h.t.Fatalf("impossible to list the nodes: %v", err)
+	}
+
+	readyCount := map[string]int{}
+	for _, node := range nodeList.Items {
+		instanceId := GetInstanceIdFromProviderID(node.Spec.ProviderID)
+		if !h.Aws.IsInstanceRunning(instanceId) {
+			continue
+		}
+
+		h.Aws.Lock.Lock()
+		readyCount[h.Aws.Instances[instanceId].AutoscalingGroupName]++
+		h.Aws.Lock.Unlock()
+	}
+
+	h.Aws.Lock.Lock()
+	asgNames := make([]string, 0, len(h.Aws.AutoscalingGroups))
+	for asgName := range h.Aws.AutoscalingGroups {
+		asgNames = append(asgNames, asgName)
+	}
+	sort.Strings(asgNames)
+
+	status := "Cluster-autoscaler status at " + time.Now().UTC().Format("2006-01-02 15:04:05 -0700 MST") + ":\n\nNodeGroups:\n"
+	for _, asgName := range asgNames {
+		autoscalingGroup := h.Aws.AutoscalingGroups[asgName]
+		ready := readyCount[asgName]
+		status += fmt.Sprintf("  Name:        %s\n", asgName)
+		status += fmt.Sprintf("  Health:      Healthy (ready=%d unready=0 notStarted=0 longNotStarted=0 registered=%d longUnregistered=0 "+
+			"cloudProviderTarget=%d (minSize=%d, maxSize=%d))\n\n",
+			ready, ready, autoscalingGroup.DesiredCapacity, autoscalingGroup.MinSize, autoscalingGroup.MaxSize)
+	}
+	h.Aws.Lock.Unlock()
+
+	configmaps := h.Client.CoreV1().ConfigMaps(*h.Ctx.Flags.CAStatusNamespace)
+	configmap := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: *h.Ctx.Flags.CAStatusNamespace,
+			Name:      *h.Ctx.Flags.CAConfigmapName,
+		},
+		Data: map[string]string{"status": status},
+	}
+
+	_, err = configmaps.Update(context.TODO(), configmap, metav1.UpdateOptions{})
+	if errors.IsNotFound(err) {
+		_, err = configmaps.Create(context.TODO(), configmap, metav1.CreateOptions{})
+	}
+	if err != nil {
+		h.t.Fatalf("impossible to write cluster-autoscaler's status: %v", err)
+	}
+}
+
+// PauseBoosting pause boosting cluster-wide through the control ConfigMap
+func (h *Harness) PauseBoosting() {
+	h.t.Helper()
+
+	err := SetPauseControl(h.Ctx, h.Client, PauseBoostingKey, true)
+	if err != nil {
+		h.t.Fatalf("impossible to pause boosting: %v", err)
+	}
+}
@@ -8,6 +8,12 @@ import (
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"net/http"
+)
+
+const (
+	// ControllerName is the name used by the controller to identify itself on Kubernetes
+	ControllerName = "aws-spots-booster"
 )
 
 // GetKubernetesClient Return a Kubernetes client configured to connect from inside or outside the cluster
@@ -28,13 +34,18 @@ func GetKubernetesClient(connectionMode string, kubeconfigPath string) (*kuberne
 		return client, err
 	}
 
+	// Trace the requests done inside a trace
+	config.Wrap(func(roundTripper http.RoundTripper) http.RoundTripper {
+		return &TracingRoundTripper{Next: roundTripper}
+	})
+
 	// Construct the client
 	client, err = kubernetes.NewForConfig(config)
 	return client, err
 }
 
 // KubernetesDeleteEvent delete an event from the cluster
-func KubernetesDeleteEvent(client *kubernetes.Clientset, namespace string, eventName string) (err error) {
+func KubernetesDeleteEvent(client kubernetes.Interface, namespace string, eventName string) (err error) {
 
 	err = client.CoreV1().Events(namespace).Delete(context.TODO(), eventName, metav1.DeleteOptions{
 		// DryRun: []string{"All"},
@@ -44,7 +55,7 @@ func KubernetesDeleteEvent(client *kubernetes.Clientset, namespace string, event
 }
 
 // KubernetesAnnotateNode add some annotations to a node
-func KubernetesAnnotateNode(client *kubernetes.Clientset, node *v1.Node, annotations map[string]string) (err error) {
+func KubernetesAnnotateNode(client kubernetes.Interface, node *v1.Node, annotations map[string]string) (err error) {
 
 	// Merge annotations with existing ones
 	maps.Copy(annotations, node.Annotations)
@@ -60,3 +71,47 @@ func KubernetesAnnotateNode(client *kubernetes.Clientset, node *v1.Node, annotat
 
 	return err
 }
+
+// KubernetesTaintNode add or replace a taint on a node, and set some annotations at the same time
+// Taints with the same key are replaced by the new one
+func KubernetesTaintNode(client kubernetes.Interface, node *v1.Node, taint v1.Taint, annotations map[string]string) (err error) {
+
+	// Replace the taint when already present
+	var taints []v1.Taint
+	for _, storedTaint := range node.Spec.Taints {
+		if storedTaint.Key != taint.Key {
+			taints = append(taints, storedTaint)
+		}
+	}
+	node.Spec.Taints = append(taints, taint)
+
+	// Merge annotations with existing ones
+	maps.Copy(annotations, node.Annotations)
+	node.SetAnnotations(annotations)
+
+	// Update the object in the cluster
+	_, err = client.CoreV1().Nodes().Update(context.TODO(), node, metav1.UpdateOptions{})
+
+	return err
+}
+
+// KubernetesUntaintNode remove a taint from a node by its key, and delete some annotations at the same time
+func KubernetesUntaintNode(client kubernetes.Interface, node *v1.Node, taintKey string, annotationKeys []string) (err error) {
+
+	var taints []v1.Taint
+	for _, storedTaint := range node.Spec.Taints {
+		if storedTaint.Key != taintKey {
+			taints = append(taints, storedTaint)
+		}
+	}
+	node.Spec.Taints = taints
+
+	for _, annotationKey := range annotationKeys {
+		delete(node.Annotations, annotationKey)
+	}
+
+	// Update the object in the cluster
+	_, err = client.CoreV1().Nodes().Update(context.TODO(), node, metav1.UpdateOptions{})
+
+	return err
+}
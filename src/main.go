@@ -10,6 +10,7 @@ import (
 	"k8s.io/client-go/util/homedir"
 	"log"
 	"net/http"
+	"os"
 	"path/filepath"
 	"time"
 )
@@ -19,9 +20,6 @@ const (
 	// DurationToConsiderNewNodes represents the time window to consider nodes joined to Kubernetes as new nodes
 	DurationToConsiderNewNodes = -10 * time.Minute
 
-	// SynchronizationScheduleSeconds The time in seconds between synchronizations
-	SynchronizationScheduleSeconds = 2
-
 	// Info messages
 	GenerateRestClientMessage            = "generating rest client to connect to kubernetes"
 	EventsOnPoolMessage                  = "events on the pool: %d"
@@ -37,106 +35,257 @@ const (
 	GenerateRestClientErrorMessage = "error connecting to kubernetes api: %s"
 	MetricsUpdateErrorMessage      = "imposible to update prometheus metrics"
 	MetricsWebserverErrorMessage   = "imposible to launch metrics webserver: %s"
+	AdminWebserverErrorMessage     = "impossible to launch admin webserver: %s"
+	InstancePricesErrorMessage     = "impossible to load instance prices file: %v"
+	AuditLogErrorMessage           = "impossible to open audit log: %v"
+	TracingErrorMessage            = "impossible to set up tracing: %v"
 )
 
 // SynchronizeBoosts execute all the processes needed to work. It is like main() but more related to the process
 // This function is expected to be run as a goroutine
-func SynchronizeBoosts(ctx *Ctx, client *kubernetes.Clientset) {
+func SynchronizeBoosts(ctx *Ctx, client kubernetes.Interface, awsClient *AwsClient, circuitBreaker *CircuitBreaker) {
+
+	// Synchronize when the pools change, instead of polling them
+	reconciler := NewReconciler(*ctx.Flags.ReconcileDebounce)
 
 	// Update the nodes pool
-	nodePool := &NodePool{}
+	nodePool := NewNodePool()
+	nodePool.OnChange(func() { RequestSynchronization(ctx, reconciler, NodesSource) })
 	go WatchNodes(ctx, client, nodePool)
 
 	// Update the events pool
-	eventPool := &EventPool{}
+	eventPool := NewEventPool()
+	eventPool.OnChange(func() { RequestSynchronization(ctx, reconciler, EventsSource) })
 	go WatchEvents(ctx, client, RebalanceEvent, eventPool)
 
 	// Keep Kubernetes clean
 	go CleanKubernetesEvents(ctx, client, eventPool, nodePool, 24)
 
 	// Load Cluster Autoscaler status configmap on memory JIT
-	autoscalingGroupPool := &AutoscalingGroupPool{}
+	autoscalingGroupPool := NewAutoscalingGroupPool()
+	autoscalingGroupPool.OnChange(func() { RequestSynchronization(ctx, reconciler, AutoscalingGroupsSource) })
 	go WatchStatusConfigmap(ctx, client, autoscalingGroupPool)
 
-	//
-	awsClient, err := AwsCreateSession()
-	if err != nil {
-		ctx.Logger.Infof(GenerateAwsClientErrorMessage, err)
-	}
 	go WatchAutoScalingGroupsTags(ctx, awsClient, autoscalingGroupPool)
+	go WatchAutoScalingGroupsTopology(ctx, awsClient, autoscalingGroupPool)
+
+	// Write snapshots of the inputs to replay them later
+	if *ctx.Flags.RecordDir != "" {
+		go RecordSnapshots(ctx, nodePool, eventPool, autoscalingGroupPool)
+	}
+
+	// Move new pods away from the nodes under risk gradually
+	if *ctx.Flags.EnableSoftCordon {
+		go SoftCordonNodesUnderRisk(ctx, client, eventPool, nodePool)
+	}
 
 	// Launch a drainer in the background
 	if !*ctx.Flags.DisableDrain {
-		go DrainNodesUnderRisk(ctx, client, awsClient, eventPool, nodePool)
+		terminationQueue := &TerminationQueue{}
+		go ProcessTerminationQueue(ctx, client, awsClient, terminationQueue)
+		go DrainNodesUnderRisk(ctx, client, eventPool, nodePool, autoscalingGroupPool, terminationQueue)
 	}
 
-	// Start working with the events
-	for {
+	// Load the prices used by the cost budget
+	instancePrices, err := LoadInstancePrices(*ctx.Flags.InstancePricesFile)
+	if err != nil {
+		ctx.Logger.Fatalf(InstancePricesErrorMessage, err)
+	}
 
-		ctx.Logger.Infof(EventsOnPoolMessage, len(eventPool.Events.Items))
-		ctx.Logger.Infof(NodesOnPoolMessage, len(nodePool.Nodes.Items))
+	// Detect replacement nodes under risk too
+	replacementRiskTracker := NewReplacementRiskTracker()
 
-		// Get a map of node-group, each value is the count of its nodes
-		nodeGroupNodesCount := GetNodeCountByNodeGroup(nodePool)
-		ctx.Logger.Infof(NodesByNodegroupMessage, nodeGroupNodesCount)
+	// Record the trims of the budgets only when they change
+	boostBudgetTracker := NewBoostBudgetTracker()
 
-		// Get a map of node-group, each value is the count of its events
-		nodeGroupEventsCount := GetEventCountByNodeGroup(eventPool, nodePool)
-		ctx.Logger.Infof(EventsByNodegroupMessage, nodeGroupEventsCount)
+	// Start working with the events
+	RunReconciler(ctx, reconciler, *ctx.Flags.ResyncPeriod, func() {
+		loopStart := time.Now()
 
-		// Get a map of node-group, each value is the count of its cordoned nodes
-		nodeGroupCordonedNodesCount := GetCordonedNodeCountByNodeGroup(nodePool)
-		ctx.Logger.Infof(CordonedNodesByNodegroupMessage, nodeGroupCordonedNodesCount)
+		ctx.Logger.Infof(EventsOnPoolMessage, eventPool.Len())
+		ctx.Logger.Infof(NodesOnPoolMessage, nodePool.Len())
 
-		nodeGroupRecentReadyNodesCount := GetRecentlyReadyNodeCountByNodeGroup(nodePool, DurationToConsiderNewNodes, true)
-		ctx.Logger.Infof(RecentlyReadyNodesByNodegroupMessage, nodeGroupRecentReadyNodesCount)
+		// Group the pools by node-group once, to be shared by the calculations and the metrics
+		aggregate := NewNodegroupsAggregate(eventPool, nodePool, *ctx.Flags.MaxTimeConsiderNewNodes, true)
+		ctx.Logger.Infof(NodesByNodegroupMessage, aggregate.NodesCount)
+		ctx.Logger.Infof(EventsByNodegroupMessage, aggregate.EventsCount)
+		ctx.Logger.Infof(CordonedNodesByNodegroupMessage, aggregate.CordonedNodesCount)
+		ctx.Logger.Infof(RecentlyReadyNodesByNodegroupMessage, aggregate.RecentlyReadyCount)
 
 		// Calculate final capacity for the ASGs
-		asgsDesiredCapacities, err := CalculateDesiredCapacityASGs(autoscalingGroupPool, nodeGroupEventsCount)
+		calculationStart := time.Now()
+		asgsDesiredCapacities, err := CalculateDesiredCapacityASGs(autoscalingGroupPool, aggregate.EventsCount)
 		if err != nil {
 			ctx.Logger.Fatal(err)
 		}
 		ctx.Logger.Infof(ShowCalculationsMessage, asgsDesiredCapacities)
-
-		err = SetDesiredCapacityASGs(ctx, awsClient, autoscalingGroupPool, asgsDesiredCapacities)
-		if err != nil {
-			ctx.Logger.Fatal(err)
+		updateCalculatedCapacityMetrics(asgsDesiredCapacities)
+		RecordCapacityCalculationSpans(ctx.Traces, nodePool, autoscalingGroupPool, asgsDesiredCapacities, calculationStart)
+
+		// Keep the boosts into the budgets
+		asgsBudgetedCapacities, _ := ApplyBoostBudgets(ctx, boostBudgetTracker, autoscalingGroupPool, nodePool, instancePrices, asgsDesiredCapacities)
+
+		// Protect the ASGs against runaway scaling
+		RecordFreshNodeEvents(ctx, circuitBreaker, eventPool, nodePool, autoscalingGroupPool)
+		EvaluateCircuitBreaker(ctx, circuitBreaker)
+		RecordReplacementsAtRisk(ctx, replacementRiskTracker, eventPool, nodePool, autoscalingGroupPool)
+
+		// Review whether boosting is paused
+		pauseControls := GetPauseControls(ctx, client)
+		updatePauseMetrics(pauseControls, nodePool, autoscalingGroupPool)
+
+		if pauseControls.Boosting {
+			ctx.Logger.Info(BoostingPausedMessage)
+		} else {
+			err = SetDesiredCapacityASGs(ctx, awsClient, autoscalingGroupPool, circuitBreaker, replacementRiskTracker, reconciler,
+				asgsDesiredCapacities, aggregate.EventsCount, asgsBudgetedCapacities)
+			if err != nil {
+				ctx.Logger.Fatal(err)
+			}
 		}
 
 		// Update Prometheus metrics from AutoscalingGroups type data
-		err = upgradePrometheusMetrics(eventPool, nodePool, autoscalingGroupPool)
+		err = upgradePrometheusMetrics(aggregate, autoscalingGroupPool)
 		if err != nil {
 			ctx.Logger.Info(MetricsUpdateErrorMessage)
 		}
 
-		time.Sleep(SynchronizationScheduleSeconds * time.Second)
+		observeLoopDuration(SynchronizationLoopName, loopStart)
+	})
+}
+
+// GetEnv return the value of an environment variable, or a default value when it is not set
+func GetEnv(key string, defaultValue string) string {
+	value, found := os.LookupEnv(key)
+	if !found {
+		return defaultValue
 	}
+	return value
 }
 
-func main() {
+// RegisterControllerFlags define the flags of the controller on a flag set, returning where their values are stored
+// Subcommands register them too, so they can be evaluated with the same configuration
+func RegisterControllerFlags(flagSet *flag.FlagSet) *ControllerFlags {
 
 	flags := &ControllerFlags{}
 
-	// Get the values from flags
-	flags.ConnectionMode = flag.String("connection-mode", "kubectl", "(optional) what type of connection to use: incluster, kubectl")
-	flags.Kubeconfig = flag.String("kubeconfig", filepath.Join(homedir.HomeDir(), ".kube", "config"), "(optional) absolute path to the kubeconfig file")
-	flags.DryRun = flag.Bool("dry-run", false, "skip actual changes")
-
-	flags.CAStatusNamespace = flag.String("ca-status-namespace", "kube-system", "kubernetes Namespace where to read cluster-utoscaler's status configmap")
-	flags.CAConfigmapName = flag.String("ca-status-name", "cluster-autoscaler-status", "name of the cluster-autoscaler's status configmap")
+	flags.ConnectionMode = flagSet.String("connection-mode", "kubectl", "(optional) what type of connection to use: incluster, kubectl")
+	flags.Kubeconfig = flagSet.String("kubeconfig", filepath.Join(homedir.HomeDir(), ".kube", "config"), "(optional) absolute path to the kubeconfig file")
+	flags.DryRun = flagSet.Bool("dry-run", false, "skip actual changes")
+
+	flags.ControlConfigmapNamespace = flagSet.String("control-configmap-namespace", "aws-spots-booster", "kubernetes Namespace where to read the configmap to pause the controller")
+	flags.ControlConfigmapName = flagSet.String("control-configmap-name", "aws-spots-booster-control", "name of the configmap to pause the controller")
+
+	flags.CAStatusNamespace = flagSet.String("ca-status-namespace", "kube-system", "kubernetes Namespace where to read cluster-utoscaler's status configmap")
+	flags.CAConfigmapName = flagSet.String("ca-status-name", "cluster-autoscaler-status", "name of the cluster-autoscaler's status configmap")
+
+	flags.ReconcileDebounce = flagSet.Duration("reconcile-debounce", 2*time.Second, "duration to wait after a change on nodes, events or autoscaling groups before synchronizing, coalescing the changes arriving meanwhile")
+	flags.ResyncPeriod = flagSet.Duration("resync-period", 30*time.Second, "duration between periodic synchronizations, done even when nothing changed")
+
+	flags.AwsApiQPS = flagSet.Float64("aws-api-qps", 2, "rate of calls allowed per aws api operation (0 disables the rate limit)")
+	flags.AwsApiBurst = flagSet.Int("aws-api-burst", 5, "burst of calls allowed per aws api operation over the rate")
+	flags.AwsApiMaxRetries = flagSet.Int("aws-api-max-retries", 5, "retries allowed per aws api call on throttling, contention or transient errors")
+	flags.AwsApiRetryBaseDelay = flagSet.Duration("aws-api-retry-base-delay", 500*time.Millisecond, "duration to wait before the first retry of an aws api call, doubled on each following one")
+	flags.AwsApiRetryMaxDelay = flagSet.Duration("aws-api-retry-max-delay", 20*time.Second, "max duration to wait between the retries of an aws api call")
+
+	flags.IgnoredAutoscalingGroups = flagSet.String("ignored-autoscaling-groups", "", "comma-separated list of autoscaling-group names to ignore on ASGs boosting")
+	flags.ExtraNodesOverCalculations = flagSet.Int("extra-nodes-over-calculation", 0, "extra nodes to add over calculated ones")
+	flags.AllowUnbalancedASGs = flagSet.Bool("allow-unbalanced-autoscaling-groups", false, "boost single-az and az-imbalanced autoscaling groups too (not recommended)")
+	flags.AZImbalanceTolerance = flagSet.Int("az-imbalance-tolerance", 2, "max difference of instances between availability zones to consider an autoscaling group balanced")
+	flags.AutoscalingGroupTagsTTL = flagSet.Duration("autoscaling-group-tags-ttl", 30*time.Second, "duration to cache the tags of the autoscaling groups before describing them on aws again")
+
+	flags.MaxBoostedNodesPerASG = flagSet.Int("max-boosted-nodes-per-asg", 0, "maximum number of nodes over the ready ones to request per autoscaling group (0 means no limit)")
+	flags.MaxBoostedNodesCluster = flagSet.Int("max-boosted-nodes-cluster", 0, "maximum number of nodes over the ready ones to request in the whole cluster (0 means no limit)")
+	flags.HourlyCostBudget = flagSet.Float64("hourly-cost-budget", 0, "maximum estimated hourly cost of the boosted nodes in the whole cluster (0 means no limit)")
+	flags.InstancePricesFile = flagSet.String("instance-prices-file", "", "path to a JSON file with the hourly price for each instance type, used by the cost budget")
+
+	flags.DisableDrain = flagSet.Bool("disable-drain", false, "disable drain-and-destroy process for nodes under risk (not recommended)")
+	flags.TimeBetweenDrains = flagSet.Duration("time-between-drains", 15*time.Second, "duration between scheduling a batch drainages and the following (when new nodes are ready)")
+	flags.DrainTimeout = flagSet.Duration("drain-timeout", 120*time.Second, "duration to consider a drain as done when not finished")
+	flags.MaxConcurrentDrains = flagSet.Int("max-concurrent-drains", 5, "maximum number of nodes to drain at once")
+	flags.IgnorePodsGracePeriod = flagSet.Bool("ignore-pods-grace-period", false, "ignore waiting for pod's grace period on termination when draininge")
+	flags.MaxTimeConsiderNewNodes = flagSet.Duration("max-time-consider-new-node", DurationToConsiderNewNodes, "max time to consider a node as new after joined to the cluster")
+	flags.WaitReplacementPods = flagSet.Bool("wait-replacement-pods", false, "wait for the workloads evicted on each drain to be available before draining the next batch of nodes")
+	flags.ReplacementPodsTimeout = flagSet.Duration("replacement-pods-timeout", 5*time.Minute, "max duration to wait for the workloads evicted on a drain to be available")
+
+	flags.TerminationsPerMinute = flagSet.Int("terminations-per-minute", 10, "maximum number of instances to terminate per minute (0 means no limit)")
+	flags.TerminationConfirmTimeout = flagSet.Duration("termination-confirm-timeout", 5*time.Minute, "max duration to wait for aws to confirm the termination of an instance")
+
+	flags.EnableSoftCordon = flagSet.Bool("enable-soft-cordon", false, "taint nodes under risk with PreferNoSchedule as soon as their events arrive, before draining them")
+	flags.SoftCordonEscalationDelay = flagSet.Duration("soft-cordon-escalation-delay", 2*time.Minute, "duration before escalating the soft-cordon taint from PreferNoSchedule to NoSchedule")
+
+	flags.PreDrainWebhookURL = flagSet.String("pre-drain-webhook-url", "", "(optional) url called before draining a node. it can veto (409) or delay (202, 425, 429) the drain")
+	flags.PostDrainWebhookURL = flagSet.String("post-drain-webhook-url", "", "(optional) url called after terminating a drained node")
+	flags.WebhookTimeout = flagSet.Duration("webhook-timeout", 5*time.Second, "timeout for each request done to the drain webhooks")
+	flags.WebhookRetries = flagSet.Int("webhook-retries", 2, "number of retries when a request to the drain webhooks fails")
+	flags.WebhookSecret = flagSet.String("webhook-secret", "", "(optional) secret to sign the payloads sent to the drain webhooks using HMAC-SHA256")
+	flags.WebhookFailurePolicy = flagSet.String("webhook-failure-policy", WebhookFailurePolicyIgnore, "what to do with a drain when the pre-drain webhook fails after all the retries: ignore, fail")
+
+	flags.CircuitBreakerWindow = flagSet.Duration("circuit-breaker-window", time.Hour, "sliding window used by the circuit breaker to count boosts and events")
+	flags.CircuitBreakerCooldown = flagSet.Duration("circuit-breaker-cooldown", time.Hour, "duration to keep boosting frozen once the circuit breaker is tripped")
+	flags.CircuitBreakerMaxBoostsPerASG = flagSet.Int("circuit-breaker-max-boosts-per-asg", 10, "boosts allowed per autoscaling group in the window before tripping the circuit breaker (0 disables it)")
+	flags.CircuitBreakerMaxBoostsCluster = flagSet.Int("circuit-breaker-max-boosts-cluster", 30, "boosts allowed in the whole cluster in the window before tripping the circuit breaker (0 disables it)")
+	flags.CircuitBreakerMaxFreshNodesEvents = flagSet.Int("circuit-breaker-max-fresh-node-events", 5, "events from freshly launched nodes allowed per autoscaling group in the window before tripping the circuit breaker (0 disables it)")
+
+	flags.ReplacementLaunchWindow = flagSet.Duration("replacement-launch-window", 10*time.Minute, "max duration between a boost and the creation of a node to consider it a replacement launched by the boost")
+	flags.ReplacementRiskWindow = flagSet.Duration("replacement-risk-window", time.Hour, "sliding window used to count replacement nodes under risk per autoscaling group")
+	flags.ReplacementRiskBackoff = flagSet.Duration("replacement-risk-backoff", 30*time.Minute, "duration to back off boosting an autoscaling group when its replacement nodes keep being under risk")
+	flags.MaxReplacementsAtRisk = flagSet.Int("max-replacements-at-risk", 3, "replacement nodes under risk allowed per autoscaling group in the window before backing off (0 disables it)")
+
+	flags.AuditLogPath = flagSet.String("audit-log-path", "", "(optional) path to the file where to append the audit records as JSON lines. use 'stdout' to write them there")
+	flags.AuditLogMaxSizeMB = flagSet.Int("audit-log-max-size-mb", 100, "size in megabytes to rotate the audit log file (0 disables the rotation)")
+	flags.AuditLogMaxBackups = flagSet.Int("audit-log-max-backups", 5, "number of rotated audit log files to retain")
+
+	flags.ControllerNamespace = flagSet.String("controller-namespace", GetEnv("POD_NAMESPACE", "aws-spots-booster"), "kubernetes Namespace where the controller is running. POD_NAMESPACE env is used by default")
+	flags.ControllerDeploymentName = flagSet.String("controller-deployment-name", "aws-spots-booster", "name of the controller's Deployment, where to emit kubernetes events about the autoscaling groups (empty disables them)")
+	flags.KubernetesEventsBurst = flagSet.Int("kubernetes-events-burst", 25, "burst of kubernetes events allowed per object and reason")
+	flags.KubernetesEventsQPS = flagSet.Float64("kubernetes-events-qps", 1.0/60, "rate of kubernetes events allowed per object and reason, once the burst is consumed")
+
+	flags.RecordDir = flagSet.String("record-dir", "", "(optional) directory where to write periodic snapshots of the inputs seen by the controller, to be replayed later")
+	flags.RecordInterval = flagSet.Duration("record-interval", time.Minute, "duration between the snapshots written on record mode")
+	flags.RecordMaxSnapshots = flagSet.Int("record-max-snapshots", 1440, "number of snapshots to retain on record mode, deleting the oldest ones (0 means no limit)")
+	flags.RecordRedactNames = flagSet.Bool("record-redact-names", false, "replace the names of the cluster, nodes, events, nodegroups, autoscaling groups and instances on the snapshots with stable hashes")
+	flags.RecordRedactIPs = flagSet.Bool("record-redact-ips", false, "replace the ip addresses on the snapshots with stable hashes")
+
+	flags.TracingOTLPEndpoint = flagSet.String("tracing-otlp-endpoint", "", "(optional) host:port of the otlp/http collector where to export the traces of the nodes under risk (empty disables tracing)")
+	flags.TracingOTLPInsecure = flagSet.Bool("tracing-otlp-insecure", false, "export the traces without tls")
+	flags.TracingSampleRatio = flagSet.Float64("tracing-sample-ratio", 1, "ratio of the nodes under risk to be traced, between 0 and 1")
+
+	flags.MetricsPort = flagSet.String("metrics-port", "2112", "port where metrics web-server will run")
+	flags.MetricsHost = flagSet.String("metrics-host", "0.0.0.0", "host where metrics web-server will run")
+
+	flags.AdminPort = flagSet.String("admin-port", "2113", "port where admin web-server will run, serving the endpoints that change the state of the controller")
+	flags.AdminHost = flagSet.String("admin-host", "127.0.0.1", "host where admin web-server will run. keep it local unless a token is set")
+	flags.AdminToken = flagSet.String("admin-token", GetEnv("ADMIN_TOKEN", ""), "(optional) bearer token required by the admin endpoints. ADMIN_TOKEN env is used by default")
+
+	return flags
+}
 
-	flags.IgnoredAutoscalingGroups = flag.String("ignored-autoscaling-groups", "", "comma-separated list of autoscaling-group names to ignore on ASGs boosting")
-	flags.ExtraNodesOverCalculations = flag.Int("extra-nodes-over-calculation", 0, "extra nodes to add over calculated ones")
+func main() {
 
-	flags.DisableDrain = flag.Bool("disable-drain", false, "disable drain-and-destroy process for nodes under risk (not recommended)")
-	flags.TimeBetweenDrains = flag.Duration("time-between-drains", 15*time.Second, "duration between scheduling a batch drainages and the following (when new nodes are ready)")
-	flags.DrainTimeout = flag.Duration("drain-timeout", 120*time.Second, "duration to consider a drain as done when not finished")
-	flags.MaxConcurrentDrains = flag.Int("max-concurrent-drains", 5, "maximum number of nodes to drain at once")
-	flags.IgnorePodsGracePeriod = flag.Bool("ignore-pods-grace-period", false, "ignore waiting for pod's grace period on termination when draininge")
-	flags.MaxTimeConsiderNewNodes = flag.Duration("max-time-consider-new-node", DurationToConsiderNewNodes, "max time to consider a node as new after joined to the cluster")
+	// Run the subcommands instead of the controller when requested
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case SimulateCommand:
+			RunSimulateCommand(os.Args[2:])
+			return
+		case StatusCommand:
+			RunStatusCommand(os.Args[2:])
+			return
+		case DrainCommand:
+			RunDrainCommand(os.Args[2:])
+			return
+		case UnboostCommand:
+			RunUnboostCommand(os.Args[2:])
+			return
+		case PauseCommand, ResumeCommand:
+			RunPauseCommand(os.Args[1], os.Args[2:])
+			return
+		}
+	}
 
-	flags.MetricsPort = flag.String("metrics-port", "2112", "port where metrics web-server will run")
-	flags.MetricsHost = flag.String("metrics-host", "0.0.0.0", "host where metrics web-server will run")
+	// Get the values from flags
+	flags := RegisterControllerFlags(flag.CommandLine)
 	flag.Parse()
 
 	//
@@ -163,6 +312,23 @@ func main() {
 		Flags:  flags,
 	}
 
+	// Open the audit log to record the actions
+	ctx.Audit, err = NewAuditLogger(*ctx.Flags.AuditLogPath, *ctx.Flags.AuditLogMaxSizeMB, *ctx.Flags.AuditLogMaxBackups)
+	if err != nil {
+		ctx.Logger.Fatalf(AuditLogErrorMessage, err)
+	}
+
+	// Store the changes intended on dry-run
+	ctx.Plan = NewPlan()
+
+	// Trace the lifecycle of the nodes under risk
+	ctx.Traces = NewNodeTracePool()
+	shutdownTracing, err := SetupTracing(&ctx)
+	if err != nil {
+		ctx.Logger.Fatalf(TracingErrorMessage, err)
+	}
+	defer shutdownTracing(mainCtx)
+
 	// Generate the Kubernetes client to modify the resources
 	ctx.Logger.Info(GenerateRestClientMessage)
 	client, err := GetKubernetesClient(*ctx.Flags.ConnectionMode, *ctx.Flags.Kubeconfig)
@@ -170,12 +336,34 @@ func main() {
 		ctx.Logger.Infof(GenerateRestClientErrorMessage, err)
 	}
 
+	// Emit Kubernetes events about the actions
+	ctx.Recorder = NewEventRecorder(client, *ctx.Flags.KubernetesEventsBurst, float32(*ctx.Flags.KubernetesEventsQPS))
+
+	// Generate the AWS clients to change the ASGs and terminate the instances
+	awsClient, err := AwsCreateSession(GetAwsRetryPolicy(ctx.Flags))
+	if err != nil {
+		ctx.Logger.Infof(GenerateAwsClientErrorMessage, err)
+	}
+
 	// Parse Cluster Autoscaler's status configmap in the background
-	go SynchronizeBoosts(&ctx, client)
+	circuitBreaker := NewCircuitBreaker()
+	go SynchronizeBoosts(&ctx, client, awsClient, circuitBreaker)
+
+	// Start a webserver for the endpoints changing the state, apart from the metrics one, as it is usually reachable by others
+	adminHost := *ctx.Flags.AdminHost + ":" + *ctx.Flags.AdminPort
+	adminMux := http.NewServeMux()
+	adminMux.Handle("/circuit-breaker/reset", CircuitBreakerResetHandler(&ctx, circuitBreaker))
+	go func() {
+		err := http.ListenAndServe(adminHost, adminMux)
+		if err != nil {
+			ctx.Logger.Infof(AdminWebserverErrorMessage, err)
+		}
+	}()
 
 	// Start a webserver for exposing metrics endpoint
 	metricsHost := *ctx.Flags.MetricsHost + ":" + *ctx.Flags.MetricsPort
 	http.Handle("/metrics", promhttp.Handler())
+	http.Handle("/plan", PlanHandler(&ctx))
 	err = http.ListenAndServe(metricsHost, nil)
 	if err != nil {
 		ctx.Logger.Infof(MetricsWebserverErrorMessage, err)
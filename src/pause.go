@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// Keys of the control ConfigMap to pause the processes cluster-wide
+	PauseBoostingKey  = "pause-boosting"
+	PauseDrainingKey  = "pause-draining"
+	PauseControlValue = "true"
+
+	// PausedTag is the ASG tag to pause boosting a single group
+	PausedTag      = "asbooster.docplanner.com/paused"
+	PausedTagValue = "true"
+
+	// ExcludeFromDrainAnnotation is the node's annotation to exclude a node from the drain process
+	ExcludeFromDrainAnnotation      = "asbooster.docplanner.com/exclude-from-drain"
+	ExcludeFromDrainAnnotationValue = "true"
+
+	// Info messages
+	BoostingPausedMessage         = "boosting is paused by the control configmap"
+	DrainingPausedMessage         = "draining is paused by the control configmap, will be reviewed in the next loop"
+	AutoscalingGroupPausedMessage = "skipping changes for paused asg: %s"
+	NodeExcludedFromDrainMessage  = "node '%s' is excluded from drain by annotation"
+
+	// Error messages
+	ControlConfigmapErrorMessage = "impossible to read the control configmap, assuming nothing is paused: %v"
+)
+
+// PauseControls represents which processes are paused cluster-wide
+type PauseControls struct {
+	Boosting bool
+	Draining bool
+}
+
+// GetPauseControls read the control ConfigMap from the cluster to know which processes are paused.
+// Nothing is paused when the ConfigMap does not exist
+func GetPauseControls(ctx *Ctx, client kubernetes.Interface) (pauseControls PauseControls) {
+
+	configmap, err := client.CoreV1().ConfigMaps(*ctx.Flags.ControlConfigmapNamespace).Get(context.TODO(), *ctx.Flags.ControlConfigmapName, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			ctx.Logger.Infof(ControlConfigmapErrorMessage, err)
+		}
+		return pauseControls
+	}
+
+	pauseControls.Boosting = configmap.Data[PauseBoostingKey] == PauseControlValue
+	pauseControls.Draining = configmap.Data[PauseDrainingKey] == PauseControlValue
+
+	return pauseControls
+}
+
+// SetPauseControl pause or resume a process cluster-wide, changing its key on the control ConfigMap.
+// The ConfigMap is created when it does not exist yet
+func SetPauseControl(ctx *Ctx, client kubernetes.Interface, key string, paused bool) (err error) {
+
+	configmaps := client.CoreV1().ConfigMaps(*ctx.Flags.ControlConfigmapNamespace)
+
+	configmap, err := configmaps.Get(context.TODO(), *ctx.Flags.ControlConfigmapName, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+
+		// Nothing is paused without the ConfigMap
+		if !paused {
+			return nil
+		}
+
+		configmap = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: *ctx.Flags.ControlConfigmapNamespace,
+				Name:      *ctx.Flags.ControlConfigmapName,
+			},
+			Data: map[string]string{key: PauseControlValue},
+		}
+		_, err = configmaps.Create(context.TODO(), configmap, metav1.CreateOptions{})
+		return err
+	}
+
+	if configmap.Data == nil {
+		configmap.Data = map[string]string{}
+	}
+
+	if paused {
+		configmap.Data[key] = PauseControlValue
+	} else {
+		delete(configmap.Data, key)
+	}
+
+	_, err = configmaps.Update(context.TODO(), configmap, metav1.UpdateOptions{})
+	return err
+}
+
+// SetAutoscalingGroupPaused pause or resume boosting a single ASG, changing its tag on AWS
+func SetAutoscalingGroupPaused(awsClient *AwsClient, autoscalingGroupName string, paused bool) error {
+
+	if paused {
+		return AwsSetAutoScalingGroupTag(awsClient, autoscalingGroupName, PausedTag, PausedTagValue)
+	}
+
+	return AwsDeleteAutoScalingGroupTag(awsClient, autoscalingGroupName, PausedTag)
+}
+
+// IsAutoscalingGroupPaused return true when an ASG is tagged to pause its boosting
+func IsAutoscalingGroupPaused(autoscalingGroupPool *AutoscalingGroupPool, autoscalingGroupName string) bool {
+
+	autoscalingGroup, found := autoscalingGroupPool.Get(autoscalingGroupName)
+
+	return found && autoscalingGroup.Tags[PausedTag] == PausedTagValue
+}
+
+// IsNodeExcludedFromDrain return true when a node is annotated to be excluded from the drain process
+func IsNodeExcludedFromDrain(nodePool *NodePool, nodeName string) bool {
+
+	node, found := nodePool.Get(nodeName)
+
+	return found && node.Annotations[ExcludeFromDrainAnnotation] == ExcludeFromDrainAnnotationValue
+}
+
+// updatePauseMetrics reflect the state of the pause controls on the metrics
+func updatePauseMetrics(pauseControls PauseControls, nodePool *NodePool, autoscalingGroupPool *AutoscalingGroupPool) {
+
+	boolToFloat := map[bool]float64{false: 0, true: 1}
+
+	mPaused.WithLabelValues("boosting").Set(boolToFloat[pauseControls.Boosting])
+	mPaused.WithLabelValues("draining").Set(boolToFloat[pauseControls.Draining])
+
+	for _, autoscalingGroup := range autoscalingGroupPool.Snapshot() {
+		paused := autoscalingGroup.Tags[PausedTag] == PausedTagValue
+		mAutoscalingGroupPaused.WithLabelValues(autoscalingGroup.Name).Set(boolToFloat[paused])
+	}
+
+	var excludedNodes int
+	for _, node := range nodePool.Snapshot() {
+		if node.Annotations[ExcludeFromDrainAnnotation] == ExcludeFromDrainAnnotationValue {
+			excludedNodes++
+		}
+	}
+
+	mNodesExcludedFromDrain.Set(float64(excludedNodes))
+}
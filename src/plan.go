@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"golang.org/x/exp/maps"
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+)
+
+const (
+	// PlanMaxDrainBatches is the maximum number of drain batches to plan ahead
+	PlanMaxDrainBatches = 100
+
+	// Info messages
+	PlannedBoostMessage       = "dry-run plan: desired capacity of asg '%s' would change from '%d' to '%d'"
+	PlannedBoostSkipMessage   = "dry-run plan: desired capacity of asg '%s' would stay at '%d': %s"
+	PlannedDrainMessage       = "dry-run plan: batch %d would drain the node '%s' of nodegroup '%s', replaced by '%s'"
+	PlannedTerminationMessage = "dry-run plan: instance '%s' of the node '%s' would be terminated"
+)
+
+// PlannedBoost represents the change of the desired capacity of an ASG intended on dry-run
+type PlannedBoost struct {
+	AutoscalingGroup string `json:"autoscalingGroup"`
+	Current          int    `json:"current"`
+	Intended         int    `json:"intended"`
+	Reason           string `json:"reason,omitempty"`
+}
+
+// PlannedDrain represents the drain of a node intended on dry-run, and the termination of its instance
+type PlannedDrain struct {
+	Batch            int    `json:"batch"`
+	Nodegroup        string `json:"nodegroup"`
+	AutoscalingGroup string `json:"autoscalingGroup"`
+	Node             string `json:"node"`
+	Instance         string `json:"instance"`
+	ReplacementNode  string `json:"replacementNode"`
+}
+
+// NewPlan return a dry-run plan ready to be used
+func NewPlan() *Plan {
+	return &Plan{
+		Boosts: map[string]PlannedBoost{},
+	}
+}
+
+// SetPlannedBoosts replace the boosts of the plan with those intended on the last loop, updating the metrics.
+// Changes are logged
+func SetPlannedBoosts(ctx *Ctx, plannedBoosts map[string]PlannedBoost) {
+
+	ctx.Plan.Lock.Lock()
+	changed := !reflect.DeepEqual(ctx.Plan.Boosts, plannedBoosts)
+	ctx.Plan.Boosts = plannedBoosts
+	ctx.Plan.BoostsUpdatedAt = time.Now()
+	ctx.Plan.Lock.Unlock()
+
+	mPlanDesiredCapacity.Reset()
+	for asgName, plannedBoost := range plannedBoosts {
+		mPlanDesiredCapacity.WithLabelValues(asgName, "current").Set(float64(plannedBoost.Current))
+		mPlanDesiredCapacity.WithLabelValues(asgName, "intended").Set(float64(plannedBoost.Intended))
+	}
+
+	if !changed {
+		return
+	}
+
+	asgNames := maps.Keys(plannedBoosts)
+	sort.Strings(asgNames)
+	for _, asgName := range asgNames {
+		plannedBoost := plannedBoosts[asgName]
+		if plannedBoost.Intended > plannedBoost.Current {
+			ctx.Logger.Infof(PlannedBoostMessage, asgName, plannedBoost.Current, plannedBoost.Intended)
+			continue
+		}
+		ctx.Logger.Infof(PlannedBoostSkipMessage, asgName, plannedBoost.Current, plannedBoost.Reason)
+	}
+}
+
+// copyPoolsForPlanning return a copy of the pools that can be modified to plan the following drain batches
+func copyPoolsForPlanning(eventPool *EventPool, nodePool *NodePool) (*EventPool, *NodePool) {
+
+	eventPoolCopy := NewEventPool()
+	for _, event := range eventPool.Snapshot() {
+		eventPoolCopy.Upsert(event)
+	}
+
+	nodePoolCopy := NewNodePool()
+	for _, node := range nodePool.Snapshot() {
+		nodePoolCopy.Upsert(node)
+	}
+
+	return eventPoolCopy, nodePoolCopy
+}
+
+// PlanDrains calculate the batches of nodes that would be drained, and the instances that would be terminated,
+// replacing the drains of the plan. Each batch is selected as the drain process does, assuming the previous ones
+// are already done: their events are gone and their replacement nodes are already used
+func PlanDrains(ctx *Ctx, eventPool *EventPool, nodePool *NodePool, autoscalingGroupPool *AutoscalingGroupPool, terminationQueue *TerminationQueue) {
+
+	plannedEventPool, plannedNodePool := copyPoolsForPlanning(eventPool, nodePool)
+
+	var plannedDrains []PlannedDrain
+	for batch := 1; batch <= PlanMaxDrainBatches; batch++ {
+
+		aggregate := NewNodegroupsAggregate(plannedEventPool, plannedNodePool, *ctx.Flags.MaxTimeConsiderNewNodes, true)
+		drainCandidates := SelectDrainCandidates(ctx, aggregate, plannedNodePool, terminationQueue)
+		if len(drainCandidates) == 0 {
+			break
+		}
+
+		drainedNodes := map[string]bool{}
+		replacementNodes := map[string]bool{}
+
+		nodegroupNames := maps.Keys(drainCandidates)
+		sort.Strings(nodegroupNames)
+
+		for _, nodegroupName := range nodegroupNames {
+			for _, drainCandidate := range drainCandidates[nodegroupName] {
+				terminationRequest := NewTerminationRequest(plannedNodePool, autoscalingGroupPool, drainCandidate.Event)
+
+				plannedDrains = append(plannedDrains, PlannedDrain{
+					Batch:            batch,
+					Nodegroup:        nodegroupName,
+					AutoscalingGroup: terminationRequest.AutoscalingGroupName,
+					Node:             terminationRequest.NodeName,
+					Instance:         terminationRequest.InstanceId,
+					ReplacementNode:  drainCandidate.ReplacementNode.Name,
+				})
+
+				drainedNodes[terminationRequest.NodeName] = true
+				replacementNodes[drainCandidate.ReplacementNode.Name] = true
+			}
+		}
+
+		// Assume this batch is done for the following ones
+		for _, event := range plannedEventPool.Snapshot() {
+			if drainedNodes[event.InvolvedObject.Name] {
+				plannedEventPool.Delete(event)
+			}
+		}
+
+		for replacementNodeName := range replacementNodes {
+			storedNode, found := plannedNodePool.Get(replacementNodeName)
+			if !found {
+				continue
+			}
+
+			node := storedNode.DeepCopy()
+			if node.Annotations == nil {
+				node.Annotations = map[string]string{}
+			}
+			node.Annotations[IgnoreRecentReadyNodeAnnotation] = IgnoreRecentReadyNodeAnnotationValue
+			plannedNodePool.Upsert(node)
+		}
+	}
+
+	ctx.Plan.Lock.Lock()
+	changed := !reflect.DeepEqual(ctx.Plan.Drains, plannedDrains)
+	ctx.Plan.Drains = plannedDrains
+	ctx.Plan.DrainsUpdatedAt = time.Now()
+	ctx.Plan.Lock.Unlock()
+
+	mPlanDrains.Reset()
+	for _, plannedDrain := range plannedDrains {
+		mPlanDrains.WithLabelValues(plannedDrain.Nodegroup).Inc()
+	}
+	if len(plannedDrains) > 0 {
+		mPlanDrainBatches.Set(float64(plannedDrains[len(plannedDrains)-1].Batch))
+	} else {
+		mPlanDrainBatches.Set(0)
+	}
+
+	if !changed {
+		return
+	}
+
+	for _, plannedDrain := range plannedDrains {
+		ctx.Logger.Infof(PlannedDrainMessage, plannedDrain.Batch, plannedDrain.Node, plannedDrain.Nodegroup, plannedDrain.ReplacementNode)
+		if plannedDrain.Instance != "" {
+			ctx.Logger.Infof(PlannedTerminationMessage, plannedDrain.Instance, plannedDrain.Node)
+		}
+	}
+}
+
+// PlanHandler return an HTTP handler exposing the dry-run plan as JSON
+// Usage: GET /plan
+func PlanHandler(ctx *Ctx) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodGet {
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		ctx.Plan.Lock.Lock()
+		content, err := json.Marshal(ctx.Plan)
+		ctx.Plan.Lock.Unlock()
+
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		writer.Header().Set("Content-Type", "application/json")
+		_, _ = writer.Write(content)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+	"time"
+)
+
+// newPlanTestNode return a node of nodegroup 'spot', ready for some time
+func newPlanTestNode(name string, readyFor time.Duration) *v1.Node {
+	readySince := metav1.NewTime(time.Now().Add(-readyFor))
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			CreationTimestamp: readySince,
+			Labels:            map[string]string{AWSNodeGroupLabel: "spot"},
+		},
+		Spec: v1.NodeSpec{ProviderID: "aws:///eu-west-1a/i-" + name},
+		Status: v1.NodeStatus{Conditions: []v1.NodeCondition{{
+			Type:               v1.NodeReady,
+			Status:             v1.ConditionTrue,
+			LastTransitionTime: readySince,
+		}}},
+	}
+}
+
+func TestPlanDrainsStopsAtMaxBatches(t *testing.T) {
+	h := NewHarness(t, "--dry-run", "--max-concurrent-drains", "1")
+
+	// One more node under risk, and replacement for it, than the batches planned ahead
+	eventPool := NewEventPool()
+	nodePool := NewNodePool()
+	for i := 0; i <= PlanMaxDrainBatches; i++ {
+		nodeName := fmt.Sprintf("node-%d", i)
+		nodePool.Upsert(newPlanTestNode(nodeName, time.Hour))
+		nodePool.Upsert(newPlanTestNode(fmt.Sprintf("node-new-%d", i), time.Minute+time.Duration(i)*time.Second))
+		eventPool.Upsert(&v1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: "rebalance-" + nodeName, Namespace: "default"},
+			InvolvedObject: v1.ObjectReference{Name: nodeName},
+		})
+	}
+
+	PlanDrains(h.Ctx, eventPool, nodePool, NewAutoscalingGroupPool(), &TerminationQueue{})
+
+	drains := h.Ctx.Plan.Drains
+	if len(drains) != PlanMaxDrainBatches || drains[len(drains)-1].Batch != PlanMaxDrainBatches {
+		t.Fatalf("expected %d planned drains, one per batch, got %d", PlanMaxDrainBatches, len(drains))
+	}
+
+	// The pools given are not modified by the planning
+	if eventPool.Len() != PlanMaxDrainBatches+1 {
+		t.Errorf("expected the events to be kept on the pool, got %d", eventPool.Len())
+	}
+	if node, _ := nodePool.Get(drains[0].ReplacementNode); node.Annotations[IgnoreRecentReadyNodeAnnotation] != "" {
+		t.Errorf("replacement '%s' was annotated on the pool", drains[0].ReplacementNode)
+	}
+}
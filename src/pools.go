@@ -0,0 +1,595 @@
+package main
+
+import (
+	"golang.org/x/exp/maps"
+	v1 "k8s.io/api/core/v1"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Pools represent stores of different types, that are written by the watchers and read by the rest of goroutines.
+// Stored objects are never modified in place, but replaced. So the objects returned by the readers are shared
+// snapshots that can be used without holding any lock, and MUST NOT be modified: deep copy them before doing it.
+// A handler can be registered on each pool to be notified when relevant data change, to trigger the reconciliation
+
+// NodePool represents the nodes stored from Kubernetes, indexed by name, instance and nodegroup.
+// The indices are maintained incrementally on each change, so the readers never scan the whole pool
+type NodePool struct {
+	lock sync.RWMutex
+
+	nodes        map[string]*v1.Node
+	byInstanceId map[string]*v1.Node
+	byNodegroup  map[string]map[string]*v1.Node
+
+	// Cordoned nodes, and schedulable nodes with their last Ready transition, by nodegroup
+	cordoned map[string]map[string]*v1.Node
+	ready    map[string]map[string]readyNode
+
+	// Nodes carrying the soft-cordon taint, by name
+	softCordoned map[string]*v1.Node
+
+	onChange func()
+}
+
+// readyNode represents a schedulable node, together with the last transition of its Ready condition
+type readyNode struct {
+	node           *v1.Node
+	lastTransition time.Time
+}
+
+// NewNodePool return an empty pool of nodes ready to be used
+func NewNodePool() *NodePool {
+	nodePool := &NodePool{}
+	nodePool.Reset()
+
+	return nodePool
+}
+
+// OnChange register a handler called when the nodes change in a way that matters for the calculations
+func (p *NodePool) OnChange(handler func()) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.onChange = handler
+}
+
+// notifyChange call the registered handler, if any. Lock must not be held
+func notifyChange(handler func()) {
+	if handler != nil {
+		handler()
+	}
+}
+
+// isNodeChangeRelevant return true when the data of a node used by the calculations changed.
+// Heartbeats and other status updates are ignored, as they happen constantly on big clusters
+func isNodeChangeRelevant(previous *v1.Node, current *v1.Node) bool {
+
+	getReadyTransition := func(node *v1.Node) (lastTransition time.Time) {
+		for _, condition := range node.Status.Conditions {
+			if condition.Type == v1.NodeReady {
+				lastTransition = condition.LastTransitionTime.Time
+			}
+		}
+		return lastTransition
+	}
+
+	return previous.Labels[AWSNodeGroupLabel] != current.Labels[AWSNodeGroupLabel] ||
+		previous.Spec.Unschedulable != current.Spec.Unschedulable ||
+		previous.Spec.ProviderID != current.Spec.ProviderID ||
+		!getReadyTransition(previous).Equal(getReadyTransition(current)) ||
+		!reflect.DeepEqual(previous.Annotations, current.Annotations)
+}
+
+// Reset delete all the nodes of the pool
+func (p *NodePool) Reset() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.nodes = map[string]*v1.Node{}
+	p.byInstanceId = map[string]*v1.Node{}
+	p.byNodegroup = map[string]map[string]*v1.Node{}
+	p.cordoned = map[string]map[string]*v1.Node{}
+	p.ready = map[string]map[string]readyNode{}
+	p.softCordoned = map[string]*v1.Node{}
+}
+
+// addToIndex store a value into a two-levels index, creating the inner map when needed
+func addToIndex[V any](index map[string]map[string]V, key string, name string, value V) {
+	if index[key] == nil {
+		index[key] = map[string]V{}
+	}
+	index[key][name] = value
+}
+
+// deleteFromIndex remove a value from a two-levels index, deleting the inner map when it is empty
+func deleteFromIndex[V any](index map[string]map[string]V, key string, name string) {
+	delete(index[key], name)
+	if len(index[key]) == 0 {
+		delete(index, key)
+	}
+}
+
+// Upsert store a copy of a node, replacing the previous version of it
+func (p *NodePool) Upsert(node *v1.Node) {
+	p.lock.Lock()
+	previous, found := p.nodes[node.Name]
+	p.upsertLocked(node)
+	onChange := p.onChange
+	p.lock.Unlock()
+
+	if !found || isNodeChangeRelevant(previous, node) {
+		notifyChange(onChange)
+	}
+}
+
+// upsertLocked store a copy of a node into the pool and its indices. Lock must be held
+func (p *NodePool) upsertLocked(node *v1.Node) {
+
+	p.deleteLocked(node.Name)
+
+	nodeCopy := node.DeepCopy()
+	p.nodes[nodeCopy.Name] = nodeCopy
+
+	if instanceId := GetInstanceIdFromProviderID(nodeCopy.Spec.ProviderID); instanceId != "" {
+		p.byInstanceId[instanceId] = nodeCopy
+	}
+
+	for _, taint := range nodeCopy.Spec.Taints {
+		if taint.Key == SoftCordonTaintKey {
+			p.softCordoned[nodeCopy.Name] = nodeCopy
+		}
+	}
+
+	nodegroupName, found := nodeCopy.Labels[AWSNodeGroupLabel]
+	if !found {
+		return
+	}
+	addToIndex(p.byNodegroup, nodegroupName, nodeCopy.Name, nodeCopy)
+
+	if nodeCopy.Spec.Unschedulable {
+		addToIndex(p.cordoned, nodegroupName, nodeCopy.Name, nodeCopy)
+		return
+	}
+
+	// Look into the conditions for last Ready transition
+	for _, condition := range nodeCopy.Status.Conditions {
+		if condition.Type == v1.NodeReady {
+			addToIndex(p.ready, nodegroupName, nodeCopy.Name, readyNode{
+				node:           nodeCopy,
+				lastTransition: condition.LastTransitionTime.Time,
+			})
+		}
+	}
+}
+
+// Delete remove a node from the pool
+func (p *NodePool) Delete(nodeName string) {
+	p.lock.Lock()
+	_, found := p.nodes[nodeName]
+	p.deleteLocked(nodeName)
+	onChange := p.onChange
+	p.lock.Unlock()
+
+	if found {
+		notifyChange(onChange)
+	}
+}
+
+// deleteLocked remove a node from the pool and its indices. Lock must be held
+func (p *NodePool) deleteLocked(nodeName string) {
+	node, found := p.nodes[nodeName]
+	if !found {
+		return
+	}
+	delete(p.nodes, nodeName)
+	delete(p.byInstanceId, GetInstanceIdFromProviderID(node.Spec.ProviderID))
+	delete(p.softCordoned, nodeName)
+
+	nodegroupName := node.Labels[AWSNodeGroupLabel]
+	deleteFromIndex(p.byNodegroup, nodegroupName, nodeName)
+	deleteFromIndex(p.cordoned, nodegroupName, nodeName)
+	deleteFromIndex(p.ready, nodegroupName, nodeName)
+}
+
+// Get return a node by its name
+func (p *NodePool) Get(nodeName string) (node *v1.Node, found bool) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	node, found = p.nodes[nodeName]
+	return node, found
+}
+
+// GetByInstanceId return a node by the ID of its instance
+func (p *NodePool) GetByInstanceId(instanceId string) (node *v1.Node, found bool) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	node, found = p.byInstanceId[instanceId]
+	return node, found
+}
+
+// GetNodegroup return the nodegroup of a node, or an empty string when the node is unknown or has no nodegroup
+func (p *NodePool) GetNodegroup(nodeName string) string {
+	node, found := p.Get(nodeName)
+	if !found {
+		return ""
+	}
+	return node.Labels[AWSNodeGroupLabel]
+}
+
+// Len return the number of nodes in the pool
+func (p *NodePool) Len() int {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return len(p.nodes)
+}
+
+// Snapshot return all the nodes of the pool, sorted by name
+func (p *NodePool) Snapshot() []*v1.Node {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return sortedNodes(p.nodes)
+}
+
+// SoftCordonedSnapshot return the nodes carrying the soft-cordon taint, sorted by name
+func (p *NodePool) SoftCordonedSnapshot() []*v1.Node {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return sortedNodes(p.softCordoned)
+}
+
+// Nodegroups return the names of the nodegroups with nodes in the pool, sorted
+func (p *NodePool) Nodegroups() []string {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	nodegroupNames := make([]string, 0, len(p.byNodegroup))
+	for nodegroupName := range p.byNodegroup {
+		nodegroupNames = append(nodegroupNames, nodegroupName)
+	}
+	sort.Strings(nodegroupNames)
+
+	return nodegroupNames
+}
+
+// NodegroupSnapshot return the nodes of a nodegroup, sorted by name
+func (p *NodePool) NodegroupSnapshot(nodegroupName string) []*v1.Node {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return sortedNodes(p.byNodegroup[nodegroupName])
+}
+
+// CountByNodegroup return the number of nodes of each nodegroup
+func (p *NodePool) CountByNodegroup() map[string]int {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	nodesCount := make(map[string]int, len(p.byNodegroup))
+	for nodegroupName, nodes := range p.byNodegroup {
+		nodesCount[nodegroupName] = len(nodes)
+	}
+
+	return nodesCount
+}
+
+// CordonedCountByNodegroup return the number of cordoned nodes of each nodegroup
+func (p *NodePool) CordonedCountByNodegroup() map[string]int {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	cordonedCount := make(map[string]int, len(p.byNodegroup))
+	for nodegroupName := range p.byNodegroup {
+		cordonedCount[nodegroupName] = len(p.cordoned[nodegroupName])
+	}
+
+	return cordonedCount
+}
+
+// RecentlyReadyByNodegroup return the schedulable nodes of each nodegroup whose Ready condition changed after a moment,
+// sorted by name. Nodes annotated with IgnoreRecentReadyNodeAnnotation can be ignored
+func (p *NodePool) RecentlyReadyByNodegroup(since time.Time, ignoreAnnotated bool) map[string][]*v1.Node {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	recentlyReadyNodes := make(map[string][]*v1.Node, len(p.byNodegroup))
+	for nodegroupName := range p.byNodegroup {
+		recentlyReadyNodes[nodegroupName] = []*v1.Node{}
+
+		for _, readyNode := range p.ready[nodegroupName] {
+			if _, annotationFound := readyNode.node.Annotations[IgnoreRecentReadyNodeAnnotation]; ignoreAnnotated && annotationFound {
+				continue
+			}
+			if readyNode.lastTransition.After(since) {
+				recentlyReadyNodes[nodegroupName] = append(recentlyReadyNodes[nodegroupName], readyNode.node)
+			}
+		}
+		sort.Slice(recentlyReadyNodes[nodegroupName], func(i, j int) bool {
+			return recentlyReadyNodes[nodegroupName][i].Name < recentlyReadyNodes[nodegroupName][j].Name
+		})
+	}
+
+	return recentlyReadyNodes
+}
+
+// sortedNodes return the nodes of a map sorted by name
+func sortedNodes(nodes map[string]*v1.Node) []*v1.Node {
+	sortedNodes := make([]*v1.Node, 0, len(nodes))
+	for _, node := range nodes {
+		sortedNodes = append(sortedNodes, node)
+	}
+	sort.Slice(sortedNodes, func(i, j int) bool { return sortedNodes[i].Name < sortedNodes[j].Name })
+
+	return sortedNodes
+}
+
+// EventPool represents the events stored from Kubernetes to handle API server events' TTL.
+// Only the newest event of each node is kept, indexed by the node's name
+type EventPool struct {
+	lock sync.RWMutex
+
+	events map[string]*v1.Event
+
+	onChange func()
+}
+
+// NewEventPool return an empty pool of events ready to be used
+func NewEventPool() *EventPool {
+	return &EventPool{
+		events: map[string]*v1.Event{},
+	}
+}
+
+// OnChange register a handler called when the events change
+func (p *EventPool) OnChange(handler func()) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.onChange = handler
+}
+
+// Reset delete all the events of the pool
+func (p *EventPool) Reset() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.events = map[string]*v1.Event{}
+}
+
+// Upsert store a copy of an event, replacing the previous one of the same node
+func (p *EventPool) Upsert(event *v1.Event) {
+	p.lock.Lock()
+	p.events[event.InvolvedObject.Name] = event.DeepCopy()
+	onChange := p.onChange
+	p.lock.Unlock()
+
+	notifyChange(onChange)
+}
+
+// Delete remove an event from the pool. Nothing is done when the event was already replaced by a newer one
+func (p *EventPool) Delete(event *v1.Event) {
+	p.lock.Lock()
+	storedEvent, found := p.events[event.InvolvedObject.Name]
+	if !found || storedEvent.Namespace != event.Namespace || storedEvent.Name != event.Name {
+		p.lock.Unlock()
+		return
+	}
+	delete(p.events, event.InvolvedObject.Name)
+	onChange := p.onChange
+	p.lock.Unlock()
+
+	notifyChange(onChange)
+}
+
+// Get return the event of a node
+func (p *EventPool) Get(nodeName string) (event *v1.Event, found bool) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	event, found = p.events[nodeName]
+	return event, found
+}
+
+// Len return the number of events in the pool
+func (p *EventPool) Len() int {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return len(p.events)
+}
+
+// Snapshot return all the events of the pool, the oldest first
+func (p *EventPool) Snapshot() []*v1.Event {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	events := make([]*v1.Event, 0, len(p.events))
+	for _, event := range p.events {
+		events = append(events, event)
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].CreationTimestamp.Equal(&events[j].CreationTimestamp) {
+			return events[i].InvolvedObject.Name < events[j].InvolvedObject.Name
+		}
+		return events[i].CreationTimestamp.Before(&events[j].CreationTimestamp)
+	})
+
+	return events
+}
+
+// AutoscalingGroupPool represents the autoscaling groups known by Cluster Autoscaler, indexed by name and nodegroup
+type AutoscalingGroupPool struct {
+	lock sync.RWMutex
+
+	autoscalingGroups map[string]*AutoscalingGroup
+	byNodegroup       map[string]*AutoscalingGroup
+
+	// names keeps the order of the ASGs on Cluster Autoscaler's status
+	names []string
+
+	// status is the raw Cluster Autoscaler's status the ASGs were parsed from
+	status string
+
+	onChange func()
+}
+
+// NewAutoscalingGroupPool return an empty pool of autoscaling groups ready to be used
+func NewAutoscalingGroupPool() *AutoscalingGroupPool {
+	return &AutoscalingGroupPool{
+		autoscalingGroups: map[string]*AutoscalingGroup{},
+		byNodegroup:       map[string]*AutoscalingGroup{},
+	}
+}
+
+// OnChange register a handler called when the ASGs change: their health, tags or topology
+func (p *AutoscalingGroupPool) OnChange(handler func()) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.onChange = handler
+}
+
+// update replace the ASGs of the pool with modified copies of them, rebuilding the indices.
+// Return whether some ASG changed
+func (p *AutoscalingGroupPool) update(modify func(autoscalingGroup *AutoscalingGroup)) (changed bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.byNodegroup = map[string]*AutoscalingGroup{}
+	for _, name := range p.names {
+		autoscalingGroupCopy := *p.autoscalingGroups[name]
+		modify(&autoscalingGroupCopy)
+
+		if !reflect.DeepEqual(autoscalingGroupCopy, *p.autoscalingGroups[name]) {
+			changed = true
+			p.autoscalingGroups[name] = &autoscalingGroupCopy
+		}
+		if nodegroupName := p.autoscalingGroups[name].Tags[AWSAutoscalingGroupsNodeGroupTag]; nodegroupName != "" {
+			p.byNodegroup[nodegroupName] = p.autoscalingGroups[name]
+		}
+	}
+
+	return changed
+}
+
+// notifyUpdate call the registered handler when an update changed some ASG
+func (p *AutoscalingGroupPool) notifyUpdate(changed bool) {
+	p.lock.RLock()
+	onChange := p.onChange
+	p.lock.RUnlock()
+
+	if changed {
+		notifyChange(onChange)
+	}
+}
+
+// SetStatus store the ASGs parsed from a Cluster Autoscaler's status, together with the raw status.
+// The health of the known ASGs is updated keeping the rest of their data, new ones are added and missing ones removed
+func (p *AutoscalingGroupPool) SetStatus(status string, autoscalingGroups AutoscalingGroups) {
+	p.lock.Lock()
+	p.status = status
+
+	previousAutoscalingGroups := p.autoscalingGroups
+	previousNames := p.names
+	p.autoscalingGroups = map[string]*AutoscalingGroup{}
+	p.names = nil
+
+	for _, autoscalingGroup := range autoscalingGroups {
+		storedAutoscalingGroup := *autoscalingGroup
+		if previousAutoscalingGroup, found := previousAutoscalingGroups[autoscalingGroup.Name]; found {
+			storedAutoscalingGroup = *previousAutoscalingGroup
+			storedAutoscalingGroup.Health = autoscalingGroup.Health
+		}
+
+		p.autoscalingGroups[autoscalingGroup.Name] = &storedAutoscalingGroup
+		p.names = append(p.names, autoscalingGroup.Name)
+	}
+
+	// The raw status changes on each update of Cluster Autoscaler, so only the parsed ASGs are compared
+	changed := !reflect.DeepEqual(previousNames, p.names)
+	for name, autoscalingGroup := range p.autoscalingGroups {
+		if previousAutoscalingGroup, found := previousAutoscalingGroups[name]; !found || previousAutoscalingGroup.Health != autoscalingGroup.Health {
+			changed = true
+		}
+	}
+	p.lock.Unlock()
+
+	// Rebuild the indices
+	p.update(func(*AutoscalingGroup) {})
+	p.notifyUpdate(changed)
+}
+
+// SetTags replace the tags of the ASGs, grouped by the name of the ASGs.
+// Tags are only replaced when they differ, and ASGs not present keep their tags
+func (p *AutoscalingGroupPool) SetTags(tags map[string]map[string]string) {
+	p.notifyUpdate(p.update(func(autoscalingGroup *AutoscalingGroup) {
+		autoscalingGroupTags, found := tags[autoscalingGroup.Name]
+		if found && !maps.Equal(autoscalingGroup.Tags, autoscalingGroupTags) {
+			autoscalingGroup.Tags = autoscalingGroupTags
+		}
+	}))
+}
+
+// SetTopologies replace the topologies of the ASGs. Those without topology are classified as unknown
+func (p *AutoscalingGroupPool) SetTopologies(topologies map[string]AutoscalingGroupTopology) {
+	p.notifyUpdate(p.update(func(autoscalingGroup *AutoscalingGroup) {
+		topology, found := topologies[autoscalingGroup.Name]
+		if !found {
+			topology.Classification = TopologyUnknown
+		}
+		autoscalingGroup.Topology = topology
+	}))
+}
+
+// Status return the raw Cluster Autoscaler's status the ASGs were parsed from
+func (p *AutoscalingGroupPool) Status() string {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.status
+}
+
+// Get return an ASG by its name
+func (p *AutoscalingGroupPool) Get(autoscalingGroupName string) (autoscalingGroup *AutoscalingGroup, found bool) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	autoscalingGroup, found = p.autoscalingGroups[autoscalingGroupName]
+	return autoscalingGroup, found
+}
+
+// GetByNodegroup return the ASG backing a nodegroup, looking at its tags
+func (p *AutoscalingGroupPool) GetByNodegroup(nodegroupName string) (autoscalingGroup *AutoscalingGroup, found bool) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	autoscalingGroup, found = p.byNodegroup[nodegroupName]
+	return autoscalingGroup, found
+}
+
+// Len return the number of ASGs in the pool
+func (p *AutoscalingGroupPool) Len() int {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return len(p.names)
+}
+
+// Snapshot return all the ASGs of the pool, in the same order they are in Cluster Autoscaler's status
+func (p *AutoscalingGroupPool) Snapshot() AutoscalingGroups {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	autoscalingGroups := make(AutoscalingGroups, 0, len(p.names))
+	for _, name := range p.names {
+		autoscalingGroups = append(autoscalingGroups, p.autoscalingGroups[name])
+	}
+
+	return autoscalingGroups
+}
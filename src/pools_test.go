@@ -0,0 +1,211 @@
+package main
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+	"time"
+)
+
+// newPoolTestNode return a node labeled with its nodegroup
+func newPoolTestNode(name string, nodegroupName string) *v1.Node {
+	return &v1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:   name,
+		Labels: map[string]string{AWSNodeGroupLabel: nodegroupName},
+	}}
+}
+
+func TestNodePoolNodegroupIndex(t *testing.T) {
+	nodePool := NewNodePool()
+	nodePool.Upsert(newPoolTestNode("node-1", "spot"))
+	nodePool.Upsert(newPoolTestNode("node-2", "spot"))
+
+	// Moving a node to another nodegroup removes it from the previous one
+	nodePool.Upsert(newPoolTestNode("node-2", "on-demand"))
+
+	nodesCount := GetNodeCountByNodeGroup(nodePool)
+	if nodesCount["spot"] != 1 || nodesCount["on-demand"] != 1 {
+		t.Fatalf("unexpected nodes by nodegroup: %v", nodesCount)
+	}
+
+	// Stored nodes are copies, so they are not changed by the writers
+	node := newPoolTestNode("node-3", "spot")
+	nodePool.Upsert(node)
+	node.Labels[AWSNodeGroupLabel] = "changed"
+	if nodegroupName := nodePool.GetNodegroup("node-3"); nodegroupName != "spot" {
+		t.Errorf("stored node was modified from outside of the pool, got nodegroup '%s'", nodegroupName)
+	}
+
+	nodePool.Delete("node-1")
+	nodePool.Delete("node-2")
+	if nodegroupNames := nodePool.Nodegroups(); len(nodegroupNames) != 1 || nodegroupNames[0] != "spot" {
+		t.Errorf("expected only nodegroup 'spot' to be left, got %v", nodegroupNames)
+	}
+}
+
+func TestEventPoolKeepsNewestEventPerNode(t *testing.T) {
+	newEvent := func(name string, nodeName string) *v1.Event {
+		return &v1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: name, Namespace: "default"},
+			InvolvedObject: v1.ObjectReference{Name: nodeName},
+		}
+	}
+
+	nodePool := NewNodePool()
+	nodePool.Upsert(newPoolTestNode("node-1", "spot"))
+
+	eventPool := NewEventPool()
+	eventPool.Upsert(newEvent("event-1", "node-1"))
+	eventPool.Upsert(newEvent("event-2", "node-1"))
+
+	if count := NewNodegroupsAggregate(eventPool, nodePool, 0, false).EventsCount["spot"]; count != 1 {
+		t.Fatalf("expected 1 event for nodegroup 'spot', got %d", count)
+	}
+
+	// Deleting the replaced event keeps the newest one
+	eventPool.Delete(newEvent("event-1", "node-1"))
+	if event, found := eventPool.Get("node-1"); !found || event.Name != "event-2" {
+		t.Fatalf("expected event-2 to be kept for node-1")
+	}
+
+	eventPool.Delete(newEvent("event-2", "node-1"))
+	if eventPool.Len() != 0 {
+		t.Errorf("expected the event pool to be empty, got %d events", eventPool.Len())
+	}
+}
+
+func TestAutoscalingGroupPoolKeepsDataOnStatusUpdates(t *testing.T) {
+	autoscalingGroupPool := NewAutoscalingGroupPool()
+	autoscalingGroupPool.SetStatus("first", AutoscalingGroups{{Name: "eks-spot"}})
+	autoscalingGroupPool.SetTags(map[string]map[string]string{
+		"eks-spot": {AWSAutoscalingGroupsNodeGroupTag: "spot"},
+	})
+
+	previous, _ := autoscalingGroupPool.Get("eks-spot")
+
+	autoscalingGroupPool.SetStatus("second", AutoscalingGroups{
+		{Name: "eks-spot", Health: HealthStatus{Ready: "3"}},
+		{Name: "eks-new"},
+	})
+
+	autoscalingGroup, found := autoscalingGroupPool.GetByNodegroup("spot")
+	if !found || autoscalingGroup.Health.Ready != "3" {
+		t.Fatalf("expected the tags to be kept and the health to be updated, got %+v", autoscalingGroup)
+	}
+	if previous.Health.Ready != "" {
+		t.Errorf("a previous snapshot of the asg was modified in place")
+	}
+	if names := GetAutoscalingGroupsNames(autoscalingGroupPool); len(names) != 2 || names[0] != "eks-spot" || names[1] != "eks-new" {
+		t.Errorf("expected asgs in status order, got %v", names)
+	}
+	if status := autoscalingGroupPool.Status(); status != "second" {
+		t.Errorf("expected raw status 'second', got '%s'", status)
+	}
+}
+
+func TestNodePoolCordonedAndRecentlyReadyIndices(t *testing.T) {
+	newReadyNode := func(name string, readyFor time.Duration) *v1.Node {
+		node := newPoolTestNode(name, "spot")
+		node.Spec.ProviderID = "aws:///eu-west-1a/i-" + name
+		node.Status.Conditions = []v1.NodeCondition{{
+			Type:               v1.NodeReady,
+			Status:             v1.ConditionTrue,
+			LastTransitionTime: metav1.NewTime(time.Now().Add(-readyFor)),
+		}}
+		return node
+	}
+
+	nodePool := NewNodePool()
+	nodePool.Upsert(newReadyNode("old", time.Hour))
+	nodePool.Upsert(newReadyNode("new", time.Minute))
+
+	annotated := newReadyNode("annotated", time.Minute)
+	annotated.Annotations = map[string]string{IgnoreRecentReadyNodeAnnotation: IgnoreRecentReadyNodeAnnotationValue}
+	nodePool.Upsert(annotated)
+
+	aggregate := NewNodegroupsAggregate(NewEventPool(), nodePool, -10*time.Minute, true)
+	if recentlyReady := aggregate.RecentlyReadyNodes["spot"]; len(recentlyReady) != 1 || recentlyReady[0].Name != "new" {
+		t.Fatalf("expected only node 'new' to be recently ready, got %v", recentlyReady)
+	}
+
+	// Cordoned nodes are not recently ready anymore
+	cordoned := newReadyNode("new", time.Minute)
+	cordoned.Spec.Unschedulable = true
+	nodePool.Upsert(cordoned)
+
+	aggregate = NewNodegroupsAggregate(NewEventPool(), nodePool, -10*time.Minute, false)
+	if aggregate.CordonedNodesCount["spot"] != 1 || aggregate.RecentlyReadyCount["spot"] != 1 {
+		t.Errorf("expected 1 cordoned and 1 recently ready node, got %d and %d",
+			aggregate.CordonedNodesCount["spot"], aggregate.RecentlyReadyCount["spot"])
+	}
+
+	if node, found := nodePool.GetByInstanceId("i-old"); !found || node.Name != "old" {
+		t.Errorf("expected node 'old' to be found by its instance")
+	}
+	nodePool.Delete("old")
+	if _, found := nodePool.GetByInstanceId("i-old"); found {
+		t.Errorf("deleted node 'old' is still found by its instance")
+	}
+}
+
+func TestAutoscalingGroupPoolOnlyChangesDifferentTags(t *testing.T) {
+	autoscalingGroupPool := NewAutoscalingGroupPool()
+	autoscalingGroupPool.SetStatus("status", AutoscalingGroups{{Name: "eks-spot"}, {Name: "eks-on-demand"}})
+	autoscalingGroupPool.SetTags(map[string]map[string]string{
+		"eks-spot":      {AWSAutoscalingGroupsNodeGroupTag: "spot"},
+		"eks-on-demand": {AWSAutoscalingGroupsNodeGroupTag: "on-demand"},
+	})
+
+	changes := 0
+	autoscalingGroupPool.OnChange(func() { changes++ })
+
+	// Same tags in new maps, and ASGs not present keep their tags
+	autoscalingGroupPool.SetTags(map[string]map[string]string{
+		"eks-spot": {AWSAutoscalingGroupsNodeGroupTag: "spot"},
+	})
+	if changes != 0 {
+		t.Errorf("expected no changes for the same tags, got %d", changes)
+	}
+	if _, found := autoscalingGroupPool.GetByNodegroup("on-demand"); !found {
+		t.Errorf("tags of asg 'eks-on-demand' were lost")
+	}
+
+	autoscalingGroupPool.SetTags(map[string]map[string]string{
+		"eks-spot": {AWSAutoscalingGroupsNodeGroupTag: "spot", PausedTag: PausedTagValue},
+	})
+	if changes != 1 {
+		t.Errorf("expected 1 change for different tags, got %d", changes)
+	}
+}
+
+func TestNodePoolSoftCordonedIndex(t *testing.T) {
+	nodePool := NewNodePool()
+
+	node := newPoolTestNode("node-1", "spot")
+	nodePool.Upsert(node)
+	nodePool.Upsert(newPoolTestNode("node-2", "spot"))
+
+	if nodes := nodePool.SoftCordonedSnapshot(); len(nodes) != 0 {
+		t.Fatalf("expected no soft-cordoned nodes, got %d", len(nodes))
+	}
+
+	node.Spec.Taints = []v1.Taint{{Key: SoftCordonTaintKey, Value: SoftCordonTaintValue, Effect: v1.TaintEffectPreferNoSchedule}}
+	nodePool.Upsert(node)
+	if nodes := nodePool.SoftCordonedSnapshot(); len(nodes) != 1 || nodes[0].Name != "node-1" {
+		t.Fatalf("expected node-1 to be soft-cordoned, got %v", nodes)
+	}
+
+	// Removing the taint or the node removes it from the index
+	node.Spec.Taints = nil
+	nodePool.Upsert(node)
+	if nodes := nodePool.SoftCordonedSnapshot(); len(nodes) != 0 {
+		t.Errorf("expected no soft-cordoned nodes once the taint is removed, got %d", len(nodes))
+	}
+
+	node.Spec.Taints = []v1.Taint{{Key: SoftCordonTaintKey, Value: SoftCordonTaintValue, Effect: v1.TaintEffectNoSchedule}}
+	nodePool.Upsert(node)
+	nodePool.Delete("node-1")
+	if nodes := nodePool.SoftCordonedSnapshot(); len(nodes) != 0 {
+		t.Errorf("expected no soft-cordoned nodes once the node is deleted, got %d", len(nodes))
+	}
+}
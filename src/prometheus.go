@@ -3,15 +3,26 @@ package main
 import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"sync"
+	"time"
 )
 
 const (
 
 	// MetricsPrefix
 	MetricsPrefix = "aws_spots_booster_"
+
+	// Results of the drains
+	DrainResultStarted   = "started"
+	DrainResultSucceeded = "succeeded"
+	DrainResultFailed    = "failed"
+	DrainResultTimedOut  = "timed-out"
+
+	// Names of the loops whose latency is measured
+	SynchronizationLoopName = "synchronization"
+	DrainLoopName           = "drain"
 )
 
-// TODO UPDATE METRICS FOR THIS CONTROLLER
 var (
 	mNodegroupEventsTotal = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Name: MetricsPrefix + "events_total",
@@ -30,34 +41,272 @@ var (
 
 	mNodegroupRecentlyReadyNodesTotal = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Name: MetricsPrefix + "recently_ready_nodes_total",
-		Help: "number of recently ready nodes per nodegroup. those created since " + DurationToConsiderNewNodes.String() + " ago",
+		Help: "number of recently ready nodes per nodegroup. those ready since --max-time-consider-new-node ago",
 	}, []string{"nodegroup"})
+
+	mAutoscalingGroupTopology = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: MetricsPrefix + "autoscaling_group_topology",
+		Help: "topology classification per autoscaling group: multi-az, single-az, az-imbalanced, unknown",
+	}, []string{"autoscaling_group", "classification"})
+
+	mAutoscalingGroupAvailabilityZones = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: MetricsPrefix + "autoscaling_group_availability_zones",
+		Help: "number of availability zones per autoscaling group",
+	}, []string{"autoscaling_group"})
+
+	mAutoscalingGroupCapacityRebalance = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: MetricsPrefix + "autoscaling_group_capacity_rebalance",
+		Help: "whether capacity-rebalance is enabled (1) or not (0) per autoscaling group",
+	}, []string{"autoscaling_group"})
+
+	mReplacementsAtRiskTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: MetricsPrefix + "replacements_at_risk_total",
+		Help: "number of replacement nodes launched by a boost that received a rebalance recommendation too",
+	}, []string{"autoscaling_group", "instance_type"})
+
+	mReplacementBackoff = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: MetricsPrefix + "replacement_backoff",
+		Help: "whether boosting is backed off (1) or not (0) per autoscaling group, because its replacements are under risk too",
+	}, []string{"autoscaling_group"})
+
+	mPaused = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: MetricsPrefix + "paused",
+		Help: "whether a process is paused (1) or not (0) cluster-wide by the control configmap",
+	}, []string{"process"})
+
+	mAutoscalingGroupPaused = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: MetricsPrefix + "autoscaling_group_paused",
+		Help: "whether boosting is paused (1) or not (0) per autoscaling group by its tags",
+	}, []string{"autoscaling_group"})
+
+	mNodesExcludedFromDrain = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: MetricsPrefix + "nodes_excluded_from_drain_total",
+		Help: "number of nodes excluded from the drain process by annotation",
+	})
+
+	mBoostedNodes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: MetricsPrefix + "boosted_nodes",
+		Help: "number of nodes over the ready ones requested per autoscaling group, once the budgets are applied",
+	}, []string{"autoscaling_group"})
+
+	mBoostTrimmedNodes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: MetricsPrefix + "boost_trimmed_nodes",
+		Help: "number of nodes trimmed from the boost per autoscaling group to fit the budgets, by reason",
+	}, []string{"autoscaling_group", "reason"})
+
+	mCircuitBreakerTripped = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: MetricsPrefix + "circuit_breaker_tripped",
+		Help: "whether the circuit breaker is tripped (1) or not (0) per autoscaling group, or for the whole cluster",
+	}, []string{"autoscaling_group"})
+
+	mCircuitBreakerTripsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: MetricsPrefix + "circuit_breaker_trips_total",
+		Help: "number of times the circuit breaker was tripped per autoscaling group, or for the whole cluster",
+	}, []string{"autoscaling_group"})
+
+	mBoostsAppliedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: MetricsPrefix + "boosts_applied_total",
+		Help: "number of desired capacity changes applied on aws per autoscaling group",
+	}, []string{"autoscaling_group"})
+
+	mAutoscalingGroupCalculatedCapacity = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: MetricsPrefix + "autoscaling_group_calculated_capacity",
+		Help: "capacity calculated from the events per autoscaling group, before the budgets and the extra nodes",
+	}, []string{"autoscaling_group"})
+
+	mAutoscalingGroupDesiredCapacity = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: MetricsPrefix + "autoscaling_group_desired_capacity",
+		Help: "desired capacity per autoscaling group on aws, as seen on the last boost attempt",
+	}, []string{"autoscaling_group"})
+
+	mDrainsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: MetricsPrefix + "drains_total",
+		Help: "number of drains per nodegroup and result: started, succeeded, failed, timed-out",
+	}, []string{"nodegroup", "result"})
+
+	mDrainDurationSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    MetricsPrefix + "drain_duration_seconds",
+		Help:    "duration of the drains per nodegroup and result",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 10),
+	}, []string{"nodegroup", "result"})
+
+	mEventToTerminationSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    MetricsPrefix + "event_to_termination_seconds",
+		Help:    "time from the rebalance recommendation event to the confirmed termination of the instance per autoscaling group",
+		Buckets: prometheus.ExponentialBuckets(15, 2, 10),
+	}, []string{"autoscaling_group"})
+
+	mAwsApiCallsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: MetricsPrefix + "aws_api_calls_total",
+		Help: "number of calls done to aws api per service and operation",
+	}, []string{"service", "operation"})
+
+	mAwsApiErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: MetricsPrefix + "aws_api_errors_total",
+		Help: "number of failed calls to aws api per service, operation and error code",
+	}, []string{"service", "operation", "code"})
+
+	mAwsApiThrottlesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: MetricsPrefix + "aws_api_throttles_total",
+		Help: "number of calls to aws api throttled per service and operation",
+	}, []string{"service", "operation"})
+
+	mAwsApiRetriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: MetricsPrefix + "aws_api_retries_total",
+		Help: "number of calls to aws api retried per service, operation and error code",
+	}, []string{"service", "operation", "code"})
+
+	mAwsApiRetriesExhaustedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: MetricsPrefix + "aws_api_retries_exhausted_total",
+		Help: "number of calls to aws api failed after all the retries per service and operation",
+	}, []string{"service", "operation"})
+
+	mAwsApiCallDurationSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    MetricsPrefix + "aws_api_call_duration_seconds",
+		Help:    "duration of the calls done to aws api per service and operation, each retry measured on its own",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"service", "operation"})
+
+	mWatchRestartsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: MetricsPrefix + "watch_restarts_total",
+		Help: "number of times a kubernetes watcher was restarted per watched resource",
+	}, []string{"watcher"})
+
+	mLoopDurationSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    MetricsPrefix + "loop_duration_seconds",
+		Help:    "duration of each iteration of the main loops, sleeps excluded",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"loop"})
+
+	mSynchronizationRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: MetricsPrefix + "synchronization_requests_total",
+		Help: "number of synchronizations requested per source of the change, before being coalesced",
+	}, []string{"source"})
 )
 
-// TODO
-func upgradePrometheusMetrics(eventPool *EventPool, nodePool *NodePool, autoscalingGroupPool *AutoscalingGroupPool) (err error) {
+// Metrics of the plan calculated on dry-run
+var (
+	mPlanDesiredCapacity = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: MetricsPrefix + "plan_desired_capacity",
+		Help: "current and intended desired capacity per autoscaling group on dry-run",
+	}, []string{"autoscaling_group", "capacity"})
 
-	nodegroups := GetNodeGroupNames(nodePool)
+	mPlanDrains = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: MetricsPrefix + "plan_drains",
+		Help: "number of nodes that would be drained (and their instances terminated) per nodegroup on dry-run",
+	}, []string{"nodegroup"})
+
+	mPlanDrainBatches = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: MetricsPrefix + "plan_drain_batches",
+		Help: "number of drain batches needed to drain all the nodes under risk on dry-run",
+	})
+)
 
-	// Get a map of node-groups, each value is the count of its events
-	nodeGroupEventsCount := GetEventCountByNodeGroup(eventPool, nodePool)
+// Metrics labeled by nodegroup or autoscaling group, whose label sets are deleted when those disappear
+var (
+	nodegroupLabeledMetrics = []*prometheus.MetricVec{
+		mNodegroupEventsTotal.MetricVec,
+		mNodegroupNodesTotal.MetricVec,
+		mNodegroupCordonedNodesTotal.MetricVec,
+		mNodegroupRecentlyReadyNodesTotal.MetricVec,
+		mDrainsTotal.MetricVec,
+		mDrainDurationSeconds.MetricVec,
+		mPlanDrains.MetricVec,
+	}
 
-	// Get a map of node-groups, each value is the count of its nodes
-	nodeGroupNodesCount := GetNodeCountByNodeGroup(nodePool)
+	autoscalingGroupLabeledMetrics = []*prometheus.MetricVec{
+		mAutoscalingGroupTopology.MetricVec,
+		mAutoscalingGroupAvailabilityZones.MetricVec,
+		mAutoscalingGroupCapacityRebalance.MetricVec,
+		mReplacementsAtRiskTotal.MetricVec,
+		mReplacementBackoff.MetricVec,
+		mAutoscalingGroupPaused.MetricVec,
+		mBoostedNodes.MetricVec,
+		mBoostTrimmedNodes.MetricVec,
+		mCircuitBreakerTripped.MetricVec,
+		mCircuitBreakerTripsTotal.MetricVec,
+		mBoostsAppliedTotal.MetricVec,
+		mAutoscalingGroupCalculatedCapacity.MetricVec,
+		mAutoscalingGroupDesiredCapacity.MetricVec,
+		mEventToTerminationSeconds.MetricVec,
+		mPlanDesiredCapacity.MetricVec,
+	}
 
-	// Get a map of node-groups, each value is the count of its cordoned nodes
-	nodeGroupCordonedNodesCount := GetCordonedNodeCountByNodeGroup(nodePool)
+	// Label values exported on the previous update of the metrics
+	exportedLabelsLock        sync.Mutex
+	exportedNodegroups        = map[string]bool{}
+	exportedAutoscalingGroups = map[string]bool{}
+)
 
-	// Get a map of node-group, each value is the count of its recently-ready nodes
-	nodeGroupRecentReadyNodesCount := GetRecentlyReadyNodeCountByNodeGroup(nodePool, DurationToConsiderNewNodes, true)
+// observeLoopDuration record the duration of a loop iteration started at the given moment
+func observeLoopDuration(loopName string, start time.Time) {
+	mLoopDurationSeconds.WithLabelValues(loopName).Observe(time.Since(start).Seconds())
+}
+
+// updateCalculatedCapacityMetrics export the capacities calculated from the events.
+// ASGs without events are not calculated, so they are removed from the metric
+func updateCalculatedCapacityMetrics(asgsCalculatedCapacity map[string]int) {
+	mAutoscalingGroupCalculatedCapacity.Reset()
+	for asgName, calculatedCapacity := range asgsCalculatedCapacity {
+		mAutoscalingGroupCalculatedCapacity.WithLabelValues(asgName).Set(float64(calculatedCapacity))
+	}
+}
+
+// deleteStaleMetrics delete the label sets of the nodegroups and ASGs that are not present anymore.
+// Empty lists are ignored, as the pools are empty while their watchers are restarting
+func deleteStaleMetrics(nodegroups []string, autoscalingGroupNames []string) {
+
+	if len(nodegroups) == 0 || len(autoscalingGroupNames) == 0 {
+		return
+	}
+
+	currentNodegroups := map[string]bool{}
+	for _, nodegroupName := range nodegroups {
+		currentNodegroups[nodegroupName] = true
+	}
+
+	currentAutoscalingGroups := map[string]bool{}
+	for _, autoscalingGroupName := range autoscalingGroupNames {
+		currentAutoscalingGroups[autoscalingGroupName] = true
+	}
+
+	exportedLabelsLock.Lock()
+	defer exportedLabelsLock.Unlock()
+
+	for nodegroupName := range exportedNodegroups {
+		if currentNodegroups[nodegroupName] {
+			continue
+		}
+		for _, metric := range nodegroupLabeledMetrics {
+			metric.DeletePartialMatch(prometheus.Labels{"nodegroup": nodegroupName})
+		}
+	}
+
+	for autoscalingGroupName := range exportedAutoscalingGroups {
+		if currentAutoscalingGroups[autoscalingGroupName] {
+			continue
+		}
+		for _, metric := range autoscalingGroupLabeledMetrics {
+			metric.DeletePartialMatch(prometheus.Labels{"autoscaling_group": autoscalingGroupName})
+		}
+	}
+
+	exportedNodegroups = currentNodegroups
+	exportedAutoscalingGroups = currentAutoscalingGroups
+}
+
+// upgradePrometheusMetrics update the metrics calculated from the pools, deleting those of the gone nodegroups and ASGs
+func upgradePrometheusMetrics(aggregate *NodegroupsAggregate, autoscalingGroupPool *AutoscalingGroupPool) (err error) {
+
+	nodegroups := aggregate.Nodegroups
 
 	for _, nodegroupName := range nodegroups {
 
 		// Convert all the values to proper format
-		nodegroupEventsTotal := float64(nodeGroupEventsCount[nodegroupName])
-		nodegroupNodesTotal := float64(nodeGroupNodesCount[nodegroupName])
-		nodegroupCordonedNodesTotal := float64(nodeGroupCordonedNodesCount[nodegroupName])
-		nodegroupRecentlyReadyNodesTotal := float64(nodeGroupRecentReadyNodesCount[nodegroupName])
+		nodegroupEventsTotal := float64(aggregate.EventsCount[nodegroupName])
+		nodegroupNodesTotal := float64(aggregate.NodesCount[nodegroupName])
+		nodegroupCordonedNodesTotal := float64(aggregate.CordonedNodesCount[nodegroupName])
+		nodegroupRecentlyReadyNodesTotal := float64(aggregate.RecentlyReadyCount[nodegroupName])
 
 		// Update all the metrics for this nodegroup
 		mNodegroupEventsTotal.WithLabelValues(nodegroupName).Set(nodegroupEventsTotal)
@@ -66,5 +315,7 @@ func upgradePrometheusMetrics(eventPool *EventPool, nodePool *NodePool, autoscal
 		mNodegroupRecentlyReadyNodesTotal.WithLabelValues(nodegroupName).Set(nodegroupRecentlyReadyNodesTotal)
 	}
 
+	deleteStaleMetrics(nodegroups, GetAutoscalingGroupsNames(autoscalingGroupPool))
+
 	return nil
 }
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"k8s.io/client-go/util/workqueue"
+	"time"
+)
+
+const (
+	// SynchronizationKey is the only item of the queue: all the changes request the same synchronization
+	SynchronizationKey = "synchronization"
+
+	// Sources of the synchronization requests
+	NodesSource             = "nodes"
+	EventsSource            = "events"
+	AutoscalingGroupsSource = "autoscaling-groups"
+	ResyncSource            = "resync"
+
+	// Info messages
+	SynchronizationRequestedMessage = "synchronization requested by a change on the %s"
+	UnchangedTargetMessage          = "skipping changes for asg '%s': desired capacity '%d' was already applied"
+)
+
+// NewReconciler return a reconciler ready to be used
+func NewReconciler(debounce time.Duration) *Reconciler {
+	return &Reconciler{
+		Queue:             workqueue.NewNamedDelayingQueue(SynchronizationKey),
+		Debounce:          debounce,
+		AppliedCapacities: map[string]int{},
+	}
+}
+
+// RequestSynchronization enqueue a synchronization after the debounce time.
+// Requests done while another one is waiting are coalesced into it by the queue
+func RequestSynchronization(ctx *Ctx, reconciler *Reconciler, source string) {
+	ctx.Logger.Debugf(SynchronizationRequestedMessage, source)
+	mSynchronizationRequestsTotal.WithLabelValues(source).Inc()
+
+	reconciler.Queue.AddAfter(SynchronizationKey, reconciler.Debounce)
+}
+
+// RunReconciler execute the synchronizations requested on the queue, one at a time.
+// A synchronization is requested on start and periodically, to review the decisions that depend on time
+// This function blocks until the queue is shut down
+func RunReconciler(ctx *Ctx, reconciler *Reconciler, resyncPeriod time.Duration, synchronize func()) {
+
+	go func() {
+		for {
+			// Verify the applied capacities against AWS again, as they can be changed by others
+			ForgetAppliedCapacities(reconciler)
+			RequestSynchronization(ctx, reconciler, ResyncSource)
+
+			time.Sleep(resyncPeriod)
+		}
+	}()
+
+	for {
+		item, shutdown := reconciler.Queue.Get()
+		if shutdown {
+			return
+		}
+
+		synchronize()
+		reconciler.Queue.Done(item)
+	}
+}
+
+// IsCapacityApplied return true when a desired capacity is the last one applied to an ASG
+func IsCapacityApplied(reconciler *Reconciler, autoscalingGroupName string, desiredCapacity int) bool {
+	reconciler.Lock.Lock()
+	defer reconciler.Lock.Unlock()
+
+	appliedCapacity, found := reconciler.AppliedCapacities[autoscalingGroupName]
+	return found && appliedCapacity == desiredCapacity
+}
+
+// RecordAppliedCapacity store the desired capacity applied to an ASG
+func RecordAppliedCapacity(reconciler *Reconciler, autoscalingGroupName string, desiredCapacity int) {
+	reconciler.Lock.Lock()
+	defer reconciler.Lock.Unlock()
+
+	reconciler.AppliedCapacities[autoscalingGroupName] = desiredCapacity
+}
+
+// ForgetAppliedCapacities delete the applied capacities of the ASGs not present in a list of targets,
+// so they are applied again when they are targeted later. All of them are deleted when no list is given
+func ForgetAppliedCapacities(reconciler *Reconciler, targetedAutoscalingGroupNames ...string) {
+	reconciler.Lock.Lock()
+	defer reconciler.Lock.Unlock()
+
+	targeted := map[string]bool{}
+	for _, autoscalingGroupName := range targetedAutoscalingGroupNames {
+		targeted[autoscalingGroupName] = true
+	}
+
+	for autoscalingGroupName := range reconciler.AppliedCapacities {
+		if !targeted[autoscalingGroupName] {
+			delete(reconciler.AppliedCapacities, autoscalingGroupName)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"go.uber.org/zap"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReconcilerCoalescesRequests(t *testing.T) {
+	ctx := &Ctx{Logger: zap.NewNop().Sugar()}
+	reconciler := NewReconciler(50 * time.Millisecond)
+	defer reconciler.Queue.ShutDown()
+
+	var synchronizations atomic.Int32
+	go RunReconciler(ctx, reconciler, time.Hour, func() { synchronizations.Add(1) })
+
+	// A synchronization is done on start
+	deadline := time.Now().Add(5 * time.Second)
+	for synchronizations.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for i := 0; i < 10; i++ {
+		RequestSynchronization(ctx, reconciler, NodesSource)
+	}
+	time.Sleep(500 * time.Millisecond)
+
+	if count := synchronizations.Load(); count != 2 {
+		t.Errorf("expected the requests to be coalesced into 1 synchronization after the initial one, got %d", count-1)
+	}
+}
+
+func TestForgetAppliedCapacities(t *testing.T) {
+	reconciler := NewReconciler(0)
+	RecordAppliedCapacity(reconciler, "eks-one", 4)
+	RecordAppliedCapacity(reconciler, "eks-two", 2)
+
+	ForgetAppliedCapacities(reconciler, "eks-one")
+	if !IsCapacityApplied(reconciler, "eks-one", 4) || IsCapacityApplied(reconciler, "eks-two", 2) {
+		t.Fatalf("expected only the capacity of the targeted asg to be kept, got %v", reconciler.AppliedCapacities)
+	}
+
+	ForgetAppliedCapacities(reconciler)
+	if len(reconciler.AppliedCapacities) != 0 {
+		t.Errorf("expected all the capacities to be forgotten, got %v", reconciler.AppliedCapacities)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// SnapshotFilePrefix is the prefix of the files written on record mode
+	SnapshotFilePrefix = "snapshot-"
+
+	// SnapshotFileTimeLayout is the layout of the moment included in the name of the snapshot files
+	SnapshotFileTimeLayout = "20060102T150405Z"
+
+	// Tags and labels of the ASGs and the nodes including the name of the cluster
+	AWSClusterNameTag                 = "eks:cluster-name"
+	KubernetesClusterTagPrefix        = "kubernetes.io/cluster/"
+	ClusterAutoscalerTagPrefix        = "k8s.io/cluster-autoscaler/"
+	ClusterAutoscalerEnabledTagSuffix = "enabled"
+	EksctlClusterNameLabel            = "alpha.eksctl.io/cluster-name"
+
+	// Info messages
+	SnapshotWrittenMessage = "snapshot written to '%s'"
+
+	// Error messages
+	SnapshotWriteErrorMessage = "impossible to write snapshot: %v"
+	SnapshotPruneErrorMessage = "impossible to delete old snapshot '%s': %v"
+)
+
+var (
+	// ipAddressRegex matches IPv4 addresses, both in dotted form and as used on EC2 hostnames (ip-10-0-1-10)
+	ipAddressRegex = regexp.MustCompile(`\b(?:ip-)?(\d{1,3})[.-](\d{1,3})[.-](\d{1,3})[.-](\d{1,3})\b`)
+)
+
+// TakeSnapshot return a copy of the inputs seen by the controller at this moment
+func TakeSnapshot(nodePool *NodePool, eventPool *EventPool, autoscalingGroupPool *AutoscalingGroupPool) (snapshot Snapshot) {
+
+	snapshot.Version = SnapshotFormatVersion
+	snapshot.Timestamp = time.Now().UTC()
+
+	for _, node := range nodePool.Snapshot() {
+		nodeCopy := node.DeepCopy()
+
+		// Not used by the controller, and too big to be stored on each snapshot
+		nodeCopy.ManagedFields = nil
+		nodeCopy.Status.Images = nil
+
+		snapshot.Nodes = append(snapshot.Nodes, *nodeCopy)
+	}
+
+	for _, event := range eventPool.Snapshot() {
+		eventCopy := event.DeepCopy()
+		eventCopy.ManagedFields = nil
+		snapshot.Events = append(snapshot.Events, *eventCopy)
+	}
+
+	snapshot.ClusterAutoscalerStatus = autoscalingGroupPool.Status()
+	snapshot.AutoscalingGroupTags = map[string]map[string]string{}
+	for _, autoscalingGroup := range autoscalingGroupPool.Snapshot() {
+		tags := map[string]string{}
+		for key, value := range autoscalingGroup.Tags {
+			tags[key] = value
+		}
+		snapshot.AutoscalingGroupTags[autoscalingGroup.Name] = tags
+	}
+
+	return snapshot
+}
+
+// getRedactedValue return a stable replacement for a sensitive value, so the same value is always replaced
+// by the same one, and the relations between the objects of the snapshots are kept
+func getRedactedValue(kind string, value string) string {
+	hash := sha256.Sum256([]byte(value))
+	return kind + "-" + hex.EncodeToString(hash[:])[:12]
+}
+
+// getClusterNamesFromTags return the names of the cluster found in the tags of an ASG, both on their values and keys.
+// Those keys are redacted too, as the cluster name is replaced everywhere
+func getClusterNamesFromTags(tags map[string]string) (clusterNames []string) {
+
+	for key, value := range tags {
+		switch {
+		case key == AWSClusterNameTag:
+			clusterNames = append(clusterNames, value)
+		case strings.HasPrefix(key, KubernetesClusterTagPrefix):
+			clusterNames = append(clusterNames, strings.TrimPrefix(key, KubernetesClusterTagPrefix))
+		case strings.HasPrefix(key, ClusterAutoscalerTagPrefix):
+			// Other Cluster Autoscaler's tags, like the enabled or the node-template ones, don't include the cluster name
+			clusterName := strings.TrimPrefix(key, ClusterAutoscalerTagPrefix)
+			if clusterName != ClusterAutoscalerEnabledTagSuffix && !strings.Contains(clusterName, "/") {
+				clusterNames = append(clusterNames, clusterName)
+			}
+		}
+	}
+
+	return clusterNames
+}
+
+// getSensitiveNames return the names that can identify the cluster found in a snapshot, with their replacements
+func getSensitiveNames(snapshot *Snapshot) (replacements map[string]string) {
+
+	replacements = map[string]string{}
+	add := func(kind string, value string) {
+		if value != "" {
+			replacements[value] = getRedactedValue(kind, value)
+		}
+	}
+
+	for _, node := range snapshot.Nodes {
+		add("node", node.Name)
+		add("nodegroup", node.Labels[AWSNodeGroupLabel])
+		add("instance", GetInstanceIdFromProviderID(node.Spec.ProviderID))
+		add("cluster", node.Labels[EksctlClusterNameLabel])
+	}
+
+	for _, event := range snapshot.Events {
+		add("event", event.Name)
+		add("node", event.InvolvedObject.Name)
+	}
+
+	for autoscalingGroupName, tags := range snapshot.AutoscalingGroupTags {
+		add("asg", autoscalingGroupName)
+		add("nodegroup", tags[AWSAutoscalingGroupsNodeGroupTag])
+		for _, clusterName := range getClusterNamesFromTags(tags) {
+			add("cluster", clusterName)
+		}
+	}
+	for _, autoscalingGroupName := range ParseAutoscalingGroupsNames(snapshot.ClusterAutoscalerStatus) {
+		add("asg", autoscalingGroupName)
+	}
+
+	return replacements
+}
+
+// RedactSnapshot return the content of a snapshot replacing the names and the IP addresses with stable hashes.
+// Replacements are done over the whole content, so the values are replaced also inside messages, statuses, etc.
+func RedactSnapshot(snapshot *Snapshot, redactNames bool, redactIPs bool) (content []byte, err error) {
+
+	content, err = json.Marshal(snapshot)
+	if err != nil {
+		return content, err
+	}
+
+	if redactNames {
+		replacements := getSensitiveNames(snapshot)
+
+		// Longest values first, so those containing others are replaced as a whole
+		values := make([]string, 0, len(replacements))
+		for value := range replacements {
+			values = append(values, value)
+		}
+		sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+
+		var replacerArgs []string
+		for _, value := range values {
+			replacerArgs = append(replacerArgs, value, replacements[value])
+		}
+		content = []byte(strings.NewReplacer(replacerArgs...).Replace(string(content)))
+	}
+
+	if redactIPs {
+		content = ipAddressRegex.ReplaceAllFunc(content, func(match []byte) []byte {
+			octets := ipAddressRegex.FindSubmatch(match)[1:]
+			ipAddress := fmt.Sprintf("%s.%s.%s.%s", octets[0], octets[1], octets[2], octets[3])
+			return []byte(getRedactedValue("ip", ipAddress))
+		})
+	}
+
+	return content, nil
+}
+
+// WriteSnapshot write the content of a snapshot into a new file in a directory, returning its path.
+// The file is written with a temporary name first, so readers never find it incomplete
+func WriteSnapshot(directory string, timestamp time.Time, content []byte) (path string, err error) {
+
+	err = os.MkdirAll(directory, 0755)
+	if err != nil {
+		return path, err
+	}
+
+	path = filepath.Join(directory, SnapshotFilePrefix+timestamp.UTC().Format(SnapshotFileTimeLayout)+SnapshotFileExtension)
+	temporaryPath := path + ".tmp"
+
+	err = os.WriteFile(temporaryPath, append(content, '\n'), 0644)
+	if err != nil {
+		return path, err
+	}
+
+	return path, os.Rename(temporaryPath, path)
+}
+
+// pruneSnapshots delete the oldest snapshot files of a directory, keeping only the newest ones
+func pruneSnapshots(ctx *Ctx, directory string, maxSnapshots int) {
+
+	if maxSnapshots <= 0 {
+		return
+	}
+
+	paths, err := filepath.Glob(filepath.Join(directory, SnapshotFilePrefix+"*"+SnapshotFileExtension))
+	if err != nil || len(paths) <= maxSnapshots {
+		return
+	}
+
+	// Names contain the moment, so sorting them sorts the snapshots by age
+	sort.Strings(paths)
+	for _, path := range paths[:len(paths)-maxSnapshots] {
+		err = os.Remove(path)
+		if err != nil {
+			ctx.Logger.Infof(SnapshotPruneErrorMessage, path, err)
+		}
+	}
+}
+
+// RecordSnapshots write periodic snapshots of the inputs seen by the controller, to be replayed later
+// This function must be executed as a go routine
+func RecordSnapshots(ctx *Ctx, nodePool *NodePool, eventPool *EventPool, autoscalingGroupPool *AutoscalingGroupPool) {
+
+	for {
+		time.Sleep(*ctx.Flags.RecordInterval)
+
+		// Nothing to record while the watchers are filling the pools
+		if autoscalingGroupPool.Status() == "" {
+			continue
+		}
+
+		snapshot := TakeSnapshot(nodePool, eventPool, autoscalingGroupPool)
+
+		content, err := RedactSnapshot(&snapshot, *ctx.Flags.RecordRedactNames, *ctx.Flags.RecordRedactIPs)
+		if err != nil {
+			ctx.Logger.Infof(SnapshotWriteErrorMessage, err)
+			continue
+		}
+
+		path, err := WriteSnapshot(*ctx.Flags.RecordDir, snapshot.Timestamp, content)
+		if err != nil {
+			ctx.Logger.Infof(SnapshotWriteErrorMessage, err)
+			continue
+		}
+		ctx.Logger.Infof(SnapshotWrittenMessage, path)
+
+		pruneSnapshots(ctx, *ctx.Flags.RecordDir, *ctx.Flags.RecordMaxSnapshots)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strings"
+	"testing"
+)
+
+// newRedactionTestSnapshot return a snapshot with a node, its event and its ASG, all of them on cluster 'prod-cluster'
+func newRedactionTestSnapshot() *Snapshot {
+	return &Snapshot{
+		Version: SnapshotFormatVersion,
+		Nodes: []v1.Node{{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "ip-10-0-1-10.eu-west-1.compute.internal",
+				Labels: map[string]string{
+					AWSNodeGroupLabel:      "spot",
+					EksctlClusterNameLabel: "prod-cluster",
+				},
+			},
+			Spec: v1.NodeSpec{ProviderID: "aws:///eu-west-1a/i-0123456789abcdef0"},
+			Status: v1.NodeStatus{Addresses: []v1.NodeAddress{
+				{Type: v1.NodeInternalIP, Address: "10.0.1.10"},
+			}},
+		}},
+		Events: []v1.Event{{
+			ObjectMeta:     metav1.ObjectMeta{Name: "rebalance-1"},
+			InvolvedObject: v1.ObjectReference{Name: "ip-10-0-1-10.eu-west-1.compute.internal"},
+		}},
+		AutoscalingGroupTags: map[string]map[string]string{
+			"eks-spot-asg": {
+				AWSAutoscalingGroupsNodeGroupTag:                 "spot",
+				AWSClusterNameTag:                                "prod-cluster",
+				KubernetesClusterTagPrefix + "prod-cluster":      "owned",
+				ClusterAutoscalerTagPrefix + "prod-cluster":      "owned",
+				ClusterAutoscalerTagPrefix + "enabled":           "true",
+				ClusterAutoscalerTagPrefix + "node-template/foo": "bar",
+			},
+		},
+	}
+}
+
+func TestRedactSnapshotNamesAreStable(t *testing.T) {
+	first, err := RedactSnapshot(newRedactionTestSnapshot(), true, false)
+	if err != nil {
+		t.Fatalf("unexpected error redacting the snapshot: %v", err)
+	}
+	second, _ := RedactSnapshot(newRedactionTestSnapshot(), true, false)
+
+	if string(first) != string(second) {
+		t.Errorf("the same snapshot was redacted differently:\n%s\n%s", first, second)
+	}
+
+	// The node is replaced by the same hash on itself and on its event
+	redactedNode := getRedactedValue("node", "ip-10-0-1-10.eu-west-1.compute.internal")
+	if count := strings.Count(string(first), redactedNode); count != 2 {
+		t.Errorf("expected the node to be replaced twice by '%s', found %d times:\n%s", redactedNode, count, first)
+	}
+}
+
+func TestRedactSnapshotReplacesLongestNamesFirst(t *testing.T) {
+	content, _ := RedactSnapshot(newRedactionTestSnapshot(), true, false)
+
+	// The ASG contains the nodegroup name, so it must be replaced as a whole
+	if redactedASG := getRedactedValue("asg", "eks-spot-asg"); !strings.Contains(string(content), redactedASG) {
+		t.Errorf("expected the asg to be replaced by '%s':\n%s", redactedASG, content)
+	}
+	if partial := "eks-" + getRedactedValue("nodegroup", "spot"); strings.Contains(string(content), partial) {
+		t.Errorf("the asg was partially replaced as '%s':\n%s", partial, content)
+	}
+}
+
+func TestRedactSnapshotReplacesClusterName(t *testing.T) {
+	content, _ := RedactSnapshot(newRedactionTestSnapshot(), true, false)
+
+	if strings.Contains(string(content), "prod-cluster") {
+		t.Errorf("the cluster name leaked into the redacted snapshot:\n%s", content)
+	}
+
+	redactedCluster := getRedactedValue("cluster", "prod-cluster")
+	for _, expected := range []string{
+		KubernetesClusterTagPrefix + redactedCluster,
+		ClusterAutoscalerTagPrefix + redactedCluster,
+		ClusterAutoscalerTagPrefix + "enabled",
+		ClusterAutoscalerTagPrefix + "node-template/foo",
+	} {
+		if !strings.Contains(string(content), expected) {
+			t.Errorf("expected tag '%s' on the redacted snapshot:\n%s", expected, content)
+		}
+	}
+}
+
+func TestRedactSnapshotReplacesIPAddresses(t *testing.T) {
+	content, _ := RedactSnapshot(newRedactionTestSnapshot(), false, true)
+
+	if strings.Contains(string(content), "10.0.1.10") || strings.Contains(string(content), "10-0-1-10") {
+		t.Errorf("the ip address leaked into the redacted snapshot:\n%s", content)
+	}
+
+	// Dotted addresses and those inside EC2 hostnames are replaced by the same hash
+	redactedIP := getRedactedValue("ip", "10.0.1.10")
+	if count := strings.Count(string(content), redactedIP+".eu-west-1.compute.internal"); count != 2 {
+		t.Errorf("expected the hostname to be replaced twice by '%s', found %d times:\n%s", redactedIP, count, content)
+	}
+	if !strings.Contains(string(content), `"address":"`+redactedIP+`"`) {
+		t.Errorf("expected the node address to be replaced by '%s':\n%s", redactedIP, content)
+	}
+}
+
+func TestIPAddressRegex(t *testing.T) {
+	for text, expected := range map[string]string{
+		"10.0.1.10": "10.0.1.10",
+		"ip-10-0-1-10.eu-west-1.compute.internal": "ip-10-0-1-10",
+		"node ip-192-168-0-1 is ready":            "ip-192-168-0-1",
+		"version 1.26.1":                          "",
+		"i-0123456789abcdef0":                     "",
+	} {
+		if match := ipAddressRegex.FindString(text); match != expected {
+			t.Errorf("expected '%s' to match '%s', got '%s'", text, expected, match)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"strings"
+)
+
+const (
+	// AutoscalingGroupEventAnnotation stores the name of the ASG an event is about, when emitted on the controller
+	AutoscalingGroupEventAnnotation = "asbooster.docplanner.com/autoscaling-group"
+
+	// Reasons for the events emitted on the nodes
+	DrainStartedReason       = "DrainStarted"
+	DrainFailedReason        = "DrainFailed"
+	DrainSucceededReason     = "DrainSucceeded"
+	TerminatedReason         = "InstanceTerminated"
+	TerminationGivenUpReason = "TerminationGivenUp"
+
+	// Reasons for the events emitted on the controller about the ASGs
+	BoostAppliedReason = "BoostApplied"
+	BoostClampedReason = "BoostClamped"
+
+	// Messages for the events
+	DrainStartedEventMessage       = "draining the node under risk (instance %s)"
+	DrainFailedEventMessage        = "drain of the node under risk failed: %v"
+	DrainSucceededEventMessage     = "node under risk drained"
+	TerminatedEventMessage         = "instance %s of the node under risk was terminated"
+	TerminationGivenUpEventMessage = "termination of the instance %s given up (%s), the node stays drained until annotation '%s' is removed"
+	BoostAppliedEventMessage       = "desired capacity of asg '%s' set to %d"
+	BoostClampedEventMessage       = "boost of asg '%s' clamped from %d to %d: %s"
+)
+
+// NewEventRecorder return a recorder to emit Kubernetes events on behalf of the controller.
+// Similar events are aggregated, and rate limited per object and reason
+func NewEventRecorder(client kubernetes.Interface, burst int, qps float32) record.EventRecorder {
+
+	eventBroadcaster := record.NewBroadcasterWithCorrelatorOptions(record.CorrelatorOptions{
+		BurstSize: burst,
+		QPS:       qps,
+
+		// Rate limit each reason and ASG on its own, as all the ASGs share the same object
+		SpamKeyFunc: func(event *v1.Event) string {
+			return strings.Join([]string{
+				event.Source.Component,
+				event.InvolvedObject.Kind,
+				event.InvolvedObject.Namespace,
+				event.InvolvedObject.Name,
+				event.Reason,
+				event.Annotations[AutoscalingGroupEventAnnotation],
+			}, "")
+		},
+	})
+
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+
+	return eventBroadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: ControllerName})
+}
+
+// RecordNodeEvent emit a Kubernetes event on a node, visible on 'kubectl describe node'
+func RecordNodeEvent(ctx *Ctx, nodeName string, eventType string, reason string, messageFmt string, args ...interface{}) {
+
+	if ctx.Recorder == nil {
+		return
+	}
+
+	// Node events use its name as UID, as kubelet does
+	nodeReference := &v1.ObjectReference{
+		Kind: "Node",
+		Name: nodeName,
+		UID:  types.UID(nodeName),
+	}
+
+	ctx.Recorder.Eventf(nodeReference, eventType, reason, messageFmt, args...)
+}
+
+// RecordAutoscalingGroupEvent emit a Kubernetes event about an ASG on the controller's Deployment
+func RecordAutoscalingGroupEvent(ctx *Ctx, autoscalingGroupName string, eventType string, reason string, messageFmt string, args ...interface{}) {
+
+	if ctx.Recorder == nil || *ctx.Flags.ControllerDeploymentName == "" {
+		return
+	}
+
+	deploymentReference := &v1.ObjectReference{
+		APIVersion: "apps/v1",
+		Kind:       "Deployment",
+		Namespace:  *ctx.Flags.ControllerNamespace,
+		Name:       *ctx.Flags.ControllerDeploymentName,
+	}
+
+	ctx.Recorder.AnnotatedEventf(deploymentReference, map[string]string{
+		AutoscalingGroupEventAnnotation: autoscalingGroupName,
+	}, eventType, reason, fmt.Sprintf("[%s] %s", autoscalingGroupName, messageFmt), args...)
+}
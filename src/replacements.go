@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"golang.org/x/exp/maps"
+	v1 "k8s.io/api/core/v1"
+	"sort"
+	"time"
+)
+
+const (
+	// InstanceTypeLabel is the node's label to store the instance type of a node
+	InstanceTypeLabel = "node.kubernetes.io/instance-type"
+
+	// Reasons for the events emitted when replacements are under risk
+	ReplacementsAtRiskReason = "ReplacementsAtRisk"
+
+	// Info messages
+	ReplacementAtRiskMessage          = "replacement node '%s' (%s) launched by the boost of asg '%s' at %s is under risk too"
+	ReplacementBackoffSkipMessage     = "skipping changes for asg '%s': its replacement nodes keep receiving events"
+	ReplacementBackoffStartedMessage  = "%d replacement nodes of asg '%s' under risk in the last %s, backing off boosts for %s. consider diversifying its instance types (seen: %v)"
+	ReplacementBackoffFinishedMessage = "back off of asg '%s' is finished"
+)
+
+// NewReplacementRiskTracker return a tracker for replacement nodes under risk ready to be used
+func NewReplacementRiskTracker() *ReplacementRiskTracker {
+	return &ReplacementRiskTracker{
+		SeenEvents:    map[string]time.Time{},
+		Boosts:        map[string][]time.Time{},
+		Occurrences:   map[string][]time.Time{},
+		InstanceTypes: map[string]map[string]int{},
+		BackoffUntil:  map[string]time.Time{},
+	}
+}
+
+// RecordReplacementBoost store the moment of a boost applied to an ASG, to link the nodes launched by it later
+func RecordReplacementBoost(tracker *ReplacementRiskTracker, autoscalingGroupName string) {
+	tracker.Lock.Lock()
+	defer tracker.Lock.Unlock()
+
+	tracker.Boosts[autoscalingGroupName] = append(tracker.Boosts[autoscalingGroupName], time.Now())
+}
+
+// GetBoostLaunchingNode return the moment of the boost that launched a node, looking for boosts done to its ASG
+// shortly before the node was created
+func GetBoostLaunchingNode(tracker *ReplacementRiskTracker, autoscalingGroupName string, nodeCreation time.Time, launchWindow time.Duration) (boost time.Time, found bool) {
+	tracker.Lock.Lock()
+	defer tracker.Lock.Unlock()
+
+	for _, boostTime := range tracker.Boosts[autoscalingGroupName] {
+		if boostTime.Before(nodeCreation) && nodeCreation.Sub(boostTime) <= launchWindow && boostTime.After(boost) {
+			boost = boostTime
+			found = true
+		}
+	}
+
+	return boost, found
+}
+
+// IsBoostBackedOff return true when boosting an ASG is backed off because its replacements are under risk too
+func IsBoostBackedOff(tracker *ReplacementRiskTracker, autoscalingGroupName string) bool {
+	tracker.Lock.Lock()
+	defer tracker.Lock.Unlock()
+
+	return time.Now().Before(tracker.BackoffUntil[autoscalingGroupName])
+}
+
+// RecordReplacementsAtRisk correlate new events with the boosts that launched their nodes, counting those
+// replacement nodes that are under risk too. When they are too many for an ASG, boosting it is backed off
+func RecordReplacementsAtRisk(ctx *Ctx, tracker *ReplacementRiskTracker, eventPool *EventPool, nodePool *NodePool,
+	autoscalingGroupPool *AutoscalingGroupPool) {
+
+	// Look for the nodes involved in events not reviewed yet
+	var newEventsNodes []*v1.Node
+
+	events := eventPool.Snapshot()
+
+	tracker.Lock.Lock()
+	pruneSeenEvents(tracker.SeenEvents, events, *ctx.Flags.ReplacementRiskWindow)
+
+	for _, event := range events {
+		if _, seen := tracker.SeenEvents[string(event.UID)]; seen {
+			continue
+		}
+		tracker.SeenEvents[string(event.UID)] = time.Now()
+
+		if node, found := nodePool.Get(event.InvolvedObject.Name); found {
+			newEventsNodes = append(newEventsNodes, node)
+		}
+	}
+	tracker.Lock.Unlock()
+
+	for _, node := range newEventsNodes {
+		autoscalingGroupName := GetAutoscalingGroupNameByNodeGroup(autoscalingGroupPool, node.Labels[AWSNodeGroupLabel])
+		if autoscalingGroupName == "" {
+			continue
+		}
+
+		boost, found := GetBoostLaunchingNode(tracker, autoscalingGroupName, node.CreationTimestamp.Time, *ctx.Flags.ReplacementLaunchWindow)
+		if !found {
+			continue
+		}
+
+		instanceType := node.Labels[InstanceTypeLabel]
+		ctx.Logger.Infof(ReplacementAtRiskMessage, node.Name, instanceType, autoscalingGroupName, boost.Format(time.RFC3339))
+		mReplacementsAtRiskTotal.WithLabelValues(autoscalingGroupName, instanceType).Inc()
+
+		tracker.Lock.Lock()
+		tracker.Occurrences[autoscalingGroupName] = append(tracker.Occurrences[autoscalingGroupName], time.Now())
+		if tracker.InstanceTypes[autoscalingGroupName] == nil {
+			tracker.InstanceTypes[autoscalingGroupName] = map[string]int{}
+		}
+		tracker.InstanceTypes[autoscalingGroupName][instanceType]++
+		tracker.Lock.Unlock()
+	}
+
+	EvaluateReplacementsAtRisk(ctx, tracker)
+}
+
+// EvaluateReplacementsAtRisk back off boosting those ASGs with too many replacements under risk in the window,
+// and release those whose back off is finished
+func EvaluateReplacementsAtRisk(ctx *Ctx, tracker *ReplacementRiskTracker) {
+
+	var alerts = map[string]string{}
+	var releases []string
+
+	tracker.Lock.Lock()
+
+	// Release those ASGs whose back off is finished
+	for autoscalingGroupName, backoffUntil := range tracker.BackoffUntil {
+		if time.Now().After(backoffUntil) {
+			delete(tracker.BackoffUntil, autoscalingGroupName)
+			releases = append(releases, autoscalingGroupName)
+		}
+	}
+
+	// Forget the boosts too old to have launched a node still counted in the window
+	for autoscalingGroupName, boosts := range tracker.Boosts {
+		tracker.Boosts[autoscalingGroupName] = pruneTimes(boosts, *ctx.Flags.ReplacementLaunchWindow+*ctx.Flags.ReplacementRiskWindow)
+		if len(tracker.Boosts[autoscalingGroupName]) == 0 {
+			delete(tracker.Boosts, autoscalingGroupName)
+		}
+	}
+
+	for autoscalingGroupName, occurrences := range tracker.Occurrences {
+		occurrences = pruneTimes(occurrences, *ctx.Flags.ReplacementRiskWindow)
+		tracker.Occurrences[autoscalingGroupName] = occurrences
+
+		_, backedOff := tracker.BackoffUntil[autoscalingGroupName]
+		if backedOff || *ctx.Flags.MaxReplacementsAtRisk <= 0 || len(occurrences) <= *ctx.Flags.MaxReplacementsAtRisk {
+			continue
+		}
+
+		tracker.BackoffUntil[autoscalingGroupName] = time.Now().Add(*ctx.Flags.ReplacementRiskBackoff)
+
+		instanceTypes := maps.Keys(tracker.InstanceTypes[autoscalingGroupName])
+		sort.Strings(instanceTypes)
+
+		alerts[autoscalingGroupName] = fmt.Sprintf(ReplacementBackoffStartedMessage, len(occurrences),
+			autoscalingGroupName, *ctx.Flags.ReplacementRiskWindow, *ctx.Flags.ReplacementRiskBackoff, instanceTypes)
+
+		// Start counting again after the back off
+		tracker.Occurrences[autoscalingGroupName] = nil
+		tracker.InstanceTypes[autoscalingGroupName] = map[string]int{}
+	}
+	tracker.Lock.Unlock()
+
+	for _, autoscalingGroupName := range releases {
+		ctx.Logger.Infof(ReplacementBackoffFinishedMessage, autoscalingGroupName)
+		mReplacementBackoff.WithLabelValues(autoscalingGroupName).Set(0)
+	}
+
+	for autoscalingGroupName, message := range alerts {
+		ctx.Logger.Warn(message)
+		mReplacementBackoff.WithLabelValues(autoscalingGroupName).Set(1)
+
+		RecordAutoscalingGroupEvent(ctx, autoscalingGroupName, v1.EventTypeWarning, ReplacementsAtRiskReason, "%s", message)
+	}
+}
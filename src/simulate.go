@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go.uber.org/zap"
+	"golang.org/x/exp/maps"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/utils/strings/slices"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	// SimulateCommand is the name of the subcommand to replay snapshots offline
+	SimulateCommand = "simulate"
+
+	// Output formats of the simulation
+	SimulationOutputTable = "table"
+	SimulationOutputJSON  = "json"
+
+	// Decisions taken for the ASGs on each tick
+	SimulationDecisionBoost = "boost"
+	SimulationDecisionSkip  = "skip"
+
+	// Error messages
+	SimulationSnapshotFlagErrorMessage = "the flag --snapshot is required"
+	SimulationOutputFlagErrorMessage   = "unsupported output format '%s': use table or json"
+	SimulationErrorMessage             = "simulation failed: %v"
+)
+
+// SimulationBoost represents the decision taken for an ASG on a tick of the simulation
+type SimulationBoost struct {
+	AutoscalingGroup string `json:"autoscalingGroup"`
+	Nodegroup        string `json:"nodegroup"`
+	Ready            int    `json:"ready"`
+	Events           int    `json:"events"`
+	Current          int    `json:"current"`
+	Calculated       int    `json:"calculated"`
+	Budgeted         int    `json:"budgeted"`
+	Target           int    `json:"target"`
+	Max              int    `json:"max"`
+	Decision         string `json:"decision"`
+	Reason           string `json:"reason,omitempty"`
+}
+
+// SimulationDrain represents a node that would be drained on a tick of the simulation
+type SimulationDrain struct {
+	Nodegroup       string `json:"nodegroup"`
+	Node            string `json:"node"`
+	ReplacementNode string `json:"replacementNode"`
+}
+
+// SimulationTick represents the decisions taken for one snapshot
+type SimulationTick struct {
+	Tick      int               `json:"tick"`
+	Timestamp time.Time         `json:"timestamp"`
+	Boosts    []SimulationBoost `json:"boosts"`
+	Drains    []SimulationDrain `json:"drains"`
+}
+
+// SimulateSnapshot run the calculations of the controller against a snapshot, returning the decisions it would take.
+// Each snapshot is simulated on its own, so the state kept between loops (circuit breaker, drained nodes, etc.) is not
+// considered, neither the topology of the ASGs nor their current capacity on AWS (the one known by Cluster Autoscaler is used)
+func SimulateSnapshot(ctx *Ctx, snapshot Snapshot, instancePrices map[string]float64) (simulationTick SimulationTick, err error) {
+
+	simulationTick.Timestamp = snapshot.Timestamp
+
+	// Work on a copy, as the snapshot is modified
+	nodes := make([]v1.Node, 0, len(snapshot.Nodes))
+	for _, node := range snapshot.Nodes {
+		nodes = append(nodes, *node.DeepCopy())
+	}
+	events := make([]v1.Event, 0, len(snapshot.Events))
+	for _, event := range snapshot.Events {
+		events = append(events, *event.DeepCopy())
+	}
+	snapshot.Nodes, snapshot.Events = nodes, events
+
+	// Move the snapshot to the present, as the calculations compare its moments with the current time
+	ShiftSnapshotTimes(&snapshot, time.Since(snapshot.Timestamp))
+
+	nodePool, eventPool, autoscalingGroupPool, err := NewPoolsFromSnapshot(&snapshot)
+	if err != nil {
+		return simulationTick, err
+	}
+
+	// Calculate the capacity of the ASGs as the controller does
+	aggregate := NewNodegroupsAggregate(eventPool, nodePool, *ctx.Flags.MaxTimeConsiderNewNodes, true)
+	asgsCalculatedCapacity, err := CalculateDesiredCapacityASGs(autoscalingGroupPool, aggregate.EventsCount)
+	if err != nil {
+		return simulationTick, err
+	}
+	asgsBudgetedCapacity, trimmedNodes := ApplyBoostBudgets(ctx, nil, autoscalingGroupPool, nodePool, instancePrices, asgsCalculatedCapacity)
+
+	asgsMaxCapacity, _ := GetAutoscalingGroupsMaxCapacity(autoscalingGroupPool)
+
+	ignoredAsgs := strings.Split(*ctx.Flags.IgnoredAutoscalingGroups, ",")
+	ignoredAsgs = slices.Filter(nil, ignoredAsgs, func(s string) bool { return s != "" })
+
+	asgNames := maps.Keys(asgsCalculatedCapacity)
+	sort.Strings(asgNames)
+
+	for _, asgName := range asgNames {
+		simulationBoost := SimulationBoost{
+			AutoscalingGroup: asgName,
+			Calculated:       asgsCalculatedCapacity[asgName],
+			Budgeted:         asgsBudgetedCapacity[asgName],
+			Max:              asgsMaxCapacity[asgName],
+			Decision:         SimulationDecisionSkip,
+		}
+
+		if autoscalingGroup, found := autoscalingGroupPool.Get(asgName); found {
+			simulationBoost.Nodegroup = autoscalingGroup.Tags[AWSAutoscalingGroupsNodeGroupTag]
+			simulationBoost.Ready, _ = strconv.Atoi(autoscalingGroup.Health.Ready)
+			simulationBoost.Current, _ = strconv.Atoi(autoscalingGroup.Health.CloudProviderTarget)
+		}
+		simulationBoost.Events = aggregate.EventsCount[simulationBoost.Nodegroup]
+
+		// Take the same decisions that are taken when setting the desired capacity
+		simulationBoost.Target = simulationBoost.Budgeted + *ctx.Flags.ExtraNodesOverCalculations
+		if simulationBoost.Target > simulationBoost.Max {
+			simulationBoost.Target = simulationBoost.Max
+		}
+
+		switch {
+		case slices.Contains(ignoredAsgs, asgName):
+			simulationBoost.Reason = "ignored"
+		case IsAutoscalingGroupPaused(autoscalingGroupPool, asgName):
+			simulationBoost.Reason = "paused"
+		case simulationBoost.Target <= simulationBoost.Current:
+			simulationBoost.Reason = "not-increasing"
+		default:
+			simulationBoost.Decision = SimulationDecisionBoost
+			if len(trimmedNodes[asgName]) > 0 {
+				simulationBoost.Reason = fmt.Sprintf("trimmed %v", trimmedNodes[asgName])
+			}
+		}
+
+		simulationTick.Boosts = append(simulationTick.Boosts, simulationBoost)
+	}
+
+	// Select the nodes to drain as the controller does
+	drainCandidates := SelectDrainCandidates(ctx, aggregate, nodePool, &TerminationQueue{})
+
+	nodegroupNames := maps.Keys(drainCandidates)
+	sort.Strings(nodegroupNames)
+
+	for _, nodegroupName := range nodegroupNames {
+		for _, drainCandidate := range drainCandidates[nodegroupName] {
+			simulationTick.Drains = append(simulationTick.Drains, SimulationDrain{
+				Nodegroup:       nodegroupName,
+				Node:            drainCandidate.Event.InvolvedObject.Name,
+				ReplacementNode: drainCandidate.ReplacementNode.Name,
+			})
+		}
+	}
+
+	return simulationTick, nil
+}
+
+// printSimulationTable print the decisions of the simulation as human-readable tables
+func printSimulationTable(simulationTicks []SimulationTick) {
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer writer.Flush()
+
+	for _, simulationTick := range simulationTicks {
+		fmt.Fprintf(writer, "TICK %d\t%s\n\n", simulationTick.Tick, simulationTick.Timestamp.Format(time.RFC3339))
+
+		fmt.Fprintln(writer, "ASG\tNODEGROUP\tREADY\tEVENTS\tCURRENT\tCALCULATED\tBUDGETED\tTARGET\tMAX\tDECISION\tREASON")
+		for _, boost := range simulationTick.Boosts {
+			fmt.Fprintf(writer, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%s\t%s\n",
+				boost.AutoscalingGroup, boost.Nodegroup, boost.Ready, boost.Events, boost.Current,
+				boost.Calculated, boost.Budgeted, boost.Target, boost.Max, boost.Decision, boost.Reason)
+		}
+		fmt.Fprintln(writer)
+
+		fmt.Fprintln(writer, "NODEGROUP\tDRAINED NODE\tREPLACEMENT NODE")
+		for _, drain := range simulationTick.Drains {
+			fmt.Fprintf(writer, "%s\t%s\t%s\n", drain.Nodegroup, drain.Node, drain.ReplacementNode)
+		}
+		fmt.Fprintln(writer)
+	}
+}
+
+// RunSimulateCommand replay the snapshots stored in a file or directory through the calculations of the controller,
+// printing the decisions taken on each of them. The flags of the controller are accepted to evaluate configuration changes
+// Usage: aws-spots-booster simulate --snapshot <path> [--output table|json] [controller flags]
+func RunSimulateCommand(args []string) {
+
+	flagSet := flag.NewFlagSet(SimulateCommand, flag.ExitOnError)
+	flags := RegisterControllerFlags(flagSet)
+	snapshotPath := flagSet.String("snapshot", "", "path to a snapshot file, a file with a time series of snapshots, or a directory of them")
+	output := flagSet.String("output", SimulationOutputTable, "format of the decisions: table, json")
+	_ = flagSet.Parse(args)
+
+	if *snapshotPath == "" {
+		fmt.Fprintln(os.Stderr, SimulationSnapshotFlagErrorMessage)
+		os.Exit(2)
+	}
+	if *output != SimulationOutputTable && *output != SimulationOutputJSON {
+		fmt.Fprintf(os.Stderr, SimulationOutputFlagErrorMessage+"\n", *output)
+		os.Exit(2)
+	}
+
+	// Logs are discarded to keep the output clean
+	ctx := &Ctx{
+		Ctx:    context.Background(),
+		Logger: zap.NewNop().Sugar(),
+		Flags:  flags,
+	}
+
+	err := func() error {
+		instancePrices, err := LoadInstancePrices(*flags.InstancePricesFile)
+		if err != nil {
+			return err
+		}
+
+		snapshots, err := LoadSnapshots(*snapshotPath)
+		if err != nil {
+			return err
+		}
+
+		var simulationTicks []SimulationTick
+		for tick, snapshot := range snapshots {
+			simulationTick, err := SimulateSnapshot(ctx, snapshot, instancePrices)
+			if err != nil {
+				return err
+			}
+			simulationTick.Tick = tick
+			simulationTicks = append(simulationTicks, simulationTick)
+		}
+
+		if *output == SimulationOutputJSON {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(simulationTicks)
+		}
+
+		printSimulationTable(simulationTicks)
+		return nil
+	}()
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, SimulationErrorMessage+"\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// SnapshotFormatVersion is the version of the snapshots format understood by this controller
+	SnapshotFormatVersion = 1
+
+	// SnapshotFileExtension is the extension of the files storing the snapshots
+	SnapshotFileExtension = ".json"
+
+	// Error messages
+	SnapshotVersionErrorMessage = "unsupported version '%d' in snapshot '%s': expected '%d'"
+	SnapshotEmptyErrorMessage   = "no snapshots found in '%s'"
+)
+
+// decodeSnapshots return all the snapshots stored in a file. A single snapshot or a stream of them (like JSON lines)
+// can be stored
+func decodeSnapshots(path string) (snapshots []Snapshot, err error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return snapshots, err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	for {
+		var snapshot Snapshot
+		err = decoder.Decode(&snapshot)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return snapshots, fmt.Errorf("%s: %v", path, err)
+		}
+
+		if snapshot.Version != SnapshotFormatVersion {
+			return snapshots, fmt.Errorf(SnapshotVersionErrorMessage, snapshot.Version, path, SnapshotFormatVersion)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	return snapshots, nil
+}
+
+// LoadSnapshots return the snapshots stored in a file, or in all the files of a directory, ordered by timestamp
+func LoadSnapshots(path string) (snapshots []Snapshot, err error) {
+
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return snapshots, err
+	}
+
+	paths := []string{path}
+	if fileInfo.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return snapshots, err
+		}
+
+		paths = []string{}
+		for _, entry := range entries {
+			if !entry.IsDir() && strings.HasSuffix(entry.Name(), SnapshotFileExtension) {
+				paths = append(paths, filepath.Join(path, entry.Name()))
+			}
+		}
+	}
+
+	for _, snapshotPath := range paths {
+		fileSnapshots, err := decodeSnapshots(snapshotPath)
+		if err != nil {
+			return snapshots, err
+		}
+		snapshots = append(snapshots, fileSnapshots...)
+	}
+
+	if len(snapshots) == 0 {
+		return snapshots, fmt.Errorf(SnapshotEmptyErrorMessage, path)
+	}
+
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].Timestamp.Before(snapshots[j].Timestamp)
+	})
+
+	return snapshots, nil
+}
+
+// ShiftSnapshotTimes move all the moments of a snapshot by some duration.
+// Done to replay old snapshots with logic that compares their moments with the current time
+func ShiftSnapshotTimes(snapshot *Snapshot, offset time.Duration) {
+
+	snapshot.Timestamp = snapshot.Timestamp.Add(offset)
+
+	for i := range snapshot.Nodes {
+		node := &snapshot.Nodes[i]
+		node.CreationTimestamp.Time = node.CreationTimestamp.Add(offset)
+
+		for j := range node.Status.Conditions {
+			condition := &node.Status.Conditions[j]
+			condition.LastHeartbeatTime.Time = condition.LastHeartbeatTime.Add(offset)
+			condition.LastTransitionTime.Time = condition.LastTransitionTime.Add(offset)
+		}
+	}
+
+	for i := range snapshot.Events {
+		event := &snapshot.Events[i]
+		event.CreationTimestamp.Time = event.CreationTimestamp.Add(offset)
+		event.FirstTimestamp.Time = event.FirstTimestamp.Add(offset)
+		event.LastTimestamp.Time = event.LastTimestamp.Add(offset)
+	}
+}
+
+// NewPoolsFromSnapshot return the pools filled with the inputs stored in a snapshot, as the watchers would do
+func NewPoolsFromSnapshot(snapshot *Snapshot) (nodePool *NodePool, eventPool *EventPool, autoscalingGroupPool *AutoscalingGroupPool, err error) {
+
+	nodePool = NewNodePool()
+	for i := range snapshot.Nodes {
+		nodePool.Upsert(&snapshot.Nodes[i])
+	}
+
+	eventPool = NewEventPool()
+	for i := range snapshot.Events {
+		eventPool.Upsert(&snapshot.Events[i])
+	}
+
+	autoscalingGroupPool = NewAutoscalingGroupPool()
+	autoscalingGroups, err := GetAutoscalingGroupsObject(
+		ParseAutoscalingGroupsNames(snapshot.ClusterAutoscalerStatus),
+		ParseAutoscalingGroupsHealthArguments(snapshot.ClusterAutoscalerStatus))
+	if err != nil {
+		return nodePool, eventPool, autoscalingGroupPool, err
+	}
+
+	autoscalingGroupPool.SetStatus(snapshot.ClusterAutoscalerStatus, *autoscalingGroups)
+	autoscalingGroupPool.SetTags(snapshot.AutoscalingGroupTags)
+
+	return nodePool, eventPool, autoscalingGroupPool, nil
+}
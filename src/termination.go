@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"go.opentelemetry.io/otel/trace"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"strings"
+	"time"
+)
+
+const (
+	// Instance lifecycle for spot instances, as reported by EC2
+	SpotInstanceLifecycle = "spot"
+
+	// TerminationGivenUpAnnotation marks a drained node whose instance could not be terminated, storing the reason.
+	// Those nodes are not drained again, and keep their events, until the annotation is removed
+	TerminationGivenUpAnnotation = "asbooster.docplanner.com/termination-given-up"
+
+	// Reasons to give up terminating an instance
+	TerminationRefusedReason        = "verification-refused"
+	TerminationDetachedReason       = "instance-detached"
+	TerminationConfirmTimeoutReason = "timeout"
+
+	// Info messages
+	TerminationEnqueuedMessage    = "instance '%s' of the node '%s' enqueued for termination"
+	TerminationRequestedMessage   = "termination requested for the instance '%s' of the node '%s'"
+	TerminationConfirmedMessage   = "termination confirmed for the instance '%s' of the node '%s'"
+	InstanceAlreadyGoneMessage    = "instance '%s' of the node '%s' is already gone, nothing to terminate"
+	TerminationGivenUpLogMessage  = "termination of the instance '%s' of the node '%s' given up (%s), the node is kept drained"
+	NodeTerminationGivenUpMessage = "node '%s' is not drained again: the termination of its instance was given up"
+
+	// Error messages
+	InstanceIdNotFoundErrorMessage       = "impossible to find the instance of the node '%s', termination skipped"
+	InstanceVerificationErrorMessage     = "impossible to verify the instance '%s' before terminating it: %v"
+	InstanceWrongAutoscalingGroupMessage = "instance '%s' belongs to the asg '%s' instead of '%s', termination refused"
+	InstanceNotSpotErrorMessage          = "instance '%s' is not a spot instance, termination refused"
+	TerminationConfirmTimeoutMessage     = "termination of the instance '%s' not confirmed after %s, giving it up"
+	TerminationGivenUpErrorMessage       = "impossible to annotate the node '%s' whose termination was given up: %v"
+)
+
+// GetInstanceIdFromProviderID return the instance ID from a node's providerID
+// Example of field: providerID: aws:///eu-central-1a/i-042377dc1ee1257a1
+func GetInstanceIdFromProviderID(providerID string) string {
+	providerIDSubstrings := strings.Split(providerID, "/")
+	return providerIDSubstrings[len(providerIDSubstrings)-1]
+}
+
+// EnqueueTermination add a termination request to the queue, unless the node is already in it
+func EnqueueTermination(terminationQueue *TerminationQueue, request *TerminationRequest) bool {
+	terminationQueue.Lock.Lock()
+	defer terminationQueue.Lock.Unlock()
+
+	for _, storedRequest := range terminationQueue.Requests {
+		if storedRequest.NodeName == request.NodeName {
+			return false
+		}
+	}
+
+	request.EnqueuedAt = time.Now()
+	terminationQueue.Requests = append(terminationQueue.Requests, request)
+	return true
+}
+
+// IsNodeEnqueuedForTermination return true when the node is waiting for its instance to be terminated
+func IsNodeEnqueuedForTermination(terminationQueue *TerminationQueue, nodeName string) bool {
+	terminationQueue.Lock.Lock()
+	defer terminationQueue.Lock.Unlock()
+
+	for _, storedRequest := range terminationQueue.Requests {
+		if storedRequest.NodeName == nodeName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsNodeTerminationGivenUp return true when a node is annotated because the termination of its instance was given up
+func IsNodeTerminationGivenUp(nodePool *NodePool, nodeName string) bool {
+
+	node, found := nodePool.Get(nodeName)
+	if !found {
+		return false
+	}
+
+	_, givenUp := node.Annotations[TerminationGivenUpAnnotation]
+	return givenUp
+}
+
+// dequeueTermination remove a termination request from the queue
+func dequeueTermination(terminationQueue *TerminationQueue, request *TerminationRequest) {
+	terminationQueue.Lock.Lock()
+	defer terminationQueue.Lock.Unlock()
+
+	for storedRequestIndex, storedRequest := range terminationQueue.Requests {
+		if storedRequest == request {
+			terminationQueue.Requests = append(terminationQueue.Requests[:storedRequestIndex], terminationQueue.Requests[storedRequestIndex+1:]...)
+			break
+		}
+	}
+}
+
+// VerifyInstanceForTermination check that an instance still belongs to the expected ASG and is a spot instance
+// Returns whether the instance still exists, and whether it can be terminated
+func VerifyInstanceForTermination(ctx *Ctx, awsClient *AwsClient, request *TerminationRequest) (exists bool, allowed bool, err error) {
+
+	autoscalingInstance, err := AwsDescribeAutoScalingInstance(awsClient, request.InstanceId)
+	if err != nil {
+		return false, false, err
+	}
+
+	// Instance is not managed by any ASG: already terminated
+	if autoscalingInstance == nil {
+		return false, false, nil
+	}
+
+	if request.AutoscalingGroupName != "" && *autoscalingInstance.AutoScalingGroupName != request.AutoscalingGroupName {
+		ctx.Logger.Infof(InstanceWrongAutoscalingGroupMessage,
+			request.InstanceId, *autoscalingInstance.AutoScalingGroupName, request.AutoscalingGroupName)
+		return true, false, nil
+	}
+
+	instance, err := AwsDescribeInstance(awsClient, request.InstanceId)
+	if err != nil {
+		return true, false, err
+	}
+
+	if instance == nil {
+		return false, false, nil
+	}
+
+	if aws.StringValue(instance.InstanceLifecycle) != SpotInstanceLifecycle {
+		ctx.Logger.Infof(InstanceNotSpotErrorMessage, request.InstanceId)
+		return true, false, nil
+	}
+
+	return true, true, nil
+}
+
+// IsInstanceTerminated return true when AWS reports the instance as shutting-down, terminated or gone
+func IsInstanceTerminated(awsClient *AwsClient, instanceId string) (bool, error) {
+
+	instance, err := AwsDescribeInstance(awsClient, instanceId)
+	if err != nil {
+		return false, err
+	}
+
+	if instance == nil || instance.State == nil {
+		return true, nil
+	}
+
+	switch aws.StringValue(instance.State.Name) {
+	case ec2.InstanceStateNameShuttingDown, ec2.InstanceStateNameTerminated:
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// getTerminationAuditRecord return an audit record filled with the data of a termination request
+func getTerminationAuditRecord(request *TerminationRequest, action string, reason string, err error) AuditRecord {
+	return AuditRecord{
+		Action:           action,
+		AutoscalingGroup: request.AutoscalingGroupName,
+		Node:             request.NodeName,
+		Instance:         request.InstanceId,
+		Event:            request.Event.Namespace + "/" + request.Event.Name,
+		Outcome:          GetAuditOutcome(err),
+		Reason:           reason,
+		Error:            GetAuditErrorString(err),
+	}
+}
+
+// completeTermination notify the termination and delete the event related to it from Kubernetes
+func completeTermination(ctx *Ctx, client kubernetes.Interface, request *TerminationRequest) {
+
+	// Notify external services about the termination
+	RunPostDrainWebhook(ctx, request.WebhookPayload)
+
+	err := KubernetesDeleteEvent(client, request.Event.Namespace, request.Event.Name)
+	if err != nil && !errors.IsNotFound(err) {
+		ctx.Logger.Infof(EventNotDeletedErrorMessage, err)
+	}
+
+	WriteAuditRecord(ctx, getTerminationAuditRecord(request, AuditActionEventDeleted, "", err))
+}
+
+// giveUpTermination leave the node of an instance that can't be terminated drained, without completing the termination:
+// its event is kept, and the node is annotated so it is not drained again
+func giveUpTermination(ctx *Ctx, client kubernetes.Interface, request *TerminationRequest, reason string) {
+
+	request.GivenUpReason = reason
+	ctx.Logger.Infof(TerminationGivenUpLogMessage, request.InstanceId, request.NodeName, reason)
+	RecordNodeEvent(ctx, request.NodeName, v1.EventTypeWarning, TerminationGivenUpReason, TerminationGivenUpEventMessage,
+		request.InstanceId, reason, TerminationGivenUpAnnotation)
+
+	node, err := client.CoreV1().Nodes().Get(context.TODO(), request.NodeName, metav1.GetOptions{})
+	if err == nil {
+		err = KubernetesAnnotateNode(client, node, map[string]string{TerminationGivenUpAnnotation: reason})
+	}
+	if err != nil && !errors.IsNotFound(err) {
+		ctx.Logger.Infof(TerminationGivenUpErrorMessage, request.NodeName, err)
+	}
+}
+
+// ProcessTerminationQueue terminate the instances enqueued by the drain process in a rate limited way,
+// confirming their termination on AWS before notifying it and deleting their events from Kubernetes.
+// Instances that can't be terminated, or whose termination is never confirmed, are given up
+// This function must be executed as a go routine
+func ProcessTerminationQueue(ctx *Ctx, client kubernetes.Interface, awsClient *AwsClient, terminationQueue *TerminationQueue) {
+
+	// Zero or negative rates mean no limit
+	var timeBetweenTerminations time.Duration
+	if *ctx.Flags.TerminationsPerMinute > 0 {
+		timeBetweenTerminations = time.Minute / time.Duration(*ctx.Flags.TerminationsPerMinute)
+	}
+	var lastTermination time.Time
+
+	for {
+		// Copy the requests to avoid blocking the queue while calling AWS
+		terminationQueue.Lock.Lock()
+		requests := make([]*TerminationRequest, len(terminationQueue.Requests))
+		copy(requests, terminationQueue.Requests)
+		terminationQueue.Lock.Unlock()
+
+		for _, request := range requests {
+
+			// Already requested, check whether AWS confirmed it
+			if !request.TerminatedAt.IsZero() {
+				terminated, err := IsInstanceTerminated(awsClient, request.InstanceId)
+				if err != nil {
+					ctx.Logger.Infof(InstanceVerificationErrorMessage, request.InstanceId, err)
+					continue
+				}
+
+				if terminated {
+					ctx.Logger.Infof(TerminationConfirmedMessage, request.InstanceId, request.NodeName)
+					WriteAuditRecord(ctx, getTerminationAuditRecord(request, AuditActionTerminationConfirmed, "", nil))
+					RecordNodeEvent(ctx, request.NodeName, v1.EventTypeNormal, TerminatedReason, TerminatedEventMessage, request.InstanceId)
+					mEventToTerminationSeconds.WithLabelValues(request.AutoscalingGroupName).
+						Observe(time.Since(request.Event.CreationTimestamp.Time).Seconds())
+					EndSpan(request.TerminationSpan, nil)
+					completeTermination(ctx, client, request)
+					dequeueTermination(terminationQueue, request)
+					continue
+				}
+
+				if time.Since(request.TerminatedAt) > *ctx.Flags.TerminationConfirmTimeout {
+					ctx.Logger.Infof(TerminationConfirmTimeoutMessage, request.InstanceId, *ctx.Flags.TerminationConfirmTimeout)
+					err = fmt.Errorf(TerminationConfirmTimeoutMessage, request.InstanceId, *ctx.Flags.TerminationConfirmTimeout)
+					WriteAuditRecord(ctx, getTerminationAuditRecord(request, AuditActionTerminationConfirmed, TerminationConfirmTimeoutReason, err))
+					EndSpan(request.TerminationSpan, err)
+					giveUpTermination(ctx, client, request, TerminationConfirmTimeoutReason)
+					dequeueTermination(terminationQueue, request)
+				}
+				continue
+			}
+
+			// Respect the rate limit for terminations
+			if time.Since(lastTermination) < timeBetweenTerminations {
+				continue
+			}
+
+			exists, allowed, err := VerifyInstanceForTermination(ctx, awsClient, request)
+			if err != nil {
+				ctx.Logger.Infof(InstanceVerificationErrorMessage, request.InstanceId, err)
+				continue
+			}
+
+			// Out of the ASG: nothing to terminate when AWS confirms the instance is gone.
+			// Otherwise it was detached, and it is not safe to terminate it
+			if !exists {
+				terminated, err := IsInstanceTerminated(awsClient, request.InstanceId)
+				if err != nil {
+					ctx.Logger.Infof(InstanceVerificationErrorMessage, request.InstanceId, err)
+					continue
+				}
+
+				if terminated {
+					ctx.Logger.Infof(InstanceAlreadyGoneMessage, request.InstanceId, request.NodeName)
+					WriteAuditRecord(ctx, getTerminationAuditRecord(request, AuditActionTerminationSkipped, "instance-gone", nil))
+					completeTermination(ctx, client, request)
+				} else {
+					WriteAuditRecord(ctx, getTerminationAuditRecord(request, AuditActionTerminationSkipped, TerminationDetachedReason, nil))
+					giveUpTermination(ctx, client, request, TerminationDetachedReason)
+				}
+				dequeueTermination(terminationQueue, request)
+				continue
+			}
+
+			// Not safe to terminate it: keep the node drained with its event
+			if !allowed {
+				WriteAuditRecord(ctx, getTerminationAuditRecord(request, AuditActionTerminationSkipped, TerminationRefusedReason, nil))
+				giveUpTermination(ctx, client, request, TerminationRefusedReason)
+				dequeueTermination(terminationQueue, request)
+				continue
+			}
+
+			// Trace the termination on the node's trace until AWS confirms it
+			terminationCtx, terminationSpan := StartSpan(GetNodeTraceContext(ctx.Traces, request.NodeName), TerminationSpanName,
+				trace.WithAttributes(
+					NodeAttribute.String(request.NodeName),
+					InstanceAttribute.String(request.InstanceId),
+					AutoscalingGroupAttribute.String(request.AutoscalingGroupName),
+				))
+
+			lastTermination = time.Now()
+			err = AwsTerminateInstance(terminationCtx, awsClient, request.InstanceId)
+			WriteAuditRecord(ctx, getTerminationAuditRecord(request, AuditActionTerminationRequested, "", err))
+			if err != nil {
+				ctx.Logger.Infof(InstanceNotFoundErrorMessage, request.InstanceId, err)
+				EndSpan(terminationSpan, err)
+				continue
+			}
+			request.TerminationSpan = terminationSpan
+
+			ctx.Logger.Infof(TerminationRequestedMessage, request.InstanceId, request.NodeName)
+			request.TerminatedAt = time.Now()
+		}
+
+		time.Sleep(WatchersLoopTime)
+	}
+}
+
+// NewTerminationRequest craft a termination request for the node involved in an event, looking for it in the pool
+func NewTerminationRequest(nodePool *NodePool, autoscalingGroupPool *AutoscalingGroupPool, event *v1.Event) *TerminationRequest {
+
+	request := &TerminationRequest{
+		NodeName: event.InvolvedObject.Name,
+		Event:    event.DeepCopy(),
+	}
+
+	var nodegroupName string
+	if node, found := nodePool.Get(event.InvolvedObject.Name); found {
+		request.InstanceId = GetInstanceIdFromProviderID(node.Spec.ProviderID)
+		nodegroupName = node.Labels[AWSNodeGroupLabel]
+	}
+
+	if nodegroupName != "" {
+		request.AutoscalingGroupName = GetAutoscalingGroupNameByNodeGroup(autoscalingGroupPool, nodegroupName)
+	}
+
+	request.WebhookPayload = &WebhookPayload{
+		Node:      request.NodeName,
+		Instance:  request.InstanceId,
+		Nodegroup: nodegroupName,
+		Reason:    event.Reason,
+	}
+
+	return request
+}
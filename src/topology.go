@@ -0,0 +1,163 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"strings"
+	"time"
+)
+
+const (
+	// Classifications for the topology of the ASGs
+	TopologyMultiAZ     = "multi-az"
+	TopologySingleAZ    = "single-az"
+	TopologyAZImbalance = "az-imbalanced"
+	TopologyUnknown     = "unknown"
+
+	// AllowUnbalancedTag is the ASG tag to allow boosting a group even when its topology is not multi-az
+	AllowUnbalancedTag      = "asbooster.docplanner.com/allow-unbalanced"
+	AllowUnbalancedTagValue = "true"
+
+	// ASGTopologySecondsBetweenSynchronizations is the time between topology reviews of the ASGs
+	ASGTopologySecondsBetweenSynchronizations = 60
+
+	// Info messages
+	TopologyClassifiedMessage       = "asg '%s' classified as '%s': zones %v, instances by zone %v, capacity-rebalance %t"
+	TopologySkippedBoostMessage     = "skipping changes for asg '%s': its topology is '%s' (hint: tag it with '%s=%s' to force it)"
+	CapacityRebalanceEnabledWarning = "asg '%s' has capacity-rebalance enabled, aws will also launch replacements on rebalance recommendations"
+
+	// Error messages
+	TopologyRetrieveErrorMessage = "impossible to get the topology of the asgs from aws: %v"
+)
+
+// GetAutoscalingGroupTopology return the topology of an ASG, classified according to its zones and instances
+func GetAutoscalingGroupTopology(autoscalingGroup *autoscaling.Group, imbalanceTolerance int) (topology AutoscalingGroupTopology) {
+
+	topology.AvailabilityZones = aws.StringValueSlice(autoscalingGroup.AvailabilityZones)
+	topology.CapacityRebalance = aws.BoolValue(autoscalingGroup.CapacityRebalance)
+	topology.InstancesByZone = map[string]int{}
+
+	vpcZoneIdentifier := aws.StringValue(autoscalingGroup.VPCZoneIdentifier)
+	if vpcZoneIdentifier != "" {
+		topology.Subnets = strings.Split(vpcZoneIdentifier, ",")
+	}
+
+	// Count healthy instances on each zone, including empty zones
+	for _, zone := range topology.AvailabilityZones {
+		topology.InstancesByZone[zone] = 0
+	}
+	for _, instance := range autoscalingGroup.Instances {
+		if aws.StringValue(instance.LifecycleState) != autoscaling.LifecycleStateInService {
+			continue
+		}
+		topology.InstancesByZone[aws.StringValue(instance.AvailabilityZone)]++
+	}
+
+	if len(topology.AvailabilityZones) < 2 {
+		topology.Classification = TopologySingleAZ
+		return topology
+	}
+
+	// Compare the most and the least populated zones
+	minInstances, maxInstances := -1, 0
+	for _, instances := range topology.InstancesByZone {
+		if minInstances == -1 || instances < minInstances {
+			minInstances = instances
+		}
+		if instances > maxInstances {
+			maxInstances = instances
+		}
+	}
+
+	topology.Classification = TopologyMultiAZ
+	if maxInstances-minInstances > imbalanceTolerance {
+		topology.Classification = TopologyAZImbalance
+	}
+
+	return topology
+}
+
+// IsTopologyBoostable return true when an ASG can be boosted according to its topology.
+// Groups can be forced by the flags, or tagging them with AllowUnbalancedTag
+func IsTopologyBoostable(ctx *Ctx, autoscalingGroup *AutoscalingGroup) bool {
+
+	if *ctx.Flags.AllowUnbalancedASGs || autoscalingGroup.Tags[AllowUnbalancedTag] == AllowUnbalancedTagValue {
+		return true
+	}
+
+	// Groups not reviewed yet, or missing from AWS, are not safe until they are classified as multi-az
+	return autoscalingGroup.Topology.Classification == TopologyMultiAZ
+}
+
+// GetTopologyClassification return the classification of an ASG, being unknown until its topology is reviewed
+func GetTopologyClassification(autoscalingGroup *AutoscalingGroup) string {
+	if autoscalingGroup.Topology.Classification == "" {
+		return TopologyUnknown
+	}
+	return autoscalingGroup.Topology.Classification
+}
+
+// WatchAutoScalingGroupsTopology review the availability zones of the ASGs periodically and classify them
+// This function must be executed as a go routine
+func WatchAutoScalingGroupsTopology(ctx *Ctx, awsClient *AwsClient, autoscalingGroupPool *AutoscalingGroupPool) {
+
+	for {
+		autoscalingGroupNames := GetAutoscalingGroupsNames(autoscalingGroupPool)
+		if len(autoscalingGroupNames) == 0 {
+			time.Sleep(ASGWatcherSecondsBetweenTries * time.Second)
+			continue
+		}
+
+		// Topologies can not be trusted when AWS fails, so all the ASGs are classified as unknown
+		topologies := map[string]AutoscalingGroupTopology{}
+		autoscalingGroups, err := AwsDescribeAutoScalingGroups(awsClient, autoscalingGroupNames)
+		if err != nil {
+			ctx.Logger.Infof(TopologyRetrieveErrorMessage, err)
+		}
+
+		for _, autoscalingGroup := range autoscalingGroups {
+			topology := GetAutoscalingGroupTopology(autoscalingGroup, *ctx.Flags.AZImbalanceTolerance)
+			topologies[aws.StringValue(autoscalingGroup.AutoScalingGroupName)] = topology
+		}
+
+		// Store the topologies into the actual ASGs object
+		autoscalingGroupPool.SetTopologies(topologies)
+
+		for _, asgName := range autoscalingGroupNames {
+			topology, found := topologies[asgName]
+			if !found {
+				topology.Classification = TopologyUnknown
+			}
+
+			ctx.Logger.Infof(TopologyClassifiedMessage, asgName, topology.Classification,
+				topology.AvailabilityZones, topology.InstancesByZone, topology.CapacityRebalance)
+
+			if topology.CapacityRebalance {
+				ctx.Logger.Warnf(CapacityRebalanceEnabledWarning, asgName)
+			}
+
+			// Update the metrics for this ASG
+			for _, classification := range []string{TopologyMultiAZ, TopologySingleAZ, TopologyAZImbalance, TopologyUnknown} {
+				var value float64
+				if classification == topology.Classification {
+					value = 1
+				}
+				mAutoscalingGroupTopology.WithLabelValues(asgName, classification).Set(value)
+			}
+
+			var capacityRebalance float64
+			if topology.CapacityRebalance {
+				capacityRebalance = 1
+			}
+			mAutoscalingGroupCapacityRebalance.WithLabelValues(asgName).Set(capacityRebalance)
+			mAutoscalingGroupAvailabilityZones.WithLabelValues(asgName).Set(float64(len(topology.AvailabilityZones)))
+		}
+
+		if err != nil {
+			time.Sleep(ASGWatcherSecondsBetweenTries * time.Second)
+			continue
+		}
+
+		time.Sleep(ASGTopologySecondsBetweenSynchronizations * time.Second)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"testing"
+)
+
+// newTestAutoscalingGroup return an ASG spread over some zones, with a number of healthy instances on each one
+func newTestAutoscalingGroup(instancesByZone map[string]int) *autoscaling.Group {
+	autoscalingGroup := &autoscaling.Group{AutoScalingGroupName: aws.String("eks-spot")}
+	for zone, instances := range instancesByZone {
+		autoscalingGroup.AvailabilityZones = append(autoscalingGroup.AvailabilityZones, aws.String(zone))
+		for i := 0; i < instances; i++ {
+			autoscalingGroup.Instances = append(autoscalingGroup.Instances, &autoscaling.Instance{
+				AvailabilityZone: aws.String(zone),
+				LifecycleState:   aws.String(autoscaling.LifecycleStateInService),
+			})
+		}
+	}
+	return autoscalingGroup
+}
+
+func TestGetAutoscalingGroupTopologyClassification(t *testing.T) {
+	scenarios := map[string]struct {
+		instancesByZone map[string]int
+		expected        string
+	}{
+		"single zone":                  {map[string]int{"eu-west-1a": 3}, TopologySingleAZ},
+		"balanced zones":               {map[string]int{"eu-west-1a": 3, "eu-west-1b": 3, "eu-west-1c": 2}, TopologyMultiAZ},
+		"imbalance at the tolerance":   {map[string]int{"eu-west-1a": 4, "eu-west-1b": 2}, TopologyMultiAZ},
+		"imbalance over the tolerance": {map[string]int{"eu-west-1a": 5, "eu-west-1b": 2}, TopologyAZImbalance},
+		"empty zone over the tolerance": {map[string]int{"eu-west-1a": 3, "eu-west-1b": 3, "eu-west-1c": 0},
+			TopologyAZImbalance},
+	}
+
+	for name, scenario := range scenarios {
+		topology := GetAutoscalingGroupTopology(newTestAutoscalingGroup(scenario.instancesByZone), 2)
+		if topology.Classification != scenario.expected {
+			t.Errorf("%s: expected '%s', got '%s' for instances by zone %v",
+				name, scenario.expected, topology.Classification, topology.InstancesByZone)
+		}
+	}
+}
+
+func TestGetAutoscalingGroupTopologyIgnoresUnhealthyInstances(t *testing.T) {
+	autoscalingGroup := newTestAutoscalingGroup(map[string]int{"eu-west-1a": 2, "eu-west-1b": 2})
+	for i := 0; i < 3; i++ {
+		autoscalingGroup.Instances = append(autoscalingGroup.Instances, &autoscaling.Instance{
+			AvailabilityZone: aws.String("eu-west-1a"),
+			LifecycleState:   aws.String(autoscaling.LifecycleStateTerminating),
+		})
+	}
+
+	topology := GetAutoscalingGroupTopology(autoscalingGroup, 0)
+	if topology.Classification != TopologyMultiAZ || topology.InstancesByZone["eu-west-1a"] != 2 {
+		t.Errorf("expected only the healthy instances to be counted, got '%s' for instances by zone %v",
+			topology.Classification, topology.InstancesByZone)
+	}
+}
+
+func TestIsTopologyBoostable(t *testing.T) {
+	h := NewHarness(t)
+
+	for classification, expected := range map[string]bool{
+		TopologyMultiAZ:     true,
+		TopologySingleAZ:    false,
+		TopologyAZImbalance: false,
+		TopologyUnknown:     false,
+		"":                  false,
+	} {
+		autoscalingGroup := &AutoscalingGroup{Name: "eks-spot", Topology: AutoscalingGroupTopology{Classification: classification}}
+		if boostable := IsTopologyBoostable(h.Ctx, autoscalingGroup); boostable != expected {
+			t.Errorf("expected topology '%s' to be boostable: %t", classification, expected)
+		}
+
+		// The tag forces any topology, even the unknown ones
+		autoscalingGroup.Tags = map[string]string{AllowUnbalancedTag: AllowUnbalancedTagValue}
+		if !IsTopologyBoostable(h.Ctx, autoscalingGroup) {
+			t.Errorf("expected topology '%s' to be boostable when forced by the tag", classification)
+		}
+	}
+}
+
+func TestIsTopologyBoostableForcedByFlag(t *testing.T) {
+	h := NewHarness(t, "--allow-unbalanced-autoscaling-groups")
+
+	autoscalingGroup := &AutoscalingGroup{Name: "eks-spot", Topology: AutoscalingGroupTopology{Classification: TopologyUnknown}}
+	if !IsTopologyBoostable(h.Ctx, autoscalingGroup) {
+		t.Errorf("expected unknown topology to be boostable when forced by the flag")
+	}
+}
+
+func TestMissingTopologiesAreNotBoostable(t *testing.T) {
+	h := NewHarness(t)
+
+	autoscalingGroupPool := NewAutoscalingGroupPool()
+	autoscalingGroupPool.SetStatus("status", AutoscalingGroups{{Name: "eks-spot"}, {Name: "eks-missing"}})
+
+	// ASGs not reviewed yet are unknown too
+	notReviewed, _ := autoscalingGroupPool.Get("eks-spot")
+	if classification := GetTopologyClassification(notReviewed); classification != TopologyUnknown {
+		t.Errorf("expected asg not reviewed yet to be '%s', got '%s'", TopologyUnknown, classification)
+	}
+
+	autoscalingGroupPool.SetTopologies(map[string]AutoscalingGroupTopology{
+		"eks-spot": {Classification: TopologyMultiAZ},
+	})
+
+	for name, expected := range map[string]bool{"eks-spot": true, "eks-missing": false} {
+		autoscalingGroup, _ := autoscalingGroupPool.Get(name)
+		if boostable := IsTopologyBoostable(h.Ctx, autoscalingGroup); boostable != expected {
+			t.Errorf("expected asg '%s' classified as '%s' to be boostable: %t",
+				name, GetTopologyClassification(autoscalingGroup), expected)
+		}
+	}
+}
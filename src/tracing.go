@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	v1 "k8s.io/api/core/v1"
+	"net/http"
+	"time"
+)
+
+const (
+	// Names of the spans of the lifecycle of a node under risk
+	NodeUnderRiskSpanName       = "node-under-risk"
+	CapacityCalculationSpanName = "capacity-calculation"
+	BoostSpanName               = "boost"
+	DrainSpanName               = "drain"
+	TerminationSpanName         = "termination"
+
+	// Attributes of the spans
+	NodeAttribute             = attribute.Key("asbooster.node")
+	InstanceAttribute         = attribute.Key("asbooster.instance")
+	NodegroupAttribute        = attribute.Key("asbooster.nodegroup")
+	AutoscalingGroupAttribute = attribute.Key("asbooster.autoscaling_group")
+	EventAttribute            = attribute.Key("asbooster.event")
+	CapacityAttribute         = attribute.Key("asbooster.capacity")
+	AwsServiceAttribute       = attribute.Key("aws.service")
+	AwsOperationAttribute     = attribute.Key("aws.operation")
+
+	// Info messages
+	TracingEnabledMessage = "exporting traces via otlp to '%s'"
+)
+
+// SetupTracing install a tracer provider exporting the spans via OTLP to the configured endpoint.
+// When no endpoint is configured, the default no-op provider is kept. Returns a function to flush the spans on exit
+func SetupTracing(ctx *Ctx) (shutdown func(context.Context) error, err error) {
+
+	shutdown = func(context.Context) error { return nil }
+
+	if *ctx.Flags.TracingOTLPEndpoint == "" {
+		return shutdown, nil
+	}
+
+	options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(*ctx.Flags.TracingOTLPEndpoint)}
+	if *ctx.Flags.TracingOTLPInsecure {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+
+	exporter, err := otlptracehttp.New(ctx.Ctx, options...)
+	if err != nil {
+		return shutdown, err
+	}
+
+	ctx.Logger.Infof(TracingEnabledMessage, *ctx.Flags.TracingOTLPEndpoint)
+	tracerProvider := InstallTracerProvider(exporter, *ctx.Flags.TracingSampleRatio)
+
+	return tracerProvider.Shutdown, nil
+}
+
+// InstallTracerProvider set a tracer provider sending the sampled spans to an exporter as the global one.
+// Any exporter can be used, like an in-process one to inspect the spans
+func InstallTracerProvider(exporter sdktrace.SpanExporter, sampleRatio float64) *sdktrace.TracerProvider {
+
+	tracerProvider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(ControllerName))),
+	)
+	otel.SetTracerProvider(tracerProvider)
+
+	return tracerProvider
+}
+
+// StartSpan start a span of the controller as a child of the span carried by the parent context
+func StartSpan(parent context.Context, name string, options ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(ControllerName).Start(parent, name, options...)
+}
+
+// EndSpan end a span, recording the error on it when present
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// NewNodeTracePool return a pool of node traces ready to be used
+func NewNodeTracePool() *NodeTracePool {
+	return &NodeTracePool{
+		Traces: map[string]*NodeTrace{},
+	}
+}
+
+// StartNodeTrace start the trace of the lifecycle of a node under risk, when its event is ingested.
+// Nothing is done when the node is already traced
+func StartNodeTrace(nodeTracePool *NodeTracePool, event *v1.Event) {
+	nodeTracePool.Lock.Lock()
+	defer nodeTracePool.Lock.Unlock()
+
+	if _, found := nodeTracePool.Traces[event.InvolvedObject.Name]; found {
+		return
+	}
+
+	spanCtx, span := StartSpan(context.Background(), NodeUnderRiskSpanName,
+		trace.WithNewRoot(),
+		trace.WithTimestamp(event.CreationTimestamp.Time),
+		trace.WithAttributes(
+			NodeAttribute.String(event.InvolvedObject.Name),
+			EventAttribute.String(event.Namespace+"/"+event.Name),
+		))
+
+	nodeTracePool.Traces[event.InvolvedObject.Name] = &NodeTrace{
+		Ctx:  spanCtx,
+		Span: span,
+	}
+}
+
+// EndNodeTrace end the trace of a node, once its event is gone
+func EndNodeTrace(nodeTracePool *NodeTracePool, nodeName string) {
+	nodeTracePool.Lock.Lock()
+	defer nodeTracePool.Lock.Unlock()
+
+	nodeTrace, found := nodeTracePool.Traces[nodeName]
+	if !found {
+		return
+	}
+
+	nodeTrace.Span.End()
+	delete(nodeTracePool.Traces, nodeName)
+}
+
+// GetNodeTraceContext return the context carrying the trace of a node.
+// When the node is not traced, a context without span is returned
+func GetNodeTraceContext(nodeTracePool *NodeTracePool, nodeName string) context.Context {
+	nodeTracePool.Lock.Lock()
+	defer nodeTracePool.Lock.Unlock()
+
+	nodeTrace, found := nodeTracePool.Traces[nodeName]
+	if !found {
+		return context.Background()
+	}
+
+	return nodeTrace.Ctx
+}
+
+// getNodeNamesByAutoscalingGroup return the names of the nodes grouped by their ASG, looking for them in the pool
+func getNodeNamesByAutoscalingGroup(nodePool *NodePool, autoscalingGroupPool *AutoscalingGroupPool, nodeNames []string) map[string][]string {
+
+	nodeNamesByAutoscalingGroup := map[string][]string{}
+	for _, nodeName := range nodeNames {
+		autoscalingGroupName := GetAutoscalingGroupNameByNodeGroup(autoscalingGroupPool, nodePool.GetNodegroup(nodeName))
+		if autoscalingGroupName == "" {
+			continue
+		}
+		nodeNamesByAutoscalingGroup[autoscalingGroupName] = append(nodeNamesByAutoscalingGroup[autoscalingGroupName], nodeName)
+	}
+
+	return nodeNamesByAutoscalingGroup
+}
+
+// RecordCapacityCalculationSpans add a span to the traces of the nodes included in a capacity calculation for the first time
+func RecordCapacityCalculationSpans(nodeTracePool *NodeTracePool, nodePool *NodePool, autoscalingGroupPool *AutoscalingGroupPool,
+	asgsDesiredCapacity map[string]int, calculationStart time.Time) {
+
+	nodeTracePool.Lock.Lock()
+	var nodeNames []string
+	for nodeName, nodeTrace := range nodeTracePool.Traces {
+		if !nodeTrace.Calculated {
+			nodeNames = append(nodeNames, nodeName)
+		}
+	}
+	nodeTracePool.Lock.Unlock()
+
+	for autoscalingGroupName, asgNodeNames := range getNodeNamesByAutoscalingGroup(nodePool, autoscalingGroupPool, nodeNames) {
+		desiredCapacity, calculated := asgsDesiredCapacity[autoscalingGroupName]
+		if !calculated {
+			continue
+		}
+
+		nodeTracePool.Lock.Lock()
+		for _, nodeName := range asgNodeNames {
+			nodeTrace := nodeTracePool.Traces[nodeName]
+			if nodeTrace == nil {
+				continue
+			}
+			nodeTrace.Calculated = true
+			nodeTrace.AutoscalingGroupName = autoscalingGroupName
+
+			_, span := StartSpan(nodeTrace.Ctx, CapacityCalculationSpanName,
+				trace.WithTimestamp(calculationStart),
+				trace.WithAttributes(
+					AutoscalingGroupAttribute.String(autoscalingGroupName),
+					CapacityAttribute.Int(desiredCapacity),
+				))
+			span.End()
+		}
+		nodeTracePool.Lock.Unlock()
+	}
+}
+
+// StartBoostSpans start a span on the traces of the nodes served by the boost of an ASG for the first time.
+// The returned context carries the first of them, to be used as parent of the AWS calls.
+// The returned function ends all the spans
+func StartBoostSpans(nodeTracePool *NodeTracePool, autoscalingGroupName string, desiredCapacity int) (context.Context, func(error)) {
+	nodeTracePool.Lock.Lock()
+	defer nodeTracePool.Lock.Unlock()
+
+	boostCtx := context.Background()
+	var spans []trace.Span
+
+	for _, nodeTrace := range nodeTracePool.Traces {
+		if nodeTrace.AutoscalingGroupName != autoscalingGroupName || nodeTrace.Boosted {
+			continue
+		}
+		nodeTrace.Boosted = true
+
+		spanCtx, span := StartSpan(nodeTrace.Ctx, BoostSpanName, trace.WithAttributes(
+			AutoscalingGroupAttribute.String(autoscalingGroupName),
+			CapacityAttribute.Int(desiredCapacity),
+		))
+		if len(spans) == 0 {
+			boostCtx = spanCtx
+		}
+		spans = append(spans, span)
+	}
+
+	return boostCtx, func(err error) {
+		for _, span := range spans {
+			EndSpan(span, err)
+		}
+	}
+}
+
+// startAwsApiCallSpan start a span for a call to AWS API, only when it is done inside a trace
+func startAwsApiCallSpan(r *request.Request) {
+	if !trace.SpanContextFromContext(r.Context()).IsValid() {
+		return
+	}
+
+	spanCtx, _ := StartSpan(r.Context(), r.ClientInfo.ServiceName+"."+r.Operation.Name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			AwsServiceAttribute.String(r.ClientInfo.ServiceName),
+			AwsOperationAttribute.String(r.Operation.Name),
+		))
+	r.SetContext(spanCtx)
+}
+
+// endAwsApiCallSpan end the span of a call to AWS API, once the request is complete
+func endAwsApiCallSpan(r *request.Request) {
+	span := trace.SpanFromContext(r.Context())
+	if !span.IsRecording() {
+		return
+	}
+	EndSpan(span, r.Error)
+}
+
+// TracingRoundTripper wraps the transport of the Kubernetes client to create a span for each request
+// done inside a trace
+type TracingRoundTripper struct {
+	Next http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *TracingRoundTripper) RoundTrip(httpRequest *http.Request) (*http.Response, error) {
+	if !trace.SpanContextFromContext(httpRequest.Context()).IsValid() {
+		return t.Next.RoundTrip(httpRequest)
+	}
+
+	spanCtx, span := StartSpan(httpRequest.Context(), "kubernetes "+httpRequest.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPMethodKey.String(httpRequest.Method),
+			semconv.HTTPURLKey.String(httpRequest.URL.Path),
+		))
+
+	httpResponse, err := t.Next.RoundTrip(httpRequest.WithContext(spanCtx))
+	if err == nil {
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(httpResponse.StatusCode))
+		if httpResponse.StatusCode >= http.StatusBadRequest {
+			span.SetStatus(codes.Error, httpResponse.Status)
+		}
+	}
+	EndSpan(span, err)
+
+	return httpResponse, err
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// installTestTracerProvider install a tracer provider keeping the spans in memory for the duration of a test.
+// The previous provider is restored once the test is done
+func installTestTracerProvider(t *testing.T) (*sdktrace.TracerProvider, *tracetest.InMemoryExporter) {
+	previousTracerProvider := otel.GetTracerProvider()
+
+	exporter := tracetest.NewInMemoryExporter()
+	tracerProvider := InstallTracerProvider(exporter, 1)
+
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previousTracerProvider)
+		tracerProvider.Shutdown(context.Background())
+	})
+
+	return tracerProvider, exporter
+}
+
+// getFlushedSpans return the spans ended so far
+func getFlushedSpans(t *testing.T, tracerProvider *sdktrace.TracerProvider, exporter *tracetest.InMemoryExporter) tracetest.SpanStubs {
+	err := tracerProvider.ForceFlush(context.Background())
+	if err != nil {
+		t.Fatalf("impossible to flush the spans: %v", err)
+	}
+	return exporter.GetSpans()
+}
+
+// getNodeTraceRoots return the root spans of the traces of a node
+func getNodeTraceRoots(spans tracetest.SpanStubs, nodeName string) (roots tracetest.SpanStubs) {
+	for _, span := range spans {
+		if span.Name != NodeUnderRiskSpanName || span.Parent.IsValid() {
+			continue
+		}
+		for _, attribute := range span.Attributes {
+			if attribute.Key == NodeAttribute && attribute.Value.AsString() == nodeName {
+				roots = append(roots, span)
+			}
+		}
+	}
+	return roots
+}
+
+// Not parallel, as the tracer provider is global
+func TestNodeUnderRiskTrace(t *testing.T) {
+	tracerProvider, exporter := installTestTracerProvider(t)
+
+	h := NewHarness(t, "--terminations-per-minute", "0")
+	h.Aws.AddAutoscalingGroup("eks-traced", "traced", 1, 10)
+	h.Start()
+
+	for _, nodeName := range []string{"traced-node-1", "traced-node-2", "traced-node-3"} {
+		h.AddNode(nodeName, "eks-traced", time.Hour)
+	}
+	h.AddPod("pod-1", "traced-node-1")
+	h.UpdateClusterAutoscalerStatus()
+
+	h.AddRebalanceRecommendation("traced-node-1")
+	h.AddRebalanceRecommendation("traced-node-2")
+
+	h.Eventually("the asg is boosted to 5", func() bool {
+		return h.Aws.GetDesiredCapacity("eks-traced") == 5
+	})
+	h.PauseBoosting()
+
+	h.AddNode("traced-node-4", "eks-traced", time.Minute)
+	h.AddNode("traced-node-5", "eks-traced", time.Minute)
+	h.UpdateClusterAutoscalerStatus()
+
+	h.Eventually("the traces of the nodes under risk are finished", func() bool {
+		spans := getFlushedSpans(t, tracerProvider, exporter)
+		return len(getNodeTraceRoots(spans, "traced-node-1")) > 0 && len(getNodeTraceRoots(spans, "traced-node-2")) > 0
+	})
+
+	spans := getFlushedSpans(t, tracerProvider, exporter)
+	for _, nodeName := range []string{"traced-node-1", "traced-node-2"} {
+		roots := getNodeTraceRoots(spans, nodeName)
+		if len(roots) != 1 {
+			t.Errorf("expected one trace for the node '%s', got %d", nodeName, len(roots))
+			continue
+		}
+		root := roots[0]
+
+		children := map[string]int{}
+		for _, span := range spans {
+			if span.SpanContext.TraceID() == root.SpanContext.TraceID() && span.Parent.SpanID() == root.SpanContext.SpanID() {
+				children[span.Name]++
+			}
+		}
+
+		for _, name := range []string{CapacityCalculationSpanName, BoostSpanName, DrainSpanName, TerminationSpanName} {
+			if children[name] != 1 {
+				t.Errorf("expected one '%s' span on the trace of the node '%s', got %d (spans: %v)", name, nodeName, children[name], children)
+			}
+		}
+	}
+
+	if roots := getNodeTraceRoots(spans, "traced-node-3"); len(roots) != 0 {
+		t.Errorf("expected no trace for the node not under risk, got %d", len(roots))
+	}
+}
+
+// Not parallel, as the tracer provider is global
+func TestAwsApiCallSpans(t *testing.T) {
+	tracerProvider, exporter := installTestTracerProvider(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/xml")
+		w.Write([]byte(`<SetDesiredCapacityResponse><ResponseMetadata><RequestId>request-1</RequestId></ResponseMetadata></SetDesiredCapacityResponse>`))
+	}))
+	defer server.Close()
+
+	awsSession, err := session.NewSession(&aws.Config{
+		Endpoint:    aws.String(server.URL),
+		Region:      aws.String("eu-west-1"),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		MaxRetries:  aws.Int(0),
+	})
+	if err != nil {
+		t.Fatalf("impossible to create the aws session: %v", err)
+	}
+	addAwsApiCallHandlers(&awsSession.Handlers)
+	client := autoscaling.New(awsSession)
+
+	input := &autoscaling.SetDesiredCapacityInput{AutoScalingGroupName: aws.String("eks-spot"), DesiredCapacity: aws.Int64(4)}
+
+	// Calls done outside a trace are not traced
+	_, err = client.SetDesiredCapacityWithContext(context.Background(), input)
+	if err != nil {
+		t.Fatalf("unexpected error calling aws: %v", err)
+	}
+
+	parentCtx, parent := StartSpan(context.Background(), BoostSpanName)
+	_, err = client.SetDesiredCapacityWithContext(parentCtx, input)
+	if err != nil {
+		t.Fatalf("unexpected error calling aws: %v", err)
+	}
+	parent.End()
+
+	spans := getFlushedSpans(t, tracerProvider, exporter)
+	if len(spans) != 2 {
+		t.Fatalf("expected the spans of the boost and its aws call, got %d", len(spans))
+	}
+
+	call := spans[0]
+	if call.Name != "autoscaling.SetDesiredCapacity" || call.SpanKind != trace.SpanKindClient {
+		t.Errorf("unexpected span for the aws call: %s (%s)", call.Name, call.SpanKind)
+	}
+	if call.Parent.SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("the span of the aws call is not a child of the boost")
+	}
+}
+
+// Not parallel, as the tracer provider is global
+func TestKubernetesCallSpans(t *testing.T) {
+	tracerProvider, exporter := installTestTracerProvider(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"apiVersion": "v1", "kind": "Node", "metadata": {"name": "node-1"}}`))
+	}))
+	defer server.Close()
+
+	client, err := kubernetes.NewForConfig(&rest.Config{
+		Host: server.URL,
+		WrapTransport: func(roundTripper http.RoundTripper) http.RoundTripper {
+			return &TracingRoundTripper{Next: roundTripper}
+		},
+	})
+	if err != nil {
+		t.Fatalf("impossible to create the kubernetes client: %v", err)
+	}
+
+	// Calls done outside a trace are not traced
+	_, err = client.CoreV1().Nodes().Get(context.Background(), "node-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error calling kubernetes: %v", err)
+	}
+
+	parentCtx, parent := StartSpan(context.Background(), DrainSpanName)
+	_, err = client.CoreV1().Nodes().Get(parentCtx, "node-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error calling kubernetes: %v", err)
+	}
+	parent.End()
+
+	spans := getFlushedSpans(t, tracerProvider, exporter)
+	if len(spans) != 2 {
+		t.Fatalf("expected the spans of the drain and its kubernetes call, got %d", len(spans))
+	}
+
+	call := spans[0]
+	if call.Name != "kubernetes GET" || call.SpanKind != trace.SpanKindClient {
+		t.Errorf("unexpected span for the kubernetes call: %s (%s)", call.Name, call.SpanKind)
+	}
+	if call.Parent.SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("the span of the kubernetes call is not a child of the drain")
+	}
+
+	statusCode := -1
+	for _, attribute := range call.Attributes {
+		if attribute.Key == semconv.HTTPStatusCodeKey {
+			statusCode = int(attribute.Value.AsInt64())
+		}
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("expected the status code 200 on the span of the kubernetes call, got %d", statusCode)
+	}
+}
@@ -2,8 +2,15 @@ package main
 
 import (
 	"context"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/client-go/util/workqueue"
+	"math/rand"
 	"sync"
 	"time"
 )
@@ -22,34 +29,164 @@ type HealthStatus struct {
 	CloudProviderMaxSize string `json:"maxSize"`             // Maximum number of nodes in the provider
 }
 
+// AutoscalingGroupTopology represents how the instances of an autoscaling group are spread over availability zones
+type AutoscalingGroupTopology struct {
+	AvailabilityZones []string
+	Subnets           []string
+	CapacityRebalance bool
+	InstancesByZone   map[string]int
+	Classification    string
+}
+
 // AutoscalingGroup represents available metrics for one autoscaling group
 type AutoscalingGroup struct {
-	Name   string
-	Health HealthStatus
-	Tags   map[string]string
+	Name     string
+	Health   HealthStatus
+	Tags     map[string]string
+	Topology AutoscalingGroupTopology
 }
 
 // AutoscalingGroups represents a group of autoscaling groups
 type AutoscalingGroups = []*AutoscalingGroup
 
-// Pools represent lockable group of different types, that are accessed/modified by goroutines
+// NodegroupsAggregate represents the figures of the pools grouped by nodegroup.
+// It is computed once per iteration of a loop, and shared by the processes executed on it
+type NodegroupsAggregate struct {
+	Nodegroups         []string
+	NodesCount         map[string]int
+	CordonedNodesCount map[string]int
+	Events             map[string][]*v1.Event
+	EventsCount        map[string]int
+	RecentlyReadyNodes map[string][]*v1.Node
+	RecentlyReadyCount map[string]int
+}
 
-// AutoscalingGroupPool represents a group of autoscaling groups
-type AutoscalingGroupPool struct {
-	Lock              sync.Mutex
-	AutoscalingGroups AutoscalingGroups
+// DrainCandidate represents a node under risk selected to be drained, paired with the recently ready node replacing it
+type DrainCandidate struct {
+	Event           *v1.Event
+	ReplacementNode *v1.Node
 }
 
-// EventPool represents a list of events stored from Kubernetes to handle API server events' TTL
-type EventPool struct {
-	Lock   sync.Mutex
-	Events v1.EventList
+// TerminationRequest represents an instance waiting to be terminated after draining its node
+type TerminationRequest struct {
+	InstanceId           string
+	NodeName             string
+	AutoscalingGroupName string
+	Event                *v1.Event
+	WebhookPayload       *WebhookPayload
+
+	EnqueuedAt   time.Time
+	TerminatedAt time.Time
+
+	// TerminationSpan covers the termination on the trace of the node, until AWS confirms it
+	TerminationSpan trace.Span
+
+	// GivenUpReason is set when the instance can't be terminated, and the node is left drained
+	GivenUpReason string
+}
+
+// TerminationQueue represents a list of instances waiting to be terminated
+type TerminationQueue struct {
+	Lock     sync.Mutex
+	Requests []*TerminationRequest
 }
 
-// NodePool represents a list of nodes stored from Kubernetes
-type NodePool struct {
-	Lock  sync.Mutex
-	Nodes v1.NodeList
+// CircuitBreaker represents the state of the protection against runaway scaling
+// Boosts and suspicious events are tracked over a sliding window, per ASG and cluster-wide
+type CircuitBreaker struct {
+	Lock sync.Mutex
+
+	// Moments when boosts were applied
+	Boosts        map[string][]time.Time
+	ClusterBoosts []time.Time
+
+	// Moments when events were received from freshly launched nodes
+	FreshNodeEvents map[string][]time.Time
+
+	// Moments when the events were reviewed, by their UID, so they are counted only once
+	SeenEvents map[string]time.Time
+
+	// Moments when the breaker was tripped
+	Tripped        map[string]time.Time
+	ClusterTripped time.Time
+}
+
+// Reconciler represents the queue of synchronizations requested by the changes on the inputs of the controller
+type Reconciler struct {
+	Queue workqueue.DelayingInterface
+
+	// Debounce is the time a requested synchronization waits, so the changes arriving meanwhile are coalesced
+	Debounce time.Duration
+
+	Lock sync.Mutex
+
+	// Last desired capacity applied to each ASG, so AWS is only called when the target changes
+	AppliedCapacities map[string]int
+}
+
+// AutoscalingGroupTagsCache represents the tags of the ASGs described on AWS, kept until they expire to reduce the calls.
+// It is only used by the tags watcher
+type AutoscalingGroupTagsCache struct {
+	TTL time.Duration
+
+	// Tags grouped by the name of their ASG
+	Tags map[string]map[string]string
+
+	// Moments when the tags of each ASG were described
+	DescribedAt map[string]time.Time
+}
+
+// AwsRetryPolicy represents how the calls done to AWS API are rate limited and retried
+type AwsRetryPolicy struct {
+	// Rate of calls allowed per operation, and the burst allowed over it
+	QPS   float32
+	Burst int
+
+	// Retries allowed per call on throttling or contention, waiting an exponential backoff with jitter between them
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+// AwsRateLimiter represents the token buckets limiting the calls done to AWS API, one per operation
+type AwsRateLimiter struct {
+	Policy AwsRetryPolicy
+
+	Lock    sync.Mutex
+	Buckets map[string]flowcontrol.RateLimiter
+
+	// Random is used to add jitter to the backoffs, so the retries of concurrent calls are spread
+	Random *rand.Rand
+}
+
+// BoostBudgetTracker represents the boosts trimmed by the budgets, so a trim persisting over the loops is recorded once
+type BoostBudgetTracker struct {
+	Lock sync.Mutex
+
+	// Desired capacity left by the budgets the last time a trim was recorded, per ASG
+	Budgeted map[string]int
+}
+
+// ReplacementRiskTracker represents the state of the detection of replacement nodes receiving events too.
+// Replacement nodes are those launched by a boost, which are under risk again shortly after
+type ReplacementRiskTracker struct {
+	Lock sync.Mutex
+
+	// Moments when the events were reviewed, by their UID, so they are counted only once
+	SeenEvents map[string]time.Time
+
+	// Moments when boosts were applied, per ASG, to find the boosts that launched the replacement nodes.
+	// Kept apart from the circuit breaker, so resetting it doesn't forget them
+	Boosts map[string][]time.Time
+
+	// Moments when replacement nodes under risk were detected, per ASG
+	Occurrences map[string][]time.Time
+
+	// Instance types of the replacement nodes under risk, per ASG
+	InstanceTypes map[string]map[string]int
+
+	// Moment until the boosts are backed off, per ASG
+	BackoffUntil map[string]time.Time
 }
 
 // Controller stuff
@@ -60,13 +197,37 @@ type ControllerFlags struct {
 	Kubeconfig     *string
 	DryRun         *bool
 
+	// Pause controls
+	ControlConfigmapNamespace *string
+	ControlConfigmapName      *string
+
 	// C.Autoscaler status process
 	CAStatusNamespace *string
 	CAConfigmapName   *string
 
+	// Reconciliation
+	ReconcileDebounce *time.Duration
+	ResyncPeriod      *time.Duration
+
+	// AWS API calls
+	AwsApiQPS            *float64
+	AwsApiBurst          *int
+	AwsApiMaxRetries     *int
+	AwsApiRetryBaseDelay *time.Duration
+	AwsApiRetryMaxDelay  *time.Duration
+
 	// Cloud process
 	IgnoredAutoscalingGroups   *string
 	ExtraNodesOverCalculations *int
+	AllowUnbalancedASGs        *bool
+	AZImbalanceTolerance       *int
+	AutoscalingGroupTagsTTL    *time.Duration
+
+	// Budgets
+	MaxBoostedNodesPerASG  *int
+	MaxBoostedNodesCluster *int
+	HourlyCostBudget       *float64
+	InstancePricesFile     *string
 
 	// Drain process
 	DisableDrain            *bool
@@ -75,10 +236,98 @@ type ControllerFlags struct {
 	MaxConcurrentDrains     *int
 	IgnorePodsGracePeriod   *bool
 	MaxTimeConsiderNewNodes *time.Duration
+	WaitReplacementPods     *bool
+	ReplacementPodsTimeout  *time.Duration
+
+	// Termination process
+	TerminationsPerMinute     *int
+	TerminationConfirmTimeout *time.Duration
+
+	// Soft-cordon process
+	EnableSoftCordon          *bool
+	SoftCordonEscalationDelay *time.Duration
+
+	// Drain webhooks
+	PreDrainWebhookURL   *string
+	PostDrainWebhookURL  *string
+	WebhookTimeout       *time.Duration
+	WebhookRetries       *int
+	WebhookSecret        *string
+	WebhookFailurePolicy *string
+
+	// Circuit breaker
+	CircuitBreakerWindow              *time.Duration
+	CircuitBreakerCooldown            *time.Duration
+	CircuitBreakerMaxBoostsPerASG     *int
+	CircuitBreakerMaxBoostsCluster    *int
+	CircuitBreakerMaxFreshNodesEvents *int
+
+	// Replacements under risk
+	ReplacementLaunchWindow *time.Duration
+	ReplacementRiskWindow   *time.Duration
+	ReplacementRiskBackoff  *time.Duration
+	MaxReplacementsAtRisk   *int
+
+	// Audit log
+	AuditLogPath       *string
+	AuditLogMaxSizeMB  *int
+	AuditLogMaxBackups *int
+
+	// Kubernetes events
+	ControllerNamespace      *string
+	ControllerDeploymentName *string
+	KubernetesEventsBurst    *int
+	KubernetesEventsQPS      *float64
+
+	// Record mode
+	RecordDir          *string
+	RecordInterval     *time.Duration
+	RecordMaxSnapshots *int
+	RecordRedactNames  *bool
+	RecordRedactIPs    *bool
+
+	// Tracing
+	TracingOTLPEndpoint *string
+	TracingOTLPInsecure *bool
+	TracingSampleRatio  *float64
 
 	// Metrics
 	MetricsPort *string
 	MetricsHost *string
+
+	// Admin endpoints
+	AdminPort  *string
+	AdminHost  *string
+	AdminToken *string
+}
+
+// Plan represents the changes intended by the controller on dry-run
+type Plan struct {
+	Lock sync.Mutex `json:"-"`
+
+	Boosts          map[string]PlannedBoost `json:"boosts"`
+	BoostsUpdatedAt time.Time               `json:"boostsUpdatedAt"`
+	Drains          []PlannedDrain          `json:"drains"`
+	DrainsUpdatedAt time.Time               `json:"drainsUpdatedAt"`
+}
+
+// Snapshot represents the inputs seen by the controller at some moment, to be replayed offline.
+// Fields must only be added in a backwards compatible way, increasing SnapshotFormatVersion otherwise
+type Snapshot struct {
+	Version   int       `json:"version"`
+	Timestamp time.Time `json:"timestamp"`
+
+	Nodes                   []v1.Node                    `json:"nodes"`
+	Events                  []v1.Event                   `json:"events"`
+	ClusterAutoscalerStatus string                       `json:"clusterAutoscalerStatus"`
+	AutoscalingGroupTags    map[string]map[string]string `json:"autoscalingGroupTags"`
+}
+
+// AwsClient represents the clients of the AWS services used by the controller.
+// Interfaces are used so the services can be replaced, like by the fakes of the tests
+type AwsClient struct {
+	AutoScaling autoscalingiface.AutoScalingAPI
+	EC2         ec2iface.EC2API
 }
 
 // Ctx represents the main context of the controller
@@ -86,4 +335,31 @@ type Ctx struct {
 	Ctx    context.Context
 	Logger *zap.SugaredLogger
 	Flags  *ControllerFlags
+	Audit  *AuditLogger
+
+	// Recorder emits Kubernetes events about the actions of the controller
+	Recorder record.EventRecorder
+
+	// Traces stores the traces of the lifecycle of the nodes under risk
+	Traces *NodeTracePool
+
+	// Plan stores the changes intended on dry-run
+	Plan *Plan
+}
+
+// NodeTrace represents the trace of the lifecycle of a node under risk: detect, boost, drain and terminate
+type NodeTrace struct {
+	// Ctx carries the root span of the trace, to be used as parent of the spans of each phase
+	Ctx  context.Context
+	Span trace.Span
+
+	AutoscalingGroupName string
+	Calculated           bool
+	Boosted              bool
+}
+
+// NodeTracePool represents the traces of the nodes under risk, indexed by node name
+type NodeTracePool struct {
+	Lock   sync.Mutex
+	Traces map[string]*NodeTrace
 }
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// Webhook phases
+	PreDrainWebhookPhase  = "pre-drain"
+	PostDrainWebhookPhase = "post-drain"
+
+	// Webhook failure policies
+	WebhookFailurePolicyIgnore = "ignore"
+	WebhookFailurePolicyFail   = "fail"
+
+	// WebhookSignatureHeader is the header carrying the HMAC-SHA256 signature of the payload
+	WebhookSignatureHeader = "X-Asbooster-Signature"
+
+	// WebhookDefaultDelay is the time to wait when a webhook asks for a delay without Retry-After header
+	WebhookDefaultDelay = 10 * time.Second
+
+	// Info messages
+	WebhookDelayedMessage = "webhook '%s' asked to delay the drain of the node '%s' for %s"
+	WebhookVetoedMessage  = "webhook '%s' vetoed the drain of the node '%s', will be reviewed in the next loop"
+
+	// Error messages
+	WebhookRequestErrorMessage = "error calling webhook '%s' for the node '%s' (try %d): %v"
+	WebhookFailedErrorMessage  = "webhook '%s' failed for the node '%s' after all the retries: %v"
+)
+
+// WebhookDecision represents what a pre-drain webhook decided about a drain
+type WebhookDecision int
+
+const (
+	WebhookDecisionAllow WebhookDecision = iota
+	WebhookDecisionDelay
+	WebhookDecisionVeto
+)
+
+// WebhookPayload represents the body sent to the drain webhooks
+type WebhookPayload struct {
+	Phase     string `json:"phase"`
+	Node      string `json:"node"`
+	Instance  string `json:"instance"`
+	Nodegroup string `json:"nodegroup"`
+	Reason    string `json:"reason"`
+	Timestamp string `json:"timestamp"`
+}
+
+// WebhookResponse represents the result of a single webhook call
+type WebhookResponse struct {
+	Decision   WebhookDecision
+	RetryAfter time.Duration
+}
+
+// SignWebhookPayload return the hex-encoded HMAC-SHA256 of a payload using the given secret
+func SignWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// CallWebhook send the payload to a webhook once, and translate the response into a decision.
+// Status codes: 2xx allows the action, 409 vetoes it, 202/425/429 delays it (Retry-After header is honoured).
+// Any other status is returned as an error
+func CallWebhook(client *http.Client, url string, secret string, payload *WebhookPayload) (response WebhookResponse, err error) {
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return response, err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return response, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	// Sign the payload when a secret is configured
+	if secret != "" {
+		request.Header.Set(WebhookSignatureHeader, "sha256="+SignWebhookPayload(secret, body))
+	}
+
+	httpResponse, err := client.Do(request)
+	if err != nil {
+		return response, err
+	}
+	defer httpResponse.Body.Close()
+
+	switch {
+	case httpResponse.StatusCode == http.StatusAccepted ||
+		httpResponse.StatusCode == http.StatusTooEarly ||
+		httpResponse.StatusCode == http.StatusTooManyRequests:
+		response.Decision = WebhookDecisionDelay
+		response.RetryAfter = WebhookDefaultDelay
+
+		retryAfterSeconds, err := strconv.Atoi(httpResponse.Header.Get("Retry-After"))
+		if err == nil && retryAfterSeconds >= 0 {
+			response.RetryAfter = time.Duration(retryAfterSeconds) * time.Second
+		}
+
+	case httpResponse.StatusCode == http.StatusConflict:
+		response.Decision = WebhookDecisionVeto
+
+	case httpResponse.StatusCode >= 200 && httpResponse.StatusCode < 300:
+		response.Decision = WebhookDecisionAllow
+
+	default:
+		return response, fmt.Errorf("unexpected status code %d", httpResponse.StatusCode)
+	}
+
+	return response, nil
+}
+
+// CallWebhookWithRetries send the payload to a webhook, retrying on errors according to the flags.
+// When all the retries fail, the decision is taken according to the configured failure policy
+func CallWebhookWithRetries(ctx *Ctx, url string, payload *WebhookPayload) (response WebhookResponse) {
+
+	client := &http.Client{Timeout: *ctx.Flags.WebhookTimeout}
+
+	var err error
+	for try := 0; try <= *ctx.Flags.WebhookRetries; try++ {
+		response, err = CallWebhook(client, url, *ctx.Flags.WebhookSecret, payload)
+		if err == nil {
+			return response
+		}
+
+		ctx.Logger.Infof(WebhookRequestErrorMessage, payload.Phase, payload.Node, try, err)
+		if try < *ctx.Flags.WebhookRetries {
+			time.Sleep(time.Duration(try+1) * time.Second)
+		}
+	}
+
+	ctx.Logger.Infof(WebhookFailedErrorMessage, payload.Phase, payload.Node, err)
+
+	response = WebhookResponse{Decision: WebhookDecisionAllow}
+	if *ctx.Flags.WebhookFailurePolicy == WebhookFailurePolicyFail {
+		response.Decision = WebhookDecisionVeto
+	}
+
+	return response
+}
+
+// RunPreDrainWebhook call the pre-drain webhook until it allows or vetoes the drain.
+// Delays are honoured until the drain timeout is reached, then the drain is vetoed for this loop.
+// Returns true when the drain can continue
+func RunPreDrainWebhook(ctx *Ctx, payload *WebhookPayload) bool {
+
+	if *ctx.Flags.PreDrainWebhookURL == "" {
+		return true
+	}
+
+	payload.Phase = PreDrainWebhookPhase
+	deadline := time.Now().Add(*ctx.Flags.DrainTimeout)
+
+	for {
+		payload.Timestamp = time.Now().Format(time.RFC3339)
+		response := CallWebhookWithRetries(ctx, *ctx.Flags.PreDrainWebhookURL, payload)
+
+		switch response.Decision {
+		case WebhookDecisionAllow:
+			return true
+
+		case WebhookDecisionDelay:
+			if time.Now().Add(response.RetryAfter).After(deadline) {
+				ctx.Logger.Infof(WebhookVetoedMessage, payload.Phase, payload.Node)
+				return false
+			}
+			ctx.Logger.Infof(WebhookDelayedMessage, payload.Phase, payload.Node, response.RetryAfter)
+			time.Sleep(response.RetryAfter)
+
+		default:
+			ctx.Logger.Infof(WebhookVetoedMessage, payload.Phase, payload.Node)
+			return false
+		}
+	}
+}
+
+// RunPostDrainWebhook notify the post-drain webhook that the node was terminated. Its decision is ignored
+func RunPostDrainWebhook(ctx *Ctx, payload *WebhookPayload) {
+
+	if *ctx.Flags.PostDrainWebhookURL == "" {
+		return
+	}
+
+	payload.Phase = PostDrainWebhookPhase
+	payload.Timestamp = time.Now().Format(time.RFC3339)
+	CallWebhookWithRetries(ctx, *ctx.Flags.PostDrainWebhookURL, payload)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newWebhookServer return a server answering each call with the status returned by a handler, given the number of the call
+func newWebhookServer(t *testing.T, respond func(call int32, w http.ResponseWriter, r *http.Request)) (*httptest.Server, *atomic.Int32) {
+	calls := &atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		respond(calls.Add(1), w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server, calls
+}
+
+func TestRunPreDrainWebhookDecisions(t *testing.T) {
+	tests := []struct {
+		name          string
+		args          []string
+		statuses      []int
+		expectedAllow bool
+		expectedCalls int32
+	}{
+		{"allowed", nil, []int{http.StatusOK}, true, 1},
+		{"delayed then allowed", nil, []int{http.StatusTooManyRequests, http.StatusAccepted, http.StatusOK}, true, 3},
+		{"vetoed", nil, []int{http.StatusConflict}, false, 1},
+		{"retried on errors", []string{"--webhook-retries", "1"}, []int{http.StatusInternalServerError, http.StatusOK}, true, 2},
+		{"failing ignored", []string{"--webhook-retries", "0"}, []int{http.StatusInternalServerError}, true, 1},
+		{"failing vetoed by policy", []string{"--webhook-retries", "0", "--webhook-failure-policy", "fail"}, []int{http.StatusBadGateway}, false, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, calls := newWebhookServer(t, func(call int32, w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(test.statuses[int(call)-1])
+			})
+
+			h := NewHarness(t, append([]string{"--pre-drain-webhook-url", server.URL}, test.args...)...)
+			allowed := RunPreDrainWebhook(h.Ctx, &WebhookPayload{Node: "node-1"})
+
+			if allowed != test.expectedAllow {
+				t.Errorf("expected allowed %t, got %t", test.expectedAllow, allowed)
+			}
+			if calls.Load() != test.expectedCalls {
+				t.Errorf("expected %d calls, got %d", test.expectedCalls, calls.Load())
+			}
+		})
+	}
+}
+
+func TestRunPreDrainWebhookDelayPastTimeout(t *testing.T) {
+	server, calls := newWebhookServer(t, func(call int32, w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooEarly)
+	})
+
+	h := NewHarness(t, "--pre-drain-webhook-url", server.URL, "--drain-timeout", "10s")
+	if RunPreDrainWebhook(h.Ctx, &WebhookPayload{Node: "node-1"}) {
+		t.Errorf("a delay past the drain timeout allowed the drain")
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected 1 call, got %d", calls.Load())
+	}
+}
+
+func TestCallWebhookSignsPayload(t *testing.T) {
+	var body []byte
+	var signature string
+	server, _ := newWebhookServer(t, func(call int32, w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		signature = r.Header.Get(WebhookSignatureHeader)
+	})
+
+	payload := &WebhookPayload{Phase: PreDrainWebhookPhase, Node: "node-1", Instance: "i-1"}
+	_, err := CallWebhook(&http.Client{Timeout: time.Second}, server.URL, "s3cr3t", payload)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var received WebhookPayload
+	if err := json.Unmarshal(body, &received); err != nil || received != *payload {
+		t.Errorf("unexpected payload received: %s", body)
+	}
+	if expected := "sha256=" + SignWebhookPayload("s3cr3t", body); signature != expected {
+		t.Errorf("expected signature '%s', got '%s'", expected, signature)
+	}
+
+	// Payloads are not signed without a secret
+	_, err = CallWebhook(&http.Client{Timeout: time.Second}, server.URL, "", payload)
+	if err != nil || signature != "" {
+		t.Errorf("unexpected signature without secret: '%s' (%v)", signature, err)
+	}
+}
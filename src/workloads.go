@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"time"
+)
+
+const (
+	// Kinds of the workloads whose replacement pods are waited for
+	DeploymentKind  = "Deployment"
+	StatefulSetKind = "StatefulSet"
+	ReplicaSetKind  = "ReplicaSet"
+
+	// WorkloadsAvailabilityCheckInterval is the time between checks of the workloads' availability
+	WorkloadsAvailabilityCheckInterval = 5 * time.Second
+
+	// Info messages
+	WaitingWorkloadsMessage   = "waiting for %d workloads evicted from the node '%s' to be available"
+	WorkloadsAvailableMessage = "workloads evicted from the node '%s' are available again"
+
+	// Error messages
+	WorkloadsTimeoutErrorMessage = "timeout waiting for workloads evicted from the node '%s': %v"
+	WorkloadOwnerErrorMessage    = "impossible to get the owner of the pod '%s/%s': %v"
+)
+
+// WorkloadReference represents a workload which owns some pods
+type WorkloadReference struct {
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+// GetWorkloadsFromPods return the Deployments, StatefulSets and ReplicaSets owning a list of pods, without duplicates.
+// ReplicaSets owned by a Deployment are replaced by the Deployment
+func GetWorkloadsFromPods(ctx *Ctx, client kubernetes.Interface, pods []v1.Pod) (workloads []WorkloadReference) {
+
+	found := map[WorkloadReference]bool{}
+
+	for _, pod := range pods {
+		owner := metav1.GetControllerOf(&pod)
+		if owner == nil {
+			continue
+		}
+
+		workload := WorkloadReference{Kind: owner.Kind, Namespace: pod.Namespace, Name: owner.Name}
+
+		switch owner.Kind {
+		case ReplicaSetKind:
+			replicaSet, err := client.AppsV1().ReplicaSets(pod.Namespace).Get(context.TODO(), owner.Name, metav1.GetOptions{})
+			if err != nil {
+				ctx.Logger.Infof(WorkloadOwnerErrorMessage, pod.Namespace, pod.Name, err)
+				continue
+			}
+
+			// Prefer the Deployment when the ReplicaSet is managed by one
+			deploymentOwner := metav1.GetControllerOf(replicaSet)
+			if deploymentOwner != nil && deploymentOwner.Kind == DeploymentKind {
+				workload = WorkloadReference{Kind: DeploymentKind, Namespace: pod.Namespace, Name: deploymentOwner.Name}
+			}
+
+		case StatefulSetKind:
+
+		// Other owners (DaemonSets, Jobs, etc) are not waited for
+		default:
+			continue
+		}
+
+		if !found[workload] {
+			found[workload] = true
+			workloads = append(workloads, workload)
+		}
+	}
+
+	return workloads
+}
+
+// IsWorkloadAvailable return true when a workload reports the desired number of available replicas
+func IsWorkloadAvailable(client kubernetes.Interface, workload WorkloadReference) (available bool, err error) {
+
+	var desiredReplicas int32 = 1
+	var availableReplicas int32
+
+	switch workload.Kind {
+	case DeploymentKind:
+		deployment, err := client.AppsV1().Deployments(workload.Namespace).Get(context.TODO(), workload.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		if deployment.Spec.Replicas != nil {
+			desiredReplicas = *deployment.Spec.Replicas
+		}
+		availableReplicas = deployment.Status.AvailableReplicas
+
+	case StatefulSetKind:
+		statefulSet, err := client.AppsV1().StatefulSets(workload.Namespace).Get(context.TODO(), workload.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		if statefulSet.Spec.Replicas != nil {
+			desiredReplicas = *statefulSet.Spec.Replicas
+		}
+		availableReplicas = statefulSet.Status.AvailableReplicas
+
+	case ReplicaSetKind:
+		replicaSet, err := client.AppsV1().ReplicaSets(workload.Namespace).Get(context.TODO(), workload.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		if replicaSet.Spec.Replicas != nil {
+			desiredReplicas = *replicaSet.Spec.Replicas
+		}
+		availableReplicas = replicaSet.Status.AvailableReplicas
+
+	default:
+		return false, fmt.Errorf("unsupported workload kind '%s'", workload.Kind)
+	}
+
+	return availableReplicas >= desiredReplicas, nil
+}
+
+// WaitForWorkloadsAvailable block until all the workloads report the desired number of available replicas,
+// or the timeout is reached
+func WaitForWorkloadsAvailable(client kubernetes.Interface, workloads []WorkloadReference, timeout time.Duration) (err error) {
+
+	deadline := time.Now().Add(timeout)
+	pending := workloads
+
+	for {
+		var stillPending []WorkloadReference
+		for _, workload := range pending {
+			available, err := IsWorkloadAvailable(client, workload)
+			if err != nil || !available {
+				stillPending = append(stillPending, workload)
+			}
+		}
+
+		pending = stillPending
+		if len(pending) == 0 {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%d workloads still unavailable: %v", len(pending), pending)
+		}
+
+		time.Sleep(WorkloadsAvailabilityCheckInterval)
+	}
+}